@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"memory-feast-online/pkg/client"
+)
+
+// errQuit ends the session
+var errQuit = errors.New("quit")
+
+const help = `Lobby
+  queue                      join random matchmaking
+  create [settings]          open an invite room
+  join CODE                  take the free seat of room CODE
+  watch CODE                 spectate room CODE
+  ready, unready             set your ready flag in the lobby
+  settings [settings]        change the lobby settings (host only)
+  kick                       remove the joiner (host only)
+Game
+  N                          act on plate N: place, select or add by phase
+  place N, select N, add N   the same, spelled out
+  confirm                    reveal the two selected plates
+  leave                      leave the queue, lobby or game
+  rematch, accept, decline   ask for or answer a rematch
+  takeover                   move a session open elsewhere here
+Debugging
+  send TYPE [JSON]           send any message, e.g. send ready {"ready":true}
+  help, quit
+
+Settings are plates=N ruleset=NAME first=random|alternate|lower_rated.`
+
+// runCommand runs one input line against c, writing help to w
+func runCommand(w io.Writer, c *client.Client, nickname, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	name, args := strings.ToLower(fields[0]), fields[1:]
+
+	// A bare number acts on that plate
+	if _, err := strconv.Atoi(name); err == nil {
+		return playPlate(c, name)
+	}
+
+	switch name {
+	case "help", "?":
+		fmt.Fprintln(w, help)
+		return nil
+	case "quit", "exit":
+		return errQuit
+	case "queue":
+		return c.JoinQueue(nickname)
+	case "create":
+		settings, err := parseSettings(args)
+		if err != nil {
+			return err
+		}
+		return c.CreateRoom(nickname, settings)
+	case "join":
+		if len(args) != 1 {
+			return errors.New("usage: join CODE")
+		}
+		return c.JoinRoom(nickname, args[0])
+	case "watch":
+		if len(args) != 1 {
+			return errors.New("usage: watch CODE")
+		}
+		return c.Spectate(args[0])
+	case "ready", "unready":
+		return c.Ready(name == "ready")
+	case "settings":
+		settings, err := parseSettings(args)
+		if err != nil {
+			return err
+		}
+		return c.UpdateSettings(settings)
+	case "kick":
+		return c.Kick()
+	case "place", "select", "add":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s N", name)
+		}
+		plate, err := plateIndex(args[0])
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "place":
+			return c.Place(plate)
+		case "select":
+			return c.Select(plate)
+		}
+		return c.Add(plate)
+	case "confirm":
+		return c.Confirm()
+	case "leave":
+		return c.Leave()
+	case "rematch":
+		return c.RequestRematch()
+	case "accept", "decline":
+		return c.RespondRematch(name == "accept")
+	case "takeover":
+		return c.Takeover()
+	case "send":
+		return sendRaw(c, args)
+	}
+	return fmt.Errorf("unknown command %q; type help", name)
+}
+
+// playPlate sends what clicking plate number does in the web client
+func playPlate(c *client.Client, number string) error {
+	plate, err := plateIndex(number)
+	if err != nil {
+		return err
+	}
+	st := c.State()
+	if st == nil {
+		return errors.New("no game yet")
+	}
+	switch st.Phase {
+	case "placement":
+		return c.Place(plate)
+	case "matching":
+		return c.Select(plate)
+	case "add_token":
+		return c.Add(plate)
+	}
+	return fmt.Errorf("plates cannot be played in the %s phase", st.Phase)
+}
+
+// plateIndex turns a plate number as shown, from 1, into its index
+func plateIndex(number string) (int, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid plate number %q", number)
+	}
+	return n - 1, nil
+}
+
+func parseSettings(args []string) (client.RoomSettings, error) {
+	var settings client.RoomSettings
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return settings, fmt.Errorf("invalid setting %q, want key=value", arg)
+		}
+		switch strings.ToLower(key) {
+		case "plates":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid plate count %q", value)
+			}
+			settings.PlateCount = n
+		case "ruleset":
+			settings.Ruleset = value
+		case "first":
+			settings.FirstPlayer = value
+		default:
+			return settings, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return settings, nil
+}
+
+// sendRaw sends a message by type with a literal JSON payload, which the
+// server validates as it would any client's
+func sendRaw(c *client.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: send TYPE [JSON]")
+	}
+	payload := json.RawMessage("{}")
+	if len(args) > 1 {
+		payload = json.RawMessage(strings.Join(args[1:], " "))
+		if !json.Valid(payload) {
+			return fmt.Errorf("invalid JSON payload %s", payload)
+		}
+	}
+	return c.Send(client.MessageType(args[0]), payload)
+}
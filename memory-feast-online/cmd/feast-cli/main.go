@@ -0,0 +1,197 @@
+// Command feast-cli plays the game from a terminal, for debugging game flow
+// without a browser. It reads one command per line from stdin; type help
+// for the list.
+//
+//	feast-cli -name Alice            # then: queue, create, join CODE, ...
+//	feast-cli -name Bob -raw         # print every protocol message instead
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"memory-feast-online/pkg/client"
+)
+
+const logLines = 8 // Event lines kept under the board when redrawing
+
+func main() {
+	endpoint := flag.String("url", "ws://localhost:8080/ws", "server WebSocket endpoint")
+	nickname := flag.String("name", "cli", "nickname to play as")
+	sessionID := flag.String("session", "", "session ID to play as, random when empty")
+	origin := flag.String("origin", "", "Origin header, for servers with ALLOWED_WS_ORIGINS")
+	raw := flag.Bool("raw", false, "print every protocol message as sent and received instead of drawing the board")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := &console{w: os.Stdout}
+	opts := &client.Options{SessionID: *sessionID}
+	if *origin != "" {
+		opts.Header = http.Header{"Origin": {*origin}}
+	}
+	if *raw {
+		opts.Trace = out.trace
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, err := client.Dial(dialCtx, *endpoint, opts)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	scr := &screen{out: out, seat: c.PlayerIndex, raw: *raw, redraw: !*raw && isTerminal(os.Stdout)}
+	lines := readLines(os.Stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-c.Events():
+			if !ok {
+				log.Fatal("connection closed")
+			}
+			scr.show(ev)
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			err := runCommand(out, c, *nickname, line)
+			if errors.Is(err, errQuit) {
+				return
+			}
+			if err != nil {
+				scr.note(err.Error())
+			}
+		}
+	}
+}
+
+// readLines delivers stdin's lines until it ends
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// console serializes output from the event loop and the client's reader
+type console struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(p)
+}
+
+// trace prints a frame in raw mode
+func (c *console) trace(sent bool, frame []byte) {
+	arrow := "<-"
+	if sent {
+		arrow = "->"
+	}
+	fmt.Fprintf(c, "%s %s %s\n", time.Now().Format("15:04:05.000"), arrow, frame)
+}
+
+// screen shows the client's events. On a terminal it redraws the board or
+// lobby with the latest event lines under it; otherwise it appends, so the
+// output can be piped to a file.
+type screen struct {
+	out    io.Writer
+	seat   func() int
+	raw    bool // Frames are traced; only show what is not a frame
+	redraw bool
+
+	view func(io.Writer) // The game or lobby, nil outside a room
+	log  []string
+}
+
+func (s *screen) show(ev client.Event) {
+	if s.raw {
+		if ev.Raw == nil {
+			s.note(describe(ev))
+		}
+		return
+	}
+
+	changed := true
+	switch p := ev.Payload.(type) {
+	case client.GameState:
+		s.view = func(w io.Writer) { renderGame(w, &p, s.seat()) }
+	case client.LobbyState:
+		s.view = func(w io.Writer) { renderLobby(w, p) }
+	case client.RoomClosed, client.QueueTimeout:
+		s.view = nil
+	default:
+		changed = false
+	}
+
+	line := describe(ev)
+	if s.redraw {
+		if line != "" {
+			s.log = append(s.log, line)
+		}
+		s.draw()
+		return
+	}
+	if changed && s.view != nil {
+		fmt.Fprintln(s.out)
+		s.view(s.out)
+	}
+	if line != "" {
+		fmt.Fprintln(s.out, line)
+	}
+}
+
+// note shows a line that is not from the server, such as a command error
+func (s *screen) note(line string) {
+	if !s.redraw {
+		fmt.Fprintln(s.out, line)
+		return
+	}
+	s.log = append(s.log, line)
+	s.draw()
+}
+
+// draw clears the terminal and draws the view, the latest log lines and a
+// prompt
+func (s *screen) draw() {
+	if len(s.log) > logLines {
+		s.log = s.log[len(s.log)-logLines:]
+	}
+	fmt.Fprint(s.out, "\x1b[H\x1b[2J")
+	if s.view != nil {
+		s.view(s.out)
+		fmt.Fprintln(s.out)
+	}
+	for _, line := range s.log {
+		fmt.Fprintln(s.out, line)
+	}
+	fmt.Fprint(s.out, "> ")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"memory-feast-online/internal/ws"
+	"memory-feast-online/pkg/client"
+)
+
+func TestRenderGameMarksPlates(t *testing.T) {
+	last := 5
+	st := &client.GameState{
+		Phase:                  "matching",
+		CurrentTurn:            1,
+		Players:                []client.PlayerInfo{{Nickname: "Alice", Tokens: 3, IsConnected: true}, {Nickname: "Bob", Tokens: 4}},
+		Plates:                 make([]client.PlateInfo, 8),
+		SelectedPlates:         []int{0},
+		OpponentSelectedPlates: []int{2},
+		MatchedPlates:          []int{3},
+		LastActionPlate:        &last,
+	}
+	for i := range st.Plates {
+		st.Plates[i] = client.PlateInfo{Tokens: i, Covered: i != 4, HasTokens: true}
+	}
+
+	var out strings.Builder
+	renderGame(&out, st, 1)
+	got := out.String()
+	for _, want := range []string{
+		"Bob           4 tokens <- turn (you)",
+		"Alice         3 tokens\n",
+		" 1[##]*",
+		" 3[##]+",
+		" 4[##]=",
+		" 5[ 4]",
+		" 6[##]!",
+		"Your turn: type two plate numbers",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in\n%s", want, got)
+		}
+	}
+	// 8 plates take two rows of four, as in the web client
+	if rows := strings.Count(got, "[##]") + strings.Count(got, "[ 4]"); rows != 8 {
+		t.Fatalf("expected 8 plates, got %d", rows)
+	}
+	if !strings.Contains(got, " 4[##]=\n") {
+		t.Fatalf("expected a row break after plate 4 in\n%s", got)
+	}
+
+	out.Reset()
+	renderGame(&out, st, -1)
+	if !strings.Contains(out.String(), "Spectating.") {
+		t.Fatalf("expected a spectator hint, got\n%s", out.String())
+	}
+}
+
+func TestRunCommandSendsMessages(t *testing.T) {
+	// The stub server starts a matching phase game on connect
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, _ := ws.NewMessage(ws.MsgGameState, ws.GameStatePayload{Phase: "matching", Plates: make([]ws.PlateInfo, 8)})
+		conn.WriteJSON(msg)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var sent []ws.Message
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &client.Options{
+		Trace: func(isSent bool, frame []byte) {
+			var msg ws.Message
+			if isSent && json.Unmarshal(frame, &msg) == nil {
+				mu.Lock()
+				sent = append(sent, msg)
+				mu.Unlock()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.WaitFor(ctx, client.MsgGameState); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"2", "confirm", "join abc123", "create plates=8 first=alternate", `send ready {"ready": true}`} {
+		if err := runCommand(io.Discard, c, "Alice", line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	want := []string{
+		`select_plate {"index":1}`,
+		`confirm_match {}`,
+		`join_room {"nickname":"Alice","sessionId":"` + c.SessionID() + `","roomCode":"abc123"}`,
+		`create_room {"nickname":"Alice","sessionId":"` + c.SessionID() + `","plateCount":8,"firstPlayer":"alternate"}`,
+		`ready {"ready":true}`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(sent))
+	}
+	for i, msg := range sent {
+		if got := string(msg.Type) + " " + string(msg.Payload); got != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got)
+		}
+	}
+
+	for _, line := range []string{"place 0", "join", "create plates", "send ready {", "dance"} {
+		if err := runCommand(io.Discard, c, "Alice", line); err == nil {
+			t.Errorf("%s: expected an error", line)
+		}
+	}
+	if err := runCommand(io.Discard, c, "Alice", "quit"); err != errQuit {
+		t.Fatalf("expected quit, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"memory-feast-online/pkg/client"
+)
+
+// plateColumns matches the web client's grid
+func plateColumns(plates int) int {
+	switch {
+	case plates <= 4:
+		return 2
+	case plates <= 12:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// renderGame draws the game as seat sees it. Plates are numbered from 1,
+// as in the web client.
+func renderGame(w io.Writer, st *client.GameState, seat int) {
+	round := ""
+	if st.Phase == "placement" {
+		round = fmt.Sprintf("  round %d/%d", st.PlacementRound, st.MaxRound)
+	}
+	fmt.Fprintf(w, "%s%s  %ds left\n", st.Phase, round, st.TimeLeft)
+
+	for i, p := range st.Players {
+		marks := ""
+		if i == st.CurrentTurn && st.Phase != "finished" {
+			marks += " <- turn"
+		}
+		if i == seat {
+			marks += " (you)"
+		}
+		if !p.IsConnected {
+			marks += " (disconnected)"
+		}
+		fmt.Fprintf(w, "  %-12s %2d tokens%s\n", p.Nickname, p.Tokens, marks)
+	}
+	fmt.Fprintln(w)
+
+	columns := plateColumns(len(st.Plates))
+	var row strings.Builder
+	for i, plate := range st.Plates {
+		fmt.Fprintf(&row, "  %2d[%s]%-2s", i+1, plateBody(plate), plateMarks(st, i))
+		if (i+1)%columns == 0 || i == len(st.Plates)-1 {
+			fmt.Fprintln(w, strings.TrimRight(row.String(), " "))
+			row.Reset()
+		}
+	}
+	fmt.Fprintln(w, "  ## covered  * your pick  + opponent's pick  = matched  ! last move")
+	fmt.Fprintln(w)
+
+	if st.Message != "" {
+		fmt.Fprintf(w, "%s\n", st.Message)
+	}
+	fmt.Fprintln(w, gameHint(st, seat))
+}
+
+func plateBody(plate client.PlateInfo) string {
+	switch {
+	case plate.Covered:
+		return "##"
+	case !plate.HasTokens:
+		return "  "
+	default:
+		return fmt.Sprintf("%2d", plate.Tokens)
+	}
+}
+
+func plateMarks(st *client.GameState, i int) string {
+	var marks strings.Builder
+	if slices.Contains(st.SelectedPlates, i) {
+		marks.WriteByte('*')
+	}
+	if slices.Contains(st.OpponentSelectedPlates, i) {
+		marks.WriteByte('+')
+	}
+	if slices.Contains(st.MatchedPlates, i) {
+		marks.WriteByte('=')
+	}
+	if st.LastActionPlate != nil && *st.LastActionPlate == i {
+		marks.WriteByte('!')
+	}
+	return marks.String()
+}
+
+// gameHint says what a plate number does now
+func gameHint(st *client.GameState, seat int) string {
+	if st.Phase == "finished" {
+		return "Game over."
+	}
+	if seat < 0 {
+		return "Spectating."
+	}
+	if st.CurrentTurn != seat {
+		return "Waiting for the opponent."
+	}
+	switch st.Phase {
+	case "placement":
+		return "Your turn: type an empty plate's number to place a token."
+	case "matching":
+		return "Your turn: type two plate numbers to select them, then confirm."
+	case "add_token":
+		return "Your turn: type a matched plate's number to add a token."
+	}
+	return ""
+}
+
+// renderLobby draws an invite room's lobby
+func renderLobby(w io.Writer, lobby client.LobbyState) {
+	fmt.Fprintf(w, "Room %s  %d plates  ruleset %s  first player %s\n",
+		lobby.RoomCode, lobby.PlateCount, lobby.Ruleset, lobby.FirstPlayerPolicy)
+	for i, p := range lobby.Players {
+		if !p.Seated {
+			continue
+		}
+		marks := ""
+		if i == lobby.HostIndex {
+			marks += " (host)"
+		}
+		if p.Ready {
+			marks += " ready"
+		}
+		if !p.IsConnected {
+			marks += " (disconnected)"
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("  %-12s%s", p.Nickname, marks), " "))
+	}
+}
+
+// describe prints one line for an event that has no screen of its own, or
+// nothing
+func describe(ev client.Event) string {
+	switch p := ev.Payload.(type) {
+	case client.Connected:
+		if p.Resumed {
+			return "Reconnected."
+		}
+		return "Connected. Type help for commands."
+	case client.Disconnected:
+		if p.Retrying {
+			return fmt.Sprintf("Disconnected (%v), reconnecting...", p.Err)
+		}
+		return fmt.Sprintf("Disconnected: %v", p.Err)
+	case client.Error:
+		line := fmt.Sprintf("Error %s: %s", p.Code, p.Message)
+		for _, f := range p.Fields {
+			line += fmt.Sprintf("\n  %s: %s", f.Field, f.Message)
+		}
+		return line
+	case client.QueueJoined:
+		return fmt.Sprintf("In the queue at position %d.", p.Position)
+	case client.QueueTimeout:
+		return fmt.Sprintf("No opponent found in %ds; left the queue.", p.TimeoutSeconds)
+	case client.Matched:
+		if ev.Type == client.MsgRoomJoined {
+			return fmt.Sprintf("Joined room %s against %s.", p.RoomCode, p.Opponent)
+		}
+		return fmt.Sprintf("Matched against %s.", p.Opponent)
+	case client.RoomCreated:
+		return fmt.Sprintf("Created room %s; share the code to invite.", p.RoomCode)
+	case client.RoomClosed:
+		return fmt.Sprintf("Room closed: %s.", p.Reason)
+	case client.PlayerLeft:
+		return fmt.Sprintf("Player %d left; forfeit in %ds unless they return.", p.PlayerIndex+1, p.GracePeriod)
+	case client.Reconnected:
+		return fmt.Sprintf("Back in seat %d.", p.PlayerIndex+1)
+	case client.GameEnd:
+		line := "Draw"
+		if p.Winner > 0 {
+			line = p.WinnerName + " wins"
+		}
+		line += fmt.Sprintf(" (%s), final tokens %v.", p.Reason, p.FinalTokens)
+		if p.RematchTimeout > 0 {
+			line += fmt.Sprintf(" Type rematch within %ds to play again.", p.RematchTimeout)
+		}
+		return line
+	case client.RematchOffered:
+		return fmt.Sprintf("%s wants a rematch: accept or decline within %ds.", p.FromNickname, p.TimeoutSeconds)
+	case client.RematchDeclined:
+		return fmt.Sprintf("No rematch: %s.", p.Reason)
+	case client.SessionReplaced:
+		return "This session was opened elsewhere."
+	case client.SessionConflict:
+		if p.CanTakeover {
+			return "This session is open elsewhere; type takeover to move it here."
+		}
+		return "This session is open elsewhere."
+	case client.Spectating:
+		return fmt.Sprintf("Watching room %s.", p.RoomCode)
+	}
+	return ""
+}
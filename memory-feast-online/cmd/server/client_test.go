@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/store"
+	"memory-feast-online/internal/ws"
+	"memory-feast-online/pkg/client"
+)
+
+// newTestEndpoint serves s's WebSocket handler and returns its ws:// URL
+func newTestEndpoint(t *testing.T, s *Server) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.hub.Run(ctx)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func dialTestClient(t *testing.T, endpoint string) *client.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, endpoint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// waitEvent returns the client's next event of msgType within 2s
+func waitEvent(t *testing.T, c *client.Client, msgType client.MessageType) client.Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ev, err := c.WaitFor(ctx, msgType)
+	if err != nil {
+		t.Fatalf("expected %s for session %s: %v", msgType, c.SessionID(), err)
+	}
+	return ev
+}
+
+func TestClientPlaysAndReconnects(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	endpoint := newTestEndpoint(t, s)
+	alice := dialTestClient(t, endpoint)
+	bob := dialTestClient(t, endpoint)
+
+	if err := alice.JoinQueue("Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if queued := waitEvent(t, alice, client.MsgQueueJoined).Payload.(client.QueueJoined); queued.Position != 1 {
+		t.Fatalf("expected Alice first in the queue, got %d", queued.Position)
+	}
+	bob.JoinQueue("Bob")
+	matched := waitEvent(t, bob, client.MsgMatched).Payload.(client.Matched)
+	if matched.Opponent != "Alice" {
+		t.Fatalf("expected Bob matched with Alice, got %+v", matched)
+	}
+	waitEvent(t, alice, client.MsgMatched)
+
+	state := waitEvent(t, alice, client.MsgGameState).Payload.(client.GameState)
+	waitEvent(t, bob, client.MsgGameState)
+	if state.Phase != "placement" || len(state.Plates) == 0 {
+		t.Fatalf("expected the placement phase, got %+v", state)
+	}
+
+	// Whoever moves first places on plate 0
+	mover, other := alice, bob
+	if state.CurrentTurn != alice.PlayerIndex() {
+		mover, other = bob, alice
+	}
+	if err := mover.Place(0); err != nil {
+		t.Fatal(err)
+	}
+	placed := waitEvent(t, other, client.MsgGameState).Payload.(client.GameState)
+	if placed.LastActionPlate == nil || *placed.LastActionPlate != 0 {
+		t.Fatalf("expected the opponent to see the placement on plate 0, got %+v", placed.LastActionPlate)
+	}
+
+	// Bob's connection drops: the client redials and takes the seat back
+	seat := bob.PlayerIndex()
+	dropConnection(t, s, bob)
+	if ev := waitEvent(t, bob, client.EventConnected); !ev.Payload.(client.Connected).Resumed {
+		t.Fatal("expected a resumed connection")
+	}
+	if back := waitEvent(t, bob, client.MsgReconnected).Payload.(client.Reconnected); back.PlayerIndex != seat {
+		t.Fatalf("expected Bob back in seat %d, got %d", seat, back.PlayerIndex)
+	}
+	waitEvent(t, bob, client.MsgGameState)
+}
+
+// dropConnection closes c's socket on the server side, as a network drop
+// would
+func dropConnection(t *testing.T, s *Server, c *client.Client) {
+	t.Helper()
+	conn := s.hub.GetClient(c.SessionID())
+	if conn == nil {
+		t.Fatalf("expected session %s to be connected", c.SessionID())
+	}
+	conn.Conn.Close()
+}
+
+func TestClientCreatesAndJoinsRoomByCode(t *testing.T) {
+	endpoint := newTestEndpoint(t, NewServer(store.NewMemoryStore(), nil))
+	host := dialTestClient(t, endpoint)
+	guest := dialTestClient(t, endpoint)
+
+	if err := host.CreateRoom("Host", client.RoomSettings{PlateCount: 8}); err != nil {
+		t.Fatal(err)
+	}
+	created := waitEvent(t, host, client.MsgRoomCreated).Payload.(client.RoomCreated)
+
+	if err := guest.JoinRoom("Guest", created.RoomCode); err != nil {
+		t.Fatal(err)
+	}
+	lobby := waitEvent(t, guest, client.MsgLobbyState).Payload.(client.LobbyState)
+	if lobby.RoomCode != created.RoomCode || lobby.PlateCount != 8 || len(lobby.Players) != 2 {
+		t.Fatalf("expected the guest in the host's 8-plate lobby, got %+v", lobby)
+	}
+
+	stranger := dialTestClient(t, endpoint)
+	if err := stranger.JoinRoom("Stranger", "ZZZZZZ"); err != nil {
+		t.Fatal(err)
+	}
+	if failed := waitEvent(t, stranger, client.MsgError).Payload.(client.Error); failed.Code != "room_not_found" {
+		t.Fatalf("expected room_not_found for an unknown code, got %+v", failed)
+	}
+}
+
+func TestSecondTabResyncsOnReconnect(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	endpoint := newTestEndpoint(t, s)
+	alice := dialTestClient(t, endpoint)
+	bob := dialTestClient(t, endpoint)
+	alice.JoinQueue("Alice")
+	waitEvent(t, alice, client.MsgQueueJoined)
+	bob.JoinQueue("Bob")
+	waitEvent(t, alice, client.MsgGameState)
+	seat := alice.PlayerIndex()
+
+	// A second tab opens the same session and, like the web client, sends
+	// reconnect once connected
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	tab, err := client.Dial(ctx, endpoint, &client.Options{SessionID: alice.SessionID(), NoReconnect: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tab.Close()
+	waitEvent(t, alice, client.MsgSessionReplaced)
+	if err := tab.Send(ws.MsgReconnect, ws.ReconnectPayload{SessionID: tab.SessionID()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Taking the session over resyncs the tab, and so does its reconnect
+	for resyncs := 0; resyncs < 2; {
+		ev, err := tab.WaitFor(ctx, client.MsgReconnected, client.MsgError)
+		if err != nil {
+			t.Fatalf("expected two resyncs, got %d: %v", resyncs, err)
+		}
+		if failed, ok := ev.Payload.(client.Error); ok {
+			t.Fatalf("expected no error on reconnect, got %+v", failed)
+		}
+		if back := ev.Payload.(client.Reconnected); back.PlayerIndex != seat {
+			t.Fatalf("expected the tab in seat %d, got %d", seat, back.PlayerIndex)
+		}
+		resyncs++
+	}
+	if st := waitEvent(t, tab, client.MsgGameState).Payload.(client.GameState); st.Phase != "placement" {
+		t.Fatalf("expected the running game, got %s", st.Phase)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"memory-feast-online/internal/cluster"
+	"memory-feast-online/internal/game"
+	"memory-feast-online/internal/store"
+	"memory-feast-online/internal/ws"
+)
+
+// errInstanceGone stops relaying frames to an instance that stopped listening
+var errInstanceGone = errors.New("instance is not subscribed")
+
+const (
+	// clusterRetryMin and clusterRetryMax bound the wait between attempts
+	// to join the cluster after starting without Redis
+	clusterRetryMin = time.Second
+	clusterRetryMax = 30 * time.Second
+)
+
+// joinCluster makes the server reachable by the other instances on bus.
+// Rooms it creates are recorded in the store as owned by instanceID. queue,
+// if not nil, is the random matching queue shared with them; players
+// waiting in the local queue move to it.
+func (s *Server) joinCluster(ctx context.Context, instanceID string, bus cluster.Bus, queue game.QueueBackend) error {
+	s.clusterMu.Lock()
+	s.instanceID = instanceID
+	s.bus = bus
+	s.clusterMu.Unlock()
+
+	if err := bus.Subscribe(ctx, instanceID, s.handleEnvelope); err != nil {
+		s.clusterMu.Lock()
+		s.instanceID = ""
+		s.bus = nil
+		s.clusterMu.Unlock()
+		return err
+	}
+	if queue != nil {
+		s.requeue(s.matchmaker.SetBackend(queue, instanceID))
+	}
+	return nil
+}
+
+// joinClusterWhenUp retries joinCluster, backing off from clusterRetryMin
+// to clusterRetryMax, for an instance that started while Redis was down.
+// It returns once the server joined or ctx is done.
+func (s *Server) joinClusterWhenUp(ctx context.Context, instanceID string, bus cluster.Bus, queue game.QueueBackend) {
+	backoff := clusterRetryMin
+	for {
+		timer := s.clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		err := s.joinCluster(ctx, instanceID, bus, queue)
+		if err == nil {
+			log.Printf("Joined cluster as instance %s", instanceID)
+			return
+		}
+		backoff = min(2*backoff, clusterRetryMax)
+		log.Printf("failed to join cluster as %s, retrying in %s: %v", instanceID, backoff, err)
+	}
+}
+
+// requeue queues players again after the queue moved to another backend
+func (s *Server) requeue(entries []*game.QueueEntry) {
+	for _, entry := range entries {
+		position, room := s.matchmaker.Join(entry)
+		if room != nil {
+			s.announceMatch(room)
+			continue
+		}
+		if position > 0 {
+			continue
+		}
+		if client := s.hub.GetClient(entry.Player.SessionID); client != nil {
+			s.setState(client, ws.ClientLobby)
+			s.sendError(client, "queue_unavailable", "Random matching is unavailable, try again")
+		}
+	}
+}
+
+// member returns the instance ID and bus, or a nil bus while the server
+// runs alone
+func (s *Server) member() (string, cluster.Bus) {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	return s.instanceID, s.bus
+}
+
+// recordRoom stores that this instance runs the room and where its players
+// sit, so other instances can route joins and reconnects to it and a
+// durable store keeps them across restarts. The room is saved over the
+// version it last saved; a version conflict means another writer got to
+// the record first and is logged rather than overwritten.
+func (s *Server) recordRoom(room *game.Room) {
+	if s.store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	instanceID, _ := s.member()
+	err := room.SaveRecord(func(version int64) (int64, error) {
+		data := &store.RoomData{
+			ID:         room.ID,
+			Code:       room.Code,
+			Owner:      instanceID,
+			Version:    version,
+			PlateCount: room.Settings().PlateCount,
+			CreatedAt:  room.CreatedAt(),
+		}
+		for i := 0; i < 2; i++ {
+			p := room.GetPlayer(i)
+			if p == nil {
+				continue
+			}
+			data.Players = append(data.Players, store.PlayerData{ID: p.ID, Nickname: p.Nickname, SessionID: p.SessionID})
+			if err := s.store.SaveSession(ctx, p.SessionID, room.ID, i); err != nil {
+				log.Printf("failed to save session %s for room %s: %v", p.SessionID, room.ID, err)
+			}
+		}
+		if err := s.store.SaveRoom(ctx, data); err != nil {
+			return 0, err
+		}
+		return data.Version, nil
+	})
+	if err != nil {
+		log.Printf("failed to save room %s: %v", room.ID, err)
+	}
+}
+
+// relayMessage sends a client message on to the instance running the
+// session's room, reporting whether it did. A join, spectate or reconnect
+// for a room on another instance starts the relay; after that every message
+// but takeover follows it.
+func (s *Server) relayMessage(client *ws.Client, msg *ws.Message) bool {
+	if _, bus := s.member(); bus == nil || msg.Type == ws.MsgTakeover || s.hub.GetClient(client.SessionID) != client {
+		return false
+	}
+
+	if client.Remote == "" {
+		if target := s.relayTarget(client.SessionID); target != "" {
+			if !s.publish(target, &cluster.Envelope{Kind: cluster.KindForward, SessionID: client.SessionID, Message: msg}) {
+				s.dropRelay(client.SessionID, target)
+				s.sendError(client, "instance_unavailable", "The server running your game is unavailable")
+			}
+			return true
+		}
+	}
+
+	owner := s.remoteOwner(client, msg)
+	if owner == "" {
+		return false
+	}
+	if client.Remote != "" {
+		s.redirect(client, owner, msg)
+		return true
+	}
+	return s.startRelay(client, owner, msg)
+}
+
+// remoteOwner returns the other instance running the room a join, spectate
+// or reconnect message asks for, or "" when it is handled here
+func (s *Server) remoteOwner(client *ws.Client, msg *ws.Message) string {
+	route := s.router.Route(msg.Type)
+	if route == nil || !route.AllowedIn(client.GetState()) {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var room *store.RoomData
+	var err error
+	switch msg.Type {
+	case ws.MsgJoinRoom, ws.MsgSpectate:
+		var payload ws.SpectatePayload // Only the room code matters here
+		if !decodeRelayed(route, msg, &payload) || s.getRoomByCode(payload.RoomCode) != nil {
+			return ""
+		}
+		room, err = s.store.GetRoomByCode(ctx, payload.RoomCode)
+	case ws.MsgReconnect:
+		var payload ws.ReconnectPayload
+		if !decodeRelayed(route, msg, &payload) {
+			return ""
+		}
+		if seated, _ := s.findPlayerRoom(payload.SessionID); seated != nil {
+			return ""
+		}
+		var roomID string
+		if roomID, _, err = s.store.GetSession(ctx, payload.SessionID); err == nil && roomID != "" {
+			room, err = s.store.GetRoom(ctx, roomID)
+		}
+	default:
+		return ""
+	}
+
+	if err != nil {
+		log.Printf("failed to look up room owner for session %s: %v", client.SessionID, err)
+		return ""
+	}
+	if instanceID, _ := s.member(); room == nil || room.Owner == "" || room.Owner == instanceID {
+		return ""
+	}
+	return room.Owner
+}
+
+// decodeRelayed reads the fields of a payload the router has yet to check.
+// Invalid payloads are left to the router to reject.
+func decodeRelayed(route *ws.Route, msg *ws.Message, v any) bool {
+	normalized, fields := route.Schema.Check(msg.Payload)
+	if fields != nil {
+		return false
+	}
+	return json.Unmarshal(normalized, v) == nil
+}
+
+// startRelay relays a local session to the instance running its room,
+// starting with msg. Returns false, relaying nothing, if that instance is
+// gone.
+func (s *Server) startRelay(client *ws.Client, owner string, msg *ws.Message) bool {
+	s.setRelay(client.SessionID, owner)
+	if !s.publish(owner, &cluster.Envelope{Kind: cluster.KindForward, SessionID: client.SessionID, Message: msg}) {
+		s.dropRelay(client.SessionID, owner)
+		return false
+	}
+
+	// Playing elsewhere gives up a rematch window held here
+	s.endPostGame(client.SessionID)
+	return true
+}
+
+// redirect hands a relayed session back to the instance holding its socket,
+// which relays msg to owner itself, so relays never chain
+func (s *Server) redirect(proxy *ws.Client, owner string, msg *ws.Message) {
+	s.setState(proxy, ws.ClientLobby)
+	s.publish(proxy.Remote, &cluster.Envelope{
+		Kind:      cluster.KindRedirect,
+		SessionID: proxy.SessionID,
+		Message:   msg,
+		Target:    owner,
+	})
+	s.hub.Unregister(proxy)
+	proxy.CloseWith(nil)
+}
+
+// handleEnvelope acts on a message from another instance. A panic is
+// logged with the envelope's kind and the session's room and drops only
+// that envelope.
+func (s *Server) handleEnvelope(env *cluster.Envelope) {
+	defer s.recoverEnvelope(env)
+
+	switch env.Kind {
+	case cluster.KindForward:
+		s.handleRelayed(env)
+	case cluster.KindResume:
+		if proxy := s.proxyFor(env); proxy != nil {
+			s.resumeSession(proxy)
+		}
+	case cluster.KindDisconnect:
+		if proxy := s.proxyFor(env); proxy != nil {
+			s.dropProxy(proxy)
+		}
+	case cluster.KindFrame:
+		if c := s.hub.GetClient(env.SessionID); c != nil && c.Remote == "" {
+			c.Enqueue(env.FrameKind, env.Frame)
+		}
+	case cluster.KindClose:
+		if s.dropRelay(env.SessionID, env.From) {
+			if c := s.hub.GetClient(env.SessionID); c != nil && c.Remote == "" {
+				c.CloseWith(nil)
+			}
+		}
+	case cluster.KindRedirect:
+		s.followRedirect(env)
+	case cluster.KindMatched:
+		s.followMatch(env)
+	default:
+		log.Printf("Unknown envelope kind %s from instance %s", env.Kind, env.From)
+	}
+}
+
+// recoverEnvelope logs a panic raised while handling env
+func (s *Server) recoverEnvelope(env *cluster.Envelope) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	roomID := ""
+	if room, _ := s.findPlayerRoom(env.SessionID); room != nil {
+		roomID = room.ID
+	}
+	log.Printf("panic handling %s envelope from instance %s in room %q for session %s: %v\n%s", env.Kind, env.From, roomID, env.SessionID, p, debug.Stack())
+}
+
+// handleRelayed handles a message from a socket on another instance through
+// the session's remote client, creating it on the first message
+func (s *Server) handleRelayed(env *cluster.Envelope) {
+	if env.Message == nil {
+		return
+	}
+
+	proxy := s.proxyFor(env)
+	if proxy == nil {
+		proxy = ws.NewRemoteClient(s.hub, env.SessionID, env.From)
+		if err := s.hub.Takeover(proxy); err != nil {
+			log.Printf("failed to register relayed session %s: %v", env.SessionID, err)
+			return
+		}
+		// A relay this instance held for the session is over
+		if target := s.relayTarget(env.SessionID); target != "" && s.dropRelay(env.SessionID, target) {
+			s.publish(target, &cluster.Envelope{Kind: cluster.KindDisconnect, SessionID: env.SessionID})
+		}
+		go s.relayFrames(proxy)
+	}
+
+	s.handleMessage(proxy, env.Message)
+}
+
+// adoptQueued seats a player the queue matched from another instance
+// through a remote client, and has that instance relay the player here
+func (s *Server) adoptQueued(entry *game.QueueEntry) {
+	proxy := ws.NewRemoteClient(s.hub, entry.Player.SessionID, entry.Instance)
+	if err := s.hub.Takeover(proxy); err != nil {
+		log.Printf("failed to register matched session %s: %v", proxy.SessionID, err)
+	}
+	entry.Conn = proxy.Conn
+	entry.Player.SetConnection(proxy.Conn)
+
+	// Sent before any frame, so the socket is relayed here when they arrive.
+	// If the instance is gone the first frame fails and the player drops
+	// out like any disconnected one.
+	s.publish(entry.Instance, &cluster.Envelope{Kind: cluster.KindMatched, SessionID: proxy.SessionID})
+	go s.relayFrames(proxy)
+}
+
+// followMatch relays a queued local session to the instance that matched it
+func (s *Server) followMatch(env *cluster.Envelope) {
+	client := s.hub.GetClient(env.SessionID)
+	if client == nil || client.Remote != "" {
+		// The socket is gone: the seat waits out its grace period
+		s.publish(env.From, &cluster.Envelope{Kind: cluster.KindDisconnect, SessionID: env.SessionID})
+		return
+	}
+
+	// A relay through which the session queued is over
+	if target := s.relayTarget(env.SessionID); target != "" && target != env.From && s.dropRelay(env.SessionID, target) {
+		s.publish(target, &cluster.Envelope{Kind: cluster.KindDisconnect, SessionID: env.SessionID})
+	}
+	s.setRelay(env.SessionID, env.From)
+
+	// Back to the lobby here gives up the local queue entry
+	if client.GetState() == ws.ClientWaiting {
+		s.setState(client, ws.ClientLobby)
+	}
+}
+
+// proxyFor returns the remote client of a session relayed from the sender
+func (s *Server) proxyFor(env *cluster.Envelope) *ws.Client {
+	if c := s.hub.GetClient(env.SessionID); c != nil && c.Remote == env.From {
+		return c
+	}
+	return nil
+}
+
+// relayFrames sends a remote client's frames to the instance holding its
+// socket until the client closes, then has that instance close the socket
+func (s *Server) relayFrames(proxy *ws.Client) {
+	err := proxy.RelayPump(func(kind ws.FrameKind, data []byte) error {
+		env := &cluster.Envelope{Kind: cluster.KindFrame, SessionID: proxy.SessionID, FrameKind: kind, Frame: data}
+		if !s.publish(proxy.Remote, env) {
+			return errInstanceGone
+		}
+		return nil
+	})
+	if err != nil {
+		// Nobody holds the socket any more
+		s.dropProxy(proxy)
+		return
+	}
+	s.publish(proxy.Remote, &cluster.Envelope{Kind: cluster.KindClose, SessionID: proxy.SessionID})
+}
+
+// dropProxy handles a relayed session's socket going away like a local
+// disconnect
+func (s *Server) dropProxy(proxy *ws.Client) {
+	s.handleClientDisconnect(proxy)
+	s.hub.Unregister(proxy)
+	proxy.Close()
+}
+
+// followRedirect relays a local session to the instance its last one sent
+// it to, or handles the message here
+func (s *Server) followRedirect(env *cluster.Envelope) {
+	client := s.hub.GetClient(env.SessionID)
+	if client == nil || client.Remote != "" || env.Message == nil || !s.dropRelay(env.SessionID, env.From) {
+		return
+	}
+	if instanceID, _ := s.member(); env.Target != instanceID && s.startRelay(client, env.Target, env.Message) {
+		return
+	}
+	s.router.Dispatch(client, env.Message)
+}
+
+// publish sends an envelope to an instance, reporting whether it arrived
+func (s *Server) publish(instance string, env *cluster.Envelope) bool {
+	instanceID, bus := s.member()
+	if bus == nil {
+		return false
+	}
+	env.From = instanceID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := bus.Publish(ctx, instance, env)
+	if err != nil {
+		log.Printf("failed to publish %s for session %s to instance %s: %v", env.Kind, env.SessionID, instance, err)
+	}
+	return ok
+}
+
+// relayTarget returns the instance a local session is relayed to, or ""
+func (s *Server) relayTarget(sessionID string) string {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+	return s.relays[sessionID]
+}
+
+func (s *Server) setRelay(sessionID, instance string) {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+	s.relays[sessionID] = instance
+}
+
+// dropRelay ends a session's relay if it still goes to instance
+func (s *Server) dropRelay(sessionID, instance string) bool {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+	if s.relays[sessionID] != instance {
+		return false
+	}
+	delete(s.relays, sessionID)
+	return true
+}
@@ -0,0 +1,1580 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/cluster"
+	"memory-feast-online/internal/game"
+	"memory-feast-online/internal/store"
+	"memory-feast-online/internal/ws"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return isAllowedWebSocketOrigin(r)
+	},
+}
+
+func isAllowedWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	allowedOrigins := os.Getenv("ALLOWED_WS_ORIGINS")
+
+	if allowedOrigins == "" {
+		return isLocalOrigin(origin)
+	}
+
+	return isOriginAllowed(origin, allowedOrigins)
+}
+
+func isLocalOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Hostname() == "" {
+		return false
+	}
+
+	host := strings.ToLower(originURL.Hostname())
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+func isOriginAllowed(origin, allowedOrigins string) bool {
+	if origin == "" {
+		return false
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+		return false
+	}
+
+	normalizedOrigin := strings.ToLower(originURL.Scheme) + "://" + strings.ToLower(originURL.Host)
+
+	for _, candidate := range strings.Split(allowedOrigins, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" {
+			continue
+		}
+		// Wildcard entries are intentionally unsupported. Provide explicit origins.
+		if candidate == "*" {
+			continue
+		}
+
+		candidateURL, err := url.Parse(candidate)
+		if err != nil || candidateURL.Scheme == "" || candidateURL.Host == "" {
+			continue
+		}
+
+		normalizedCandidate := strings.ToLower(candidateURL.Scheme) + "://" + strings.ToLower(candidateURL.Host)
+		if normalizedOrigin == normalizedCandidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lobbyHostIndex is the seat of the player who created an invite room
+const lobbyHostIndex = 0
+
+// seat locates a session inside a room
+type seat struct {
+	room  *game.Room
+	index int
+}
+
+// rematchWindow is the post-game period in which the players of a finished
+// room can agree to play again
+type rematchWindow struct {
+	room      *game.Room // The finished room
+	requester int        // Player index who requested the rematch, or -1
+	timer     clock.Timer
+}
+
+// Server holds all server state
+type Server struct {
+	hub        *ws.Hub
+	matchmaker *game.Matchmaker
+	store      store.Store
+	codeAlloc  *game.CodeAllocator
+	clock      clock.Clock // Drives room, queue and server timers
+
+	// rooms, codes and sessions are kept consistent under roomsMu
+	rooms      map[string]*game.Room // room ID -> room
+	codes      map[string]*game.Room // invite code -> room
+	sessions   map[string]seat       // session ID -> room and player index
+	spectators map[string]*game.Room // session ID -> room it watches
+	roomsMu    sync.RWMutex
+
+	rematches map[string]*rematchWindow // session ID -> open rematch window
+	rematchMu sync.Mutex
+
+	history *game.PlayerHistory // Ratings and last first players for new games
+
+	router  *ws.Router  // Client message routes, see routes.go
+	metrics *ws.Metrics // Per message type counters
+
+	// Other instances sharing the store, see cluster.go. bus is nil when
+	// the server runs alone. An instance that started while Redis was down
+	// sets them once it joins, so they are read through member.
+	instanceID string
+	bus        cluster.Bus
+	clusterMu  sync.RWMutex
+	relays     map[string]string // Local session ID -> instance running its room
+	relayMu    sync.Mutex
+}
+
+// NewServer creates a new server instance. clk may be nil, in which case the
+// wall clock is used.
+func NewServer(st store.Store, clk clock.Clock) *Server {
+	s := &Server{
+		hub:        ws.NewHub(),
+		rooms:      make(map[string]*game.Room),
+		codes:      make(map[string]*game.Room),
+		sessions:   make(map[string]seat),
+		spectators: make(map[string]*game.Room),
+		rematches:  make(map[string]*rematchWindow),
+		relays:     make(map[string]string),
+		history:    game.NewPlayerHistory(),
+		store:      st,
+		clock:      clock.OrReal(clk),
+		metrics:    ws.NewMetrics(),
+	}
+	s.router = s.newRouter()
+	s.installStateHooks()
+
+	var reserver game.CodeReserver
+	if st != nil {
+		reserver = st
+	}
+	s.codeAlloc = game.NewCodeAllocator(reserver)
+
+	// Initialize matchmaker with callback
+	s.matchmaker = game.NewMatchmaker(
+		func(entry1, entry2 *game.QueueEntry) *game.Room {
+			plateCount := game.ClampPlateCount((entry1.PlateCount + entry2.PlateCount) / 2)
+
+			room := game.NewRoom(plateCount, s.clock)
+			room.Hub = s.hub
+			room.SetOnEmpty(func(roomID string) {
+				s.removeRoom(roomID)
+			})
+
+			// Add players. One queued through another instance is relayed
+			// from there.
+			for _, entry := range []*game.QueueEntry{entry1, entry2} {
+				if entry.Conn == nil && entry.Instance != "" {
+					s.adoptQueued(entry)
+				}
+				room.AddPlayer(entry.Player)
+			}
+
+			// Store the room
+			s.addRoom(room)
+
+			// Start the game
+			s.startGame(room)
+			s.runRoom(room)
+
+			return room
+		},
+		func(entry *game.QueueEntry) {
+			if entry == nil || entry.Player == nil {
+				return
+			}
+
+			client := s.hub.GetClient(entry.Player.SessionID)
+			if client == nil {
+				return
+			}
+
+			s.setState(client, ws.ClientLobby)
+
+			timeoutMsg, err := ws.NewMessage(ws.MsgQueueTimeout, ws.QueueTimeoutPayload{
+				TimeoutSeconds: int(game.QueueTimeout / time.Second),
+			})
+			if err != nil {
+				log.Printf("failed to create queue_timeout message for session %s: %v", entry.Player.SessionID, err)
+				return
+			}
+
+			if err := client.SendMessage(timeoutMsg); err != nil {
+				log.Printf("failed to send queue_timeout message for session %s: %v", entry.Player.SessionID, err)
+			}
+		},
+		s.clock,
+	)
+
+	return s
+}
+
+// addRoom registers a room and indexes its code and seated players.
+func (s *Server) addRoom(room *game.Room) {
+	s.roomsMu.Lock()
+	s.rooms[room.ID] = room
+	if room.Code != "" {
+		s.codes[room.Code] = room
+	}
+	for i := 0; i < 2; i++ {
+		if p := room.GetPlayer(i); p != nil {
+			s.sessions[p.SessionID] = seat{room: room, index: i}
+		}
+	}
+	s.roomsMu.Unlock()
+
+	s.recordRoom(room)
+}
+
+// joinRoom seats a player in a registered room and indexes the session in
+// the same critical section, so lookups never observe a half-joined player.
+func (s *Server) joinRoom(room *game.Room, player *game.Player) (int, error) {
+	s.roomsMu.Lock()
+	if s.rooms[room.ID] != room {
+		s.roomsMu.Unlock()
+		return -1, game.ErrRoomNotFound
+	}
+
+	index, err := room.AddPlayer(player)
+	if err != nil {
+		s.roomsMu.Unlock()
+		return -1, err
+	}
+	s.sessions[player.SessionID] = seat{room: room, index: index}
+	s.roomsMu.Unlock()
+
+	s.recordRoom(room)
+	return index, nil
+}
+
+// unindexRoomLocked drops every index entry pointing at room.
+// Callers must hold roomsMu.
+func (s *Server) unindexRoomLocked(room *game.Room) {
+	if s.codes[room.Code] == room {
+		delete(s.codes, room.Code)
+	}
+	for i := 0; i < 2; i++ {
+		p := room.GetPlayer(i)
+		if p == nil {
+			continue
+		}
+		if st, ok := s.sessions[p.SessionID]; ok && st.room == room {
+			delete(s.sessions, p.SessionID)
+		}
+	}
+}
+
+func (s *Server) removeRoom(roomID string) {
+	s.roomsMu.Lock()
+	room, ok := s.rooms[roomID]
+	if ok {
+		s.unindexRoomLocked(room)
+		delete(s.rooms, roomID)
+	}
+	s.roomsMu.Unlock()
+
+	if ok {
+		room.StopCodeExpiry()
+		room.Close()
+		s.releaseSpectators(room)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Free the invite code for reuse
+	if ok {
+		if err := s.codeAlloc.Release(ctx, room.Code, roomID); err != nil {
+			log.Printf("failed to release code %s for room %s: %v", room.Code, roomID, err)
+		}
+	}
+
+	// Also remove from store
+	if s.store != nil {
+		if err := s.store.DeleteRoom(ctx, roomID); err != nil {
+			log.Printf("failed to delete room %s from store: %v", roomID, err)
+		}
+	}
+
+	log.Printf("Room %s removed", roomID)
+}
+
+func (s *Server) getRoom(roomID string) *game.Room {
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+	return s.rooms[roomID]
+}
+
+func (s *Server) getRoomByCode(code string) *game.Room {
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+	return s.codes[code]
+}
+
+// handleWebSocket handles WebSocket connections
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	// Get session ID from query params or generate new one
+	sessionID := r.URL.Query().Get("sessionId")
+	if sessionID == "" {
+		sessionID = generateSessionID()
+	}
+
+	client := ws.NewClient(s.hub, conn, sessionID)
+	client.SetOnDisconnect(func(c *ws.Client) {
+		s.handleClientDisconnect(c)
+	})
+	replaced, err := s.hub.Register(client)
+	switch {
+	case err != nil:
+		log.Printf("session %s not registered: %v", sessionID, err)
+	case replaced:
+		// Opened in another tab: pick up where the old socket left off
+		s.resumeSession(client)
+	}
+
+	// Start write pump (includes ping/pong)
+	go client.WritePump()
+
+	// Read messages
+	client.ReadPump(func(c *ws.Client, msg *ws.Message) {
+		s.handleMessage(c, msg)
+	})
+}
+
+// handleMessage routes a client message through the middleware chain, or
+// relays it to the instance running the session's room
+func (s *Server) handleMessage(client *ws.Client, msg *ws.Message) {
+	if s.relayMessage(client, msg) {
+		return
+	}
+	s.router.Dispatch(client, msg)
+}
+
+func (s *Server) handleJoinQueue(client *ws.Client, payload ws.JoinQueuePayload) {
+	player := game.NewPlayer(client.SessionID, payload.Nickname, client.SessionID, client.Conn)
+
+	// Join queue (default 20 plates). A relayed session is queued under the
+	// instance holding its socket.
+	position, room := s.matchmaker.Join(&game.QueueEntry{
+		Player:     player,
+		Conn:       client.Conn,
+		PlateCount: 20,
+		Instance:   client.Remote,
+	})
+
+	if room != nil {
+		s.announceMatch(room)
+	} else if position == 0 {
+		s.sendError(client, "queue_unavailable", "Random matching is unavailable, try again")
+	} else {
+		// Added to queue - transition to Waiting
+		s.setState(client, ws.ClientWaiting)
+		queueMsg, err := ws.NewMessage(ws.MsgQueueJoined, ws.QueueJoinedPayload{
+			Position: position,
+		})
+		if err != nil {
+			log.Printf("failed to create queue_joined message for session %s: %v", client.SessionID, err)
+			return
+		}
+		client.SendMessage(queueMsg)
+	}
+}
+
+// announceMatch tells both players of a room the queue matched where they
+// sit, then sends the first game state
+func (s *Server) announceMatch(room *game.Room) {
+	for i := 0; i < 2; i++ {
+		p := room.GetPlayer(i)
+		if p != nil {
+			matchedMsg, err := ws.NewMessage(ws.MsgMatched, ws.MatchedPayload{
+				RoomID:      room.ID,
+				PlayerIndex: i,
+				Opponent:    room.GetOpponentNickname(i),
+			})
+			if err != nil {
+				log.Printf("failed to create matched message for player %d in room %s: %v", i, room.ID, err)
+				continue
+			}
+
+			c := s.hub.GetClient(p.SessionID)
+			if c != nil {
+				s.setState(c, ws.ClientInGame)
+				c.SendMessage(matchedMsg)
+			}
+		}
+	}
+
+	// Send initial game state
+	room.BroadcastStateWithMessage(firstPlayerMessage(room), "info")
+}
+
+func (s *Server) handleCreateRoom(client *ws.Client, payload ws.CreateRoomPayload) {
+	plateCount := payload.PlateCount
+	if plateCount == 0 {
+		plateCount = game.DefaultPlateCount
+	}
+	plateCount = game.ClampPlateCount(plateCount)
+
+	ruleset, ok := game.LookupRuleset(payload.Ruleset)
+	if !ok {
+		s.sendError(client, "invalid_ruleset", "Unknown ruleset "+payload.Ruleset)
+		return
+	}
+
+	policy, ok := game.ParseFirstPlayerPolicy(payload.FirstPlayer)
+	if !ok {
+		s.sendError(client, "invalid_first_player", "Unknown first player policy "+payload.FirstPlayer)
+		return
+	}
+
+	room := game.NewRoom(plateCount, s.clock)
+	room.Hub = s.hub
+	room.UpdateSettings(game.RoomSettings{
+		PlateCount:        plateCount,
+		Ruleset:           ruleset,
+		FirstPlayerPolicy: policy,
+	})
+	room.SetOnEmpty(func(roomID string) {
+		s.removeRoom(roomID)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	code, err := s.codeAlloc.Allocate(ctx, room.ID)
+	cancel()
+	if err != nil {
+		log.Printf("failed to allocate room code for room %s: %v", room.ID, err)
+		s.sendError(client, "create_failed", "Could not create room, please try again")
+		return
+	}
+	room.Code = code
+
+	player := game.NewPlayer(client.SessionID, payload.Nickname, client.SessionID, client.Conn)
+	room.AddPlayer(player)
+
+	s.addRoom(room)
+
+	// Abandoned waiting rooms give their code back
+	room.SetCodeExpiry(s.clock.AfterFunc(game.WaitingRoomCodeTTL, func() {
+		s.expireWaitingRoom(room)
+	}))
+
+	// Transition to Waiting state
+	s.setState(client, ws.ClientWaiting)
+
+	// Send room created message
+	createdMsg, err := ws.NewMessage(ws.MsgRoomCreated, ws.RoomCreatedPayload{
+		RoomID:   room.ID,
+		RoomCode: room.Code,
+	})
+	if err != nil {
+		log.Printf("failed to create room_created message for room %s: %v", room.ID, err)
+		return
+	}
+	client.SendMessage(createdMsg)
+
+	room.BroadcastLobbyState()
+}
+
+func (s *Server) handleJoinRoom(client *ws.Client, payload ws.JoinRoomPayload) {
+	room := s.getRoomByCode(payload.RoomCode)
+	if room == nil {
+		s.sendError(client, "room_not_found", "Room not found")
+		return
+	}
+
+	if room.IsFull() {
+		s.sendError(client, "room_full", "Room is full")
+		return
+	}
+
+	player := game.NewPlayer(client.SessionID, payload.Nickname, client.SessionID, client.Conn)
+	playerIndex, err := s.joinRoom(room, player)
+	if err != nil {
+		s.sendError(client, "join_failed", err.Error())
+		return
+	}
+
+	// Send joined message
+	joinedMsg, err := ws.NewMessage(ws.MsgRoomJoined, ws.MatchedPayload{
+		RoomID:      room.ID,
+		RoomCode:    room.Code,
+		PlayerIndex: playerIndex,
+		Opponent:    room.GetOpponentNickname(playerIndex),
+	})
+	if err != nil {
+		log.Printf("failed to create room_joined message for room %s: %v", room.ID, err)
+		return
+	}
+	client.SendMessage(joinedMsg)
+
+	// Both players stay in the lobby until they send ready
+	s.setState(client, ws.ClientWaiting)
+
+	// Let the host know who joined
+	opponentIndex := 1 - playerIndex
+	if p := room.GetPlayer(opponentIndex); p != nil {
+		matchedMsg, err := ws.NewMessage(ws.MsgMatched, ws.MatchedPayload{
+			RoomID:      room.ID,
+			RoomCode:    room.Code,
+			PlayerIndex: opponentIndex,
+			Opponent:    room.GetOpponentNickname(opponentIndex),
+		})
+		if err != nil {
+			log.Printf("failed to create matched message for player %d in room %s: %v", opponentIndex, room.ID, err)
+		} else if c := s.hub.GetClient(p.SessionID); c != nil {
+			c.SendMessage(matchedMsg)
+		}
+	}
+
+	room.BroadcastLobbyState()
+}
+
+func (s *Server) handleReady(client *ws.Client, payload ws.ReadyPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+
+	allReady, err := room.SetReady(playerIndex, payload.Ready)
+	if err != nil {
+		s.sendError(client, "invalid_action", "Cannot change ready state now")
+		return
+	}
+
+	if allReady {
+		s.startLobbyGame(room)
+		return
+	}
+	room.BroadcastLobbyState()
+}
+
+func (s *Server) handleUpdateSettings(client *ws.Client, payload ws.UpdateSettingsPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+	if playerIndex != lobbyHostIndex {
+		s.sendError(client, "not_host", "Only the room host can change settings")
+		return
+	}
+
+	settings := room.Settings()
+	if payload.PlateCount != 0 {
+		settings.PlateCount = payload.PlateCount
+	}
+
+	if payload.Ruleset != "" {
+		ruleset, ok := game.LookupRuleset(payload.Ruleset)
+		if !ok {
+			s.sendError(client, "invalid_ruleset", "Unknown ruleset "+payload.Ruleset)
+			return
+		}
+		settings.Ruleset = ruleset
+	}
+
+	if payload.FirstPlayer != "" {
+		policy, ok := game.ParseFirstPlayerPolicy(payload.FirstPlayer)
+		if !ok {
+			s.sendError(client, "invalid_first_player", "Unknown first player policy "+payload.FirstPlayer)
+			return
+		}
+		settings.FirstPlayerPolicy = policy
+	}
+
+	if err := room.UpdateSettings(settings); err != nil {
+		s.sendError(client, "invalid_action", "Settings can only change before the game starts")
+		return
+	}
+
+	room.BroadcastLobbyState()
+}
+
+func (s *Server) handleKickPlayer(client *ws.Client, _ ws.KickPlayerPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+	if playerIndex != lobbyHostIndex {
+		s.sendError(client, "not_host", "Only the room host can kick players")
+		return
+	}
+	if room.GetPhase() != game.PhaseWaiting {
+		s.sendError(client, "invalid_action", "Players can only be kicked before the game starts")
+		return
+	}
+
+	kicked := s.vacateSeat(room, 1-lobbyHostIndex)
+	if kicked == nil {
+		s.sendError(client, "invalid_action", "No player to kick")
+		return
+	}
+
+	s.sendRoomClosed(kicked.SessionID, "kicked")
+	room.BroadcastLobbyState()
+}
+
+// startLobbyGame starts an invite room once both players are ready
+func (s *Server) startLobbyGame(room *game.Room) {
+	room.StopCodeExpiry()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := s.codeAlloc.Retain(ctx, room.Code, room.ID, game.ActiveRoomCodeTTL); err != nil {
+		log.Printf("failed to retain code %s for room %s: %v", room.Code, room.ID, err)
+	}
+	cancel()
+
+	s.startGame(room)
+	s.runRoom(room)
+
+	for i := 0; i < 2; i++ {
+		if p := room.GetPlayer(i); p != nil {
+			if c := s.hub.GetClient(p.SessionID); c != nil {
+				s.setState(c, ws.ClientInGame)
+			}
+		}
+	}
+
+	room.BroadcastStateWithMessage(firstPlayerMessage(room), "info")
+}
+
+// firstPlayerMessage announces who starts the game and which policy chose them
+func firstPlayerMessage(room *game.Room) string {
+	first := room.GetFirstPlayer()
+	name := fmt.Sprintf("플레이어 %d", first+1)
+	if p := room.GetPlayer(first); p != nil {
+		name = p.Nickname
+	}
+
+	var reason string
+	switch room.Settings().FirstPlayerPolicy {
+	case game.FirstPlayerAlternate:
+		reason = "번갈아 선공"
+	case game.FirstPlayerLowerRated:
+		reason = "낮은 레이팅 선공"
+	default:
+		reason = "무작위 추첨"
+	}
+	return fmt.Sprintf("%s 님이 먼저 시작합니다 (%s)", name, reason)
+}
+
+// startGame starts the room's game with its players' ratings and who started
+// their last game, so the first-player policy can use them
+func (s *Server) startGame(room *game.Room) {
+	s.history.Seed(room)
+	room.StartGame()
+	s.history.RecordStart(room)
+}
+
+// runRoom starts the room's event loop, which reports the result to endGame
+func (s *Server) runRoom(room *game.Room) {
+	room.Run(game.RoomHooks{OnGameEnd: s.endGame})
+}
+
+// vacateSeat removes a player from a lobby seat and unindexes the session.
+// Returns the removed player, or nil if the seat was empty.
+func (s *Server) vacateSeat(room *game.Room, index int) *game.Player {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+
+	player := room.GetPlayer(index)
+	if player == nil {
+		return nil
+	}
+	room.RemovePlayer(index)
+
+	if st, ok := s.sessions[player.SessionID]; ok && st.room == room {
+		delete(s.sessions, player.SessionID)
+	}
+	return player
+}
+
+// leaveLobby handles a player leaving an invite room before the game starts.
+// The host leaving closes the room; the joiner leaving frees the seat.
+func (s *Server) leaveLobby(room *game.Room, playerIndex int) {
+	if playerIndex != lobbyHostIndex {
+		s.vacateSeat(room, playerIndex)
+		room.BroadcastLobbyState()
+		return
+	}
+
+	// Remove first so the guest's move to the lobby finds no seat to free
+	s.removeRoom(room.ID)
+	if guest := room.GetPlayer(1 - lobbyHostIndex); guest != nil {
+		s.sendRoomClosed(guest.SessionID, "host_left")
+	}
+}
+
+// sendRoomClosed returns a removed lobby player to the lobby
+func (s *Server) sendRoomClosed(sessionID, reason string) {
+	c := s.hub.GetClient(sessionID)
+	if c == nil {
+		return
+	}
+	s.setState(c, ws.ClientLobby)
+
+	closedMsg, err := ws.NewMessage(ws.MsgRoomClosed, ws.RoomClosedPayload{Reason: reason})
+	if err != nil {
+		log.Printf("failed to create room_closed message for session %s: %v", sessionID, err)
+		return
+	}
+	c.SendMessage(closedMsg)
+}
+
+// expireWaitingRoom closes an invite room whose game never started.
+func (s *Server) expireWaitingRoom(room *game.Room) {
+	if s.getRoom(room.ID) != room || room.GetPhase() != game.PhaseWaiting {
+		return
+	}
+
+	s.removeRoom(room.ID)
+	for i := 0; i < 2; i++ {
+		if p := room.GetPlayer(i); p != nil {
+			s.sendRoomClosed(p.SessionID, "expired")
+		}
+	}
+}
+
+func (s *Server) handlePlaceToken(client *ws.Client, payload ws.PlaceTokenPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+
+	if err := room.PlaceToken(playerIndex, payload.Index); err != nil {
+		s.sendError(client, "invalid_action", "Cannot place token here")
+	}
+}
+
+func (s *Server) handleSelectPlate(client *ws.Client, payload ws.SelectPlatePayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+
+	// Invalid selections are silently ignored
+	room.SelectPlate(playerIndex, payload.Index)
+}
+
+func (s *Server) handleConfirmMatch(client *ws.Client, _ ws.ConfirmMatchPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+
+	if err := room.ConfirmMatch(playerIndex); err != nil {
+		s.sendError(client, "invalid_action", "Cannot confirm match")
+	}
+}
+
+func (s *Server) handleAddToken(client *ws.Client, payload ws.AddTokenPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.sendError(client, "not_in_room", "You are not in a room")
+		return
+	}
+
+	if err := room.AddToken(playerIndex, payload.Index); err != nil {
+		s.sendError(client, "invalid_action", "Cannot add token here")
+	}
+}
+
+func (s *Server) handleReconnect(client *ws.Client, payload ws.ReconnectPayload) {
+	// A socket that resumed its session when it connected, such as a second
+	// tab, is resynced rather than refused
+	if client.GetState() != ws.ClientLobby {
+		s.resumeSession(client)
+		return
+	}
+
+	room, playerIndex := s.findPlayerRoom(payload.SessionID)
+	if room == nil {
+		s.sendError(client, "no_active_game", "No active game found")
+		return
+	}
+
+	// Check if game is already finished
+	if room.GetPhase() == game.PhaseFinished {
+		s.sendError(client, "game_finished", "Game has already ended")
+		return
+	}
+
+	player := room.GetPlayer(playerIndex)
+	if player == nil {
+		s.sendError(client, "player_not_found", "Player not found")
+		return
+	}
+
+	// Check grace period
+	if player.DisconnectedDuration() > game.ReconnectGracePeriod {
+		s.sendError(client, "grace_period_expired", "Reconnection grace period expired")
+		return
+	}
+
+	s.rebindSeat(client, room, playerIndex)
+}
+
+// rebindSeat points a room seat at the client and resyncs it
+func (s *Server) rebindSeat(client *ws.Client, room *game.Room, playerIndex int) {
+	player := room.GetPlayer(playerIndex)
+	if player == nil {
+		return
+	}
+
+	// Update connection
+	player.SetConnection(client.Conn)
+
+	// Back into the pre-game lobby
+	if room.GetPhase() == game.PhaseWaiting {
+		s.setState(client, ws.ClientWaiting)
+		room.BroadcastLobbyState()
+		return
+	}
+
+	// Transition to InGame state
+	s.setState(client, ws.ClientInGame)
+
+	// Send reconnected message
+	reconnectedMsg, err := ws.NewMessage(ws.MsgReconnected, ws.ReconnectedPayload{
+		PlayerIndex: playerIndex,
+	})
+	if err != nil {
+		log.Printf("failed to create reconnected message for session %s: %v", client.SessionID, err)
+		return
+	}
+	client.SendMessage(reconnectedMsg)
+
+	// Send current game state
+	room.BroadcastState()
+}
+
+// handleTakeover moves the session to a socket that was offered a takeover
+func (s *Server) handleTakeover(client *ws.Client, _ ws.TakeoverPayload) {
+	if s.hub.GetClient(client.SessionID) == client {
+		return
+	}
+	if err := s.hub.Takeover(client); err != nil {
+		s.sendError(client, "takeover_failed", "Could not take over session")
+		return
+	}
+	s.resumeSession(client)
+}
+
+// resumeSession rebinds a client that took over its session to the seat or
+// queue entry the session holds, with a full state resync
+func (s *Server) resumeSession(client *ws.Client) {
+	// The instance running the session's room resyncs it
+	if target := s.relayTarget(client.SessionID); target != "" && client.Remote == "" {
+		s.publish(target, &cluster.Envelope{Kind: cluster.KindResume, SessionID: client.SessionID})
+		return
+	}
+
+	// A watched game or an open rematch window carries over as well
+	if watched := s.spectatedRoom(client.SessionID); watched != nil {
+		if s.setState(client, ws.ClientSpectating) {
+			s.sendSpectating(client, watched)
+		}
+		return
+	}
+	s.rematchMu.Lock()
+	_, rematch := s.rematches[client.SessionID]
+	s.rematchMu.Unlock()
+	if rematch {
+		s.setState(client, ws.ClientPostGame)
+		return
+	}
+
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		position := s.matchmaker.GetQueuePosition(client.SessionID)
+		if position == 0 {
+			return
+		}
+		s.setState(client, ws.ClientWaiting)
+		queueMsg, err := ws.NewMessage(ws.MsgQueueJoined, ws.QueueJoinedPayload{Position: position})
+		if err != nil {
+			log.Printf("failed to create queue_joined message for session %s: %v", client.SessionID, err)
+			return
+		}
+		client.SendMessage(queueMsg)
+		return
+	}
+
+	if !s.isRoomActive(room) || room.GetPhase() == game.PhaseFinished {
+		return
+	}
+	s.rebindSeat(client, room, playerIndex)
+}
+
+func (s *Server) handleLeaveRoom(client *ws.Client, _ ws.LeaveRoomPayload) {
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+
+	// Explicit leave of a running game = immediate forfeit, opponent wins
+	if s.isRoomActive(room) && room.GetPhase() != game.PhaseWaiting {
+		if player := room.GetPlayer(playerIndex); player != nil {
+			player.ClearConnection()
+			s.setState(client, ws.ClientLobby)
+			if err := room.Forfeit(playerIndex); err != nil {
+				log.Printf("failed to forfeit room %s for player %d: %v", room.ID, playerIndex, err)
+			}
+			return
+		}
+	}
+
+	// Exit hooks give up the queue entry, lobby seat, spectator slot or
+	// rematch window
+	s.setState(client, ws.ClientLobby)
+}
+
+func (s *Server) handleClientDisconnect(client *ws.Client) {
+	if client == nil {
+		return
+	}
+
+	// The session lives on in the socket that replaced this one
+	if current := s.hub.GetClient(client.SessionID); current != nil && current != client {
+		return
+	}
+
+	// The session's room is on another instance
+	if target := s.relayTarget(client.SessionID); target != "" && client.Remote == "" {
+		if s.dropRelay(client.SessionID, target) {
+			s.publish(target, &cluster.Envelope{Kind: cluster.KindDisconnect, SessionID: client.SessionID})
+		}
+		return
+	}
+
+	s.cancelRematch(client.SessionID, "left")
+	s.stopSpectating(client.SessionID)
+
+	room, playerIndex := s.findPlayerRoom(client.SessionID)
+	if room == nil {
+		s.matchmaker.LeaveQueue(client.SessionID)
+		return
+	}
+
+	player := room.GetPlayer(playerIndex)
+	if player == nil {
+		return
+	}
+
+	if !player.ClearConnectionIf(client.Conn) {
+		return
+	}
+
+	if !room.IsFull() {
+		s.removeRoom(room.ID)
+		return
+	}
+
+	if !s.isRoomActive(room) {
+		return
+	}
+
+	// Dropping out of the lobby frees the seat instead of starting a forfeit clock
+	if room.GetPhase() == game.PhaseWaiting {
+		s.leaveLobby(room, playerIndex)
+		return
+	}
+
+	opponentIndex := 1 - playerIndex
+	leftMsg, err := ws.NewMessage(ws.MsgPlayerLeft, ws.PlayerLeftPayload{
+		PlayerIndex: playerIndex,
+		GracePeriod: int(game.ReconnectGracePeriod / time.Second),
+	})
+	if err != nil {
+		log.Printf("failed to create player_left message for room %s: %v", room.ID, err)
+	} else if err := room.SendToPlayer(opponentIndex, leftMsg); err != nil {
+		log.Printf("failed to send player_left message to opponent %d in room %s: %v", opponentIndex, room.ID, err)
+	}
+
+	if err := room.Disconnected(playerIndex); err != nil {
+		log.Printf("failed to start grace period in room %s for player %d: %v", room.ID, playerIndex, err)
+	}
+}
+
+func (s *Server) findPlayerRoom(sessionID string) (*game.Room, int) {
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+
+	if st, ok := s.sessions[sessionID]; ok {
+		return st.room, st.index
+	}
+	return nil, -1
+}
+
+func (s *Server) isRoomActive(room *game.Room) bool {
+	if room == nil {
+		return false
+	}
+	if room.GetPhase() == game.PhaseFinished {
+		return false
+	}
+	return s.getRoom(room.ID) == room
+}
+
+// endGame announces the result and closes the room. winner is -1 for a draw.
+func (s *Server) endGame(room *game.Room, winner int, reason string) {
+	room.SetFinished()
+
+	winnerName := ""
+	if p := room.GetPlayer(winner); p != nil {
+		winnerName = p.Nickname
+	}
+
+	displayWinner := 0 // 0 for draw
+	if winner >= 0 {
+		displayWinner = winner + 1
+	}
+
+	finalTokens := []int{0, 0}
+	if p0 := room.GetPlayer(0); p0 != nil {
+		finalTokens[0] = p0.Tokens
+	}
+	if p1 := room.GetPlayer(1); p1 != nil {
+		finalTokens[1] = p1.Tokens
+	}
+
+	rematchTimeout := s.openRematch(room)
+	endMsg, err := ws.NewMessage(ws.MsgGameEnd, ws.GameEndPayload{
+		Winner:         displayWinner, // 1-indexed for display
+		WinnerName:     winnerName,
+		Reason:         reason,
+		FinalTokens:    finalTokens,
+		RematchTimeout: rematchTimeout,
+	})
+	if err != nil {
+		log.Printf("failed to create game_end message for room %s: %v", room.ID, err)
+	} else {
+		room.BroadcastMessage(endMsg)
+	}
+
+	s.history.RecordResult(room, winner)
+	s.recordMatch(room, winner, reason, finalTokens)
+	s.finishPlayers(room, rematchTimeout > 0)
+	s.removeRoom(room.ID)
+}
+
+func (s *Server) endGameNoMatches(room *game.Room) {
+	s.endGame(room, room.GetWinner(), "no_matches")
+}
+
+// openRematch opens the post-game rematch window when both players are still
+// connected. Returns the window length in seconds, or 0 if none was opened.
+func (s *Server) openRematch(room *game.Room) int {
+	p0, p1 := room.GetPlayer(0), room.GetPlayer(1)
+	if p0 == nil || p1 == nil || !p0.IsConnected() || !p1.IsConnected() {
+		return 0
+	}
+
+	window := &rematchWindow{room: room, requester: -1}
+
+	s.rematchMu.Lock()
+	s.closeRematchLocked(p0.SessionID)
+	s.closeRematchLocked(p1.SessionID)
+	s.rematches[p0.SessionID] = window
+	s.rematches[p1.SessionID] = window
+	window.timer = s.clock.AfterFunc(game.RematchTimeout, func() {
+		s.expireRematch(window)
+	})
+	s.rematchMu.Unlock()
+
+	return int(game.RematchTimeout / time.Second)
+}
+
+// closeRematchLocked removes the window held by sessionID, if any.
+// Callers must hold rematchMu.
+func (s *Server) closeRematchLocked(sessionID string) *rematchWindow {
+	window := s.rematches[sessionID]
+	if window == nil {
+		return nil
+	}
+	window.timer.Stop()
+	for i := 0; i < 2; i++ {
+		if p := window.room.GetPlayer(i); p != nil && s.rematches[p.SessionID] == window {
+			delete(s.rematches, p.SessionID)
+		}
+	}
+	return window
+}
+
+// cancelRematch closes the window held by sessionID and tells the other
+// player why
+func (s *Server) cancelRematch(sessionID, reason string) {
+	s.rematchMu.Lock()
+	window := s.closeRematchLocked(sessionID)
+	s.rematchMu.Unlock()
+
+	if window == nil {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		p := window.room.GetPlayer(i)
+		if p == nil {
+			continue
+		}
+		s.endPostGame(p.SessionID)
+		if p.SessionID != sessionID {
+			s.sendRematchDeclined(p.SessionID, reason)
+		}
+	}
+}
+
+// expireRematch closes a window nobody acted on in time
+func (s *Server) expireRematch(window *rematchWindow) {
+	s.rematchMu.Lock()
+	p0 := window.room.GetPlayer(0)
+	if p0 == nil || s.rematches[p0.SessionID] != window {
+		s.rematchMu.Unlock()
+		return
+	}
+	s.closeRematchLocked(p0.SessionID)
+	s.rematchMu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if p := window.room.GetPlayer(i); p != nil {
+			s.endPostGame(p.SessionID)
+			s.sendRematchDeclined(p.SessionID, "expired")
+		}
+	}
+}
+
+func (s *Server) sendRematchDeclined(sessionID, reason string) {
+	client := s.hub.GetClient(sessionID)
+	if client == nil {
+		return
+	}
+
+	msg, err := ws.NewMessage(ws.MsgRematchDeclined, ws.RematchDeclinedPayload{Reason: reason})
+	if err != nil {
+		log.Printf("failed to create rematch_declined message for session %s: %v", sessionID, err)
+		return
+	}
+	if err := client.SendMessage(msg); err != nil {
+		log.Printf("failed to send rematch_declined message for session %s: %v", sessionID, err)
+	}
+}
+
+// rematchSeat finds the sender's player index in the finished room
+func rematchSeat(window *rematchWindow, sessionID string) int {
+	for i := 0; i < 2; i++ {
+		if p := window.room.GetPlayer(i); p != nil && p.SessionID == sessionID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleRematchRequest(client *ws.Client, _ ws.RematchRequestPayload) {
+	s.rematchMu.Lock()
+	window := s.rematches[client.SessionID]
+	if window == nil {
+		s.rematchMu.Unlock()
+		s.sendError(client, "no_rematch", "Rematch is no longer available")
+		return
+	}
+
+	playerIndex := rematchSeat(window, client.SessionID)
+	switch window.requester {
+	case playerIndex:
+		s.rematchMu.Unlock()
+		return
+	case 1 - playerIndex:
+		// Both asked for a rematch
+		s.closeRematchLocked(client.SessionID)
+		s.rematchMu.Unlock()
+		s.startRematch(window.room)
+		return
+	}
+
+	// The opponent gets a full timeout to answer
+	window.requester = playerIndex
+	window.timer.Reset(game.RematchTimeout)
+	s.rematchMu.Unlock()
+
+	opponent := window.room.GetPlayer(1 - playerIndex)
+	requester := window.room.GetPlayer(playerIndex)
+	offerMsg, err := ws.NewMessage(ws.MsgRematchOffered, ws.RematchOfferedPayload{
+		FromNickname:   requester.Nickname,
+		TimeoutSeconds: int(game.RematchTimeout / time.Second),
+	})
+	if err != nil {
+		log.Printf("failed to create rematch_offered message for room %s: %v", window.room.ID, err)
+		return
+	}
+	if c := s.hub.GetClient(opponent.SessionID); c != nil {
+		if err := c.SendMessage(offerMsg); err != nil {
+			log.Printf("failed to send rematch_offered message for session %s: %v", opponent.SessionID, err)
+		}
+	}
+}
+
+func (s *Server) handleRematchResponse(client *ws.Client, payload ws.RematchResponsePayload) {
+	if !payload.Accept {
+		s.cancelRematch(client.SessionID, "declined")
+		return
+	}
+
+	s.rematchMu.Lock()
+	window := s.rematches[client.SessionID]
+	if window == nil {
+		s.rematchMu.Unlock()
+		s.sendError(client, "no_rematch", "Rematch is no longer available")
+		return
+	}
+	if window.requester != 1-rematchSeat(window, client.SessionID) {
+		s.rematchMu.Unlock()
+		s.sendError(client, "no_rematch_request", "Your opponent has not requested a rematch")
+		return
+	}
+	s.closeRematchLocked(client.SessionID)
+	s.rematchMu.Unlock()
+
+	s.startRematch(window.room)
+}
+
+// startRematch seats both players of a finished room in a new game with the
+// same settings and the first player swapped
+func (s *Server) startRematch(finished *game.Room) {
+	room := finished.Rematch()
+	room.Hub = s.hub
+	room.SetOnEmpty(func(roomID string) {
+		s.removeRoom(roomID)
+	})
+
+	// Both players must still be online to start
+	var clients [2]*ws.Client
+	for i := 0; i < 2; i++ {
+		clients[i] = s.hub.GetClient(room.GetPlayer(i).SessionID)
+		if clients[i] == nil {
+			s.endPostGame(room.GetPlayer(1 - i).SessionID)
+			s.sendRematchDeclined(room.GetPlayer(1-i).SessionID, "left")
+			return
+		}
+		room.GetPlayer(i).SetConnection(clients[i].Conn)
+	}
+
+	s.addRoom(room)
+	s.startGame(room)
+	s.runRoom(room)
+
+	for i, c := range clients {
+		p := room.GetPlayer(i)
+		s.setState(c, ws.ClientInGame)
+
+		matchedMsg, err := ws.NewMessage(ws.MsgMatched, ws.MatchedPayload{
+			RoomID:      room.ID,
+			PlayerIndex: i,
+			Opponent:    room.GetPlayer(1 - i).Nickname,
+		})
+		if err != nil {
+			log.Printf("failed to create matched message for rematch room %s: %v", room.ID, err)
+			continue
+		}
+		if err := c.SendMessage(matchedMsg); err != nil {
+			log.Printf("failed to send matched message for session %s: %v", p.SessionID, err)
+		}
+	}
+
+	room.BroadcastStateWithMessage(firstPlayerMessage(room), "info")
+}
+
+// recordMatch persists the result of a finished game
+func (s *Server) recordMatch(room *game.Room, winner int, reason string, finalTokens []int) {
+	if s.store == nil {
+		return
+	}
+
+	settings := room.Settings()
+	record := &store.MatchRecord{
+		RoomID:            room.ID,
+		PlateCount:        settings.PlateCount,
+		Ruleset:           settings.Ruleset.Name,
+		FirstPlayerPolicy: string(settings.FirstPlayerPolicy),
+		FirstPlayer:       room.GetFirstPlayer(),
+		Winner:            winner,
+		Reason:            reason,
+		FinalTokens:       finalTokens,
+		StartedAt:         room.StartedAt(),
+		EndedAt:           s.clock.Now(),
+	}
+	for i := 0; i < 2; i++ {
+		if p := room.GetPlayer(i); p != nil {
+			record.Players = append(record.Players, store.PlayerData{
+				ID:        p.ID,
+				Nickname:  p.Nickname,
+				SessionID: p.SessionID,
+				Tokens:    p.Tokens,
+			})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.store.SaveMatch(ctx, record); err != nil {
+		log.Printf("failed to save match record for room %s: %v", room.ID, err)
+	}
+}
+
+func (s *Server) sendError(client *ws.Client, code, message string, fields ...ws.FieldError) {
+	errMsg, err := ws.NewErrorMessage(code, message, fields...)
+	if err != nil {
+		log.Printf("failed to create error message code=%s for session %s: %v", code, client.SessionID, err)
+		return
+	}
+	client.SendMessage(errMsg)
+}
+
+func generateSessionID() string {
+	return game.GenerateID()
+}
+
+func main() {
+	// Get configuration from environment
+	redisAddr := os.Getenv("REDIS_ADDR")
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Redis is wrapped to ride out outages: writes are buffered while it is
+	// down and replayed once it is back
+	var st store.Store
+	var redisStore *store.RedisStore
+	var health *store.HealthStore
+	redisUp := false
+	if redisAddr != "" {
+		opts, err := redisOptionsFromEnv(redisAddr)
+		if err != nil {
+			log.Fatalf("Invalid Redis configuration: %v", err)
+		}
+		if redisStore, err = store.OpenRedisStore(opts); err != nil {
+			log.Fatalf("Invalid Redis configuration: %v", err)
+		}
+		health = store.NewHealthStore(redisStore, clock.Real())
+		if err := health.Check(context.Background()); err != nil {
+			log.Printf("Redis at %s is unreachable, buffering writes until it is back: %v", redisAddr, err)
+		} else {
+			log.Printf("Connected to Redis at %s", redisAddr)
+			redisUp = true
+		}
+		st = health
+	} else {
+		log.Println("REDIS_ADDR not set, using local store")
+		st = localStore()
+	}
+
+	server := NewServer(st, clock.Real())
+
+	// Send queue size and what to do with clients that fall behind
+	queueSize := ws.DefaultSendQueueSize
+	if v := os.Getenv("SEND_QUEUE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			queueSize = n
+		} else {
+			log.Printf("Invalid SEND_QUEUE_SIZE %q, using %d", v, queueSize)
+		}
+	}
+	policy, ok := ws.ParseSlowConsumerPolicy(os.Getenv("SLOW_CONSUMER_POLICY"))
+	if !ok {
+		log.Printf("Invalid SLOW_CONSUMER_POLICY %q, using %s", os.Getenv("SLOW_CONSUMER_POLICY"), ws.SlowConsumerCoalesce)
+		policy = ws.SlowConsumerCoalesce
+	}
+	server.hub.SetSendQueue(queueSize, policy)
+
+	// What happens when the same session connects from a second tab
+	takeover, ok := ws.ParseTakeoverPolicy(os.Getenv("TAKEOVER_POLICY"))
+	if !ok {
+		log.Printf("Invalid TAKEOVER_POLICY %q, using %s", os.Getenv("TAKEOVER_POLICY"), ws.TakeoverReplace)
+		takeover = ws.TakeoverReplace
+	}
+	server.hub.SetTakeoverPolicy(takeover)
+
+	// Stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if health != nil {
+		go health.Run(ctx)
+	}
+
+	// Replicas sharing Redis relay sessions to the instance running their
+	// room. The cluster needs Redis to subscribe, so an instance that
+	// started during an outage runs alone until it can join.
+	if redisStore != nil {
+		instanceID := os.Getenv("INSTANCE_ID")
+		if instanceID == "" {
+			instanceID = game.GenerateID()
+		}
+		bus := cluster.NewRedisBus(redisStore.Client(), redisStore.KeyPrefix())
+		queue := redisStore.Queue(server.clock)
+		if redisUp {
+			if err := server.joinCluster(ctx, instanceID, bus, queue); err != nil {
+				log.Fatalf("Failed to join cluster as %s: %v", instanceID, err)
+			}
+			log.Printf("Joined cluster as instance %s", instanceID)
+		} else {
+			log.Println("Redis unreachable at startup, running without the cluster until it is back")
+			go server.joinClusterWhenUp(ctx, instanceID, bus, queue)
+		}
+	}
+
+	// A local store drops expired rooms and sessions like Redis would
+	if janitor, ok := st.(interface {
+		RunJanitor(ctx context.Context, interval time.Duration)
+	}); ok {
+		go janitor.RunJanitor(ctx, store.JanitorInterval)
+	}
+
+	// Start hub; it closes every socket once ctx is done
+	hubDone := make(chan struct{})
+	go func() {
+		server.hub.Run(ctx)
+		close(hubDone)
+	}()
+
+	// Routes
+	http.HandleFunc("/ws", server.handleWebSocket)
+	expvar.Publish("ws_messages", server.metrics) // Served at /debug/vars
+	if health != nil {
+		expvar.Publish("store", health)
+	}
+	http.HandleFunc("/readyz", readinessHandler(st))
+
+	schemaHandler, err := newProtocolSchemaHandler()
+	if err != nil {
+		log.Fatalf("Failed to encode protocol schema: %v", err)
+	}
+	http.HandleFunc(protocolSchemaPath, schemaHandler)
+
+	// Serve static files
+	fs := http.FileServer(http.Dir("./web"))
+	http.Handle("/", fs)
+
+	httpServer := &http.Server{Addr: ":" + port}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("failed to shut down HTTP server: %v", err)
+		}
+	}()
+
+	log.Printf("Server starting on :%s", port)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("ListenAndServe: ", err)
+	}
+
+	<-hubDone
+	if fileStore, ok := st.(*store.FileStore); ok {
+		if err := fileStore.Close(); err != nil {
+			log.Printf("failed to close data directory: %v", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// redisOptionsFromEnv reads the Redis deployment from the environment.
+// addr is REDIS_ADDR: one address, or comma-separated sentinels or Cluster
+// seed nodes.
+func redisOptionsFromEnv(addr string) (store.RedisOptions, error) {
+	opts := store.RedisOptions{
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		MasterName:       os.Getenv("REDIS_SENTINEL_MASTER"),
+		SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		CAFile:           os.Getenv("REDIS_CA_FILE"),
+		ServerName:       os.Getenv("REDIS_TLS_SERVER_NAME"),
+		KeyPrefix:        os.Getenv("REDIS_KEY_PREFIX"),
+	}
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			opts.Addrs = append(opts.Addrs, a)
+		}
+	}
+
+	// A wrong DB or mode would quietly use someone else's data, so unlike
+	// the tuning variables these are not defaulted
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil || db < 0 {
+			return opts, fmt.Errorf("invalid REDIS_DB %q", v)
+		}
+		opts.DB = db
+	}
+	for name, flag := range map[string]*bool{"REDIS_TLS": &opts.TLS, "REDIS_CLUSTER": &opts.Cluster} {
+		if v := os.Getenv(name); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*flag = on
+		}
+	}
+	return opts, nil
+}
+
+// readinessHandler answers 200 with the store's status as JSON. A
+// HealthStore buffering writes for an unreachable primary still serves
+// games, so it stays ready and reports healthy false: an outage shared by
+// every replica must not take them all out of the Service.
+func readinessHandler(st store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := store.HealthStatus{Healthy: true}
+		if health, ok := st.(*store.HealthStore); ok {
+			status = health.Status()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("failed to write readiness status: %v", err)
+		}
+	}
+}
+
+// localStore keeps state in DATA_DIR when it is set, or in memory
+func localStore() store.Store {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		return store.NewMemoryStore()
+	}
+	st, err := store.NewFileStore(dir, nil)
+	if err != nil {
+		log.Fatalf("Failed to open data directory %s: %v", dir, err)
+	}
+	log.Printf("Using data directory %s", dir)
+	return st
+}
@@ -0,0 +1,1316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/cluster"
+	"memory-feast-online/internal/game"
+	"memory-feast-online/internal/store"
+	"memory-feast-online/internal/ws"
+)
+
+func TestHandleLeaveRoomRemovesWaitingPlayerFromQueue(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	client := ws.NewClient(s.hub, nil, "session-waiting")
+	if err := client.Transition(ws.ClientWaiting); err != nil {
+		t.Fatalf("failed to enter waiting: %v", err)
+	}
+
+	player := game.NewPlayer("player-1", "Tester", client.SessionID, nil)
+	position, room := s.matchmaker.JoinQueue(player, nil, 20)
+	if position != 1 {
+		t.Fatalf("expected queue position 1, got %d", position)
+	}
+	if room != nil {
+		t.Fatalf("expected no room match, got room %v", room.ID)
+	}
+	if got := s.matchmaker.GetQueuePosition(client.SessionID); got != 1 {
+		t.Fatalf("expected player to be queued before leave, got position %d", got)
+	}
+
+	s.handleLeaveRoom(client, ws.LeaveRoomPayload{})
+
+	if got := s.matchmaker.GetQueuePosition(client.SessionID); got != 0 {
+		t.Fatalf("expected queue removal on leave_room, got position %d", got)
+	}
+	if got := client.GetState(); got != ws.ClientLobby {
+		t.Fatalf("expected client state lobby, got %s", got)
+	}
+}
+
+func TestEndGameRemovesRoom(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	room.Players[1] = game.NewPlayer("p2", "Bob", "s2", nil)
+
+	s.addRoom(room)
+
+	s.endGame(room, 0, "tokens")
+
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected room to be removed after endGame")
+	}
+}
+
+func TestEndGameNoMatchesRemovesRoom(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	room.Players[1] = game.NewPlayer("p2", "Bob", "s2", nil)
+
+	s.addRoom(room)
+
+	s.endGameNoMatches(room)
+
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected room to be removed after endGameNoMatches")
+	}
+}
+
+func TestHandleClientDisconnectRemovesQueuedPlayer(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	client := ws.NewClient(s.hub, nil, "session-queued")
+
+	player := game.NewPlayer("player-q", "Queued", client.SessionID, nil)
+	position, room := s.matchmaker.JoinQueue(player, nil, 20)
+	if position != 1 {
+		t.Fatalf("expected queue position 1, got %d", position)
+	}
+	if room != nil {
+		t.Fatalf("expected no room match, got room %v", room.ID)
+	}
+
+	s.handleClientDisconnect(client)
+
+	if got := s.matchmaker.GetQueuePosition(client.SessionID); got != 0 {
+		t.Fatalf("expected queued player to be removed on disconnect, got position %d", got)
+	}
+}
+
+func TestHandleClientDisconnectClearsConnectionAndRemovesWaitingRoom(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+
+	conn := &websocket.Conn{}
+	player := game.NewPlayer("player-w", "Waiting", "session-waiting-room", conn)
+	if _, err := room.AddPlayer(player); err != nil {
+		t.Fatalf("failed to add player to room: %v", err)
+	}
+
+	s.addRoom(room)
+
+	client := ws.NewClient(s.hub, conn, player.SessionID)
+	s.handleClientDisconnect(client)
+
+	if player.IsConnected() {
+		t.Fatalf("expected disconnected player connection to be cleared")
+	}
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected waiting room to be removed when lone player disconnects")
+	}
+}
+
+func TestHandleClientDisconnectIgnoresStaleConnectionAfterRebind(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+
+	oldConn := &websocket.Conn{}
+	newConn := &websocket.Conn{}
+
+	player := game.NewPlayer("player-r", "Rebound", "session-rebound", oldConn)
+	if _, err := room.AddPlayer(player); err != nil {
+		t.Fatalf("failed to add player to room: %v", err)
+	}
+
+	player.SetConnection(newConn)
+
+	s.addRoom(room)
+
+	staleClient := ws.NewClient(s.hub, oldConn, player.SessionID)
+	s.handleClientDisconnect(staleClient)
+
+	if got := player.GetConnection(); got != newConn {
+		t.Fatalf("expected stale disconnect to keep rebound connection")
+	}
+	if got := s.getRoom(room.ID); got == nil {
+		t.Fatalf("expected room to remain active after stale disconnect")
+	}
+}
+
+func TestIsOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		origin         string
+		allowedOrigins string
+		want           bool
+	}{
+		{
+			name:           "exact origin allowed",
+			origin:         "https://app.example.com",
+			allowedOrigins: "https://app.example.com,https://admin.example.com",
+			want:           true,
+		},
+		{
+			name:           "wildcard not supported",
+			origin:         "https://app.example.com",
+			allowedOrigins: "*",
+			want:           false,
+		},
+		{
+			name:           "case insensitive scheme and host",
+			origin:         "HTTPS://APP.EXAMPLE.COM",
+			allowedOrigins: "https://app.example.com",
+			want:           true,
+		},
+		{
+			name:           "empty origin rejected",
+			origin:         "",
+			allowedOrigins: "https://app.example.com",
+			want:           false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOriginAllowed(tt.origin, tt.allowedOrigins); got != tt.want {
+				t.Fatalf("isOriginAllowed(%q, %q) = %v, want %v", tt.origin, tt.allowedOrigins, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAllowedWebSocketOriginDefaultLocalhostOnly(t *testing.T) {
+	t.Setenv("ALLOWED_WS_ORIGINS", "")
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	if !isAllowedWebSocketOrigin(req) {
+		t.Fatalf("expected localhost origin to be allowed by default")
+	}
+
+	req = httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	if isAllowedWebSocketOrigin(req) {
+		t.Fatalf("expected non-local origin to be rejected by default")
+	}
+}
+
+func TestIsAllowedWebSocketOriginUsesConfiguredList(t *testing.T) {
+	t.Setenv("ALLOWED_WS_ORIGINS", "https://app.example.com")
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	if !isAllowedWebSocketOrigin(req) {
+		t.Fatalf("expected configured origin to be allowed")
+	}
+
+	req = httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	if isAllowedWebSocketOrigin(req) {
+		t.Fatalf("expected localhost origin to be rejected when explicit allowlist is set")
+	}
+}
+
+func TestIsAllowedWebSocketOriginWildcardRejected(t *testing.T) {
+	t.Setenv("ALLOWED_WS_ORIGINS", "*")
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	if isAllowedWebSocketOrigin(req) {
+		t.Fatalf("expected wildcard allowlist to be rejected")
+	}
+}
+
+func TestRoomIndexesTrackJoinAndRemove(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Code = allocateCode(t, s, room)
+
+	host := game.NewPlayer("p1", "Host", "session-host", nil)
+	if _, err := room.AddPlayer(host); err != nil {
+		t.Fatalf("failed to add host: %v", err)
+	}
+	s.addRoom(room)
+
+	if got := s.getRoomByCode(room.Code); got != room {
+		t.Fatalf("expected room to be indexed by code %s", room.Code)
+	}
+	if got, idx := s.findPlayerRoom(host.SessionID); got != room || idx != 0 {
+		t.Fatalf("expected host at index 0, got room=%v idx=%d", got, idx)
+	}
+
+	guest := game.NewPlayer("p2", "Guest", "session-guest", nil)
+	idx, err := s.joinRoom(room, guest)
+	if err != nil {
+		t.Fatalf("joinRoom failed: %v", err)
+	}
+	if got, gotIdx := s.findPlayerRoom(guest.SessionID); got != room || gotIdx != idx {
+		t.Fatalf("expected guest at index %d, got room=%v idx=%d", idx, got, gotIdx)
+	}
+
+	s.removeRoom(room.ID)
+
+	if got := s.getRoomByCode(room.Code); got != nil {
+		t.Fatalf("expected code index to be cleared after removal")
+	}
+	for _, sessionID := range []string{host.SessionID, guest.SessionID} {
+		if got, _ := s.findPlayerRoom(sessionID); got != nil {
+			t.Fatalf("expected session %s to be unindexed after removal", sessionID)
+		}
+	}
+	if _, err := s.joinRoom(room, game.NewPlayer("p3", "Late", "session-late", nil)); err == nil {
+		t.Fatalf("expected joinRoom on removed room to fail")
+	}
+}
+
+func TestRecordRoomSavesOverItsOwnVersion(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewServer(st, nil)
+	ctx := context.Background()
+
+	room := game.NewRoom(4, nil)
+	room.Players[0] = game.NewPlayer("p1", "Host", "session-host", nil)
+	s.addRoom(room)
+	if _, err := s.joinRoom(room, game.NewPlayer("p2", "Guest", "session-guest", nil)); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := st.GetRoom(ctx, room.ID)
+	if stored == nil || stored.Version != 2 || len(stored.Players) != 2 {
+		t.Fatalf("expected both saves at version 2, got %+v", stored)
+	}
+
+	// Someone else writes the record: the next save conflicts instead of
+	// overwriting it
+	stored.Owner = "other"
+	if err := st.SaveRoom(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	s.recordRoom(room)
+	if stored, _ = st.GetRoom(ctx, room.ID); stored.Owner != "other" || stored.Version != 3 {
+		t.Fatalf("expected the other writer's record kept, got %+v", stored)
+	}
+}
+
+func TestRemoveRoomKeepsSessionBoundToNewerRoom(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	oldRoom := game.NewRoom(4, nil)
+	oldRoom.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	s.addRoom(oldRoom)
+
+	newRoom := game.NewRoom(4, nil)
+	newRoom.Players[1] = game.NewPlayer("p1", "Alice", "s1", nil)
+	s.addRoom(newRoom)
+
+	s.removeRoom(oldRoom.ID)
+
+	if got, idx := s.findPlayerRoom("s1"); got != newRoom || idx != 1 {
+		t.Fatalf("expected session to stay bound to newer room, got room=%v idx=%d", got, idx)
+	}
+}
+
+func TestRoomIndexesConsistentUnderConcurrency(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	const workers = 8
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		worker := worker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				room := game.NewRoom(4, nil)
+				code, err := s.codeAlloc.Allocate(context.Background(), room.ID)
+				if err != nil {
+					t.Errorf("Allocate failed: %v", err)
+					return
+				}
+				room.Code = code
+				hostSession := fmt.Sprintf("host-%d-%d", worker, i)
+				guestSession := fmt.Sprintf("guest-%d-%d", worker, i)
+
+				room.AddPlayer(game.NewPlayer(hostSession, "Host", hostSession, nil))
+				s.addRoom(room)
+
+				if _, err := s.joinRoom(room, game.NewPlayer(guestSession, "Guest", guestSession, nil)); err != nil {
+					t.Errorf("joinRoom failed: %v", err)
+					return
+				}
+
+				for _, sessionID := range []string{hostSession, guestSession} {
+					got, idx := s.findPlayerRoom(sessionID)
+					if got != room {
+						t.Errorf("session %s resolved to wrong room", sessionID)
+						return
+					}
+					if p, want := room.GetPlayerBySessionID(sessionID); p == nil || want != idx {
+						t.Errorf("session %s indexed at %d, room has it at %d", sessionID, idx, want)
+						return
+					}
+				}
+				if got := s.getRoomByCode(room.Code); got != room {
+					t.Errorf("code %s resolved to wrong room", room.Code)
+					return
+				}
+
+				s.removeRoom(room.ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+	if len(s.rooms) != 0 || len(s.codes) != 0 || len(s.sessions) != 0 {
+		t.Fatalf("expected empty indexes, got rooms=%d codes=%d sessions=%d", len(s.rooms), len(s.codes), len(s.sessions))
+	}
+}
+
+func TestRemoveRoomReleasesCode(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewServer(st, nil)
+	room := game.NewRoom(4, nil)
+	room.Code = allocateCode(t, s, room)
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	s.addRoom(room)
+
+	s.removeRoom(room.ID)
+
+	if s.codeAlloc.InUse(room.Code) {
+		t.Fatalf("expected code %s to be released locally", room.Code)
+	}
+	ok, err := st.ReserveCode(context.Background(), room.Code, "other-room", time.Minute)
+	if err != nil {
+		t.Fatalf("ReserveCode failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected code %s to be released in the store", room.Code)
+	}
+}
+
+func TestExpireWaitingRoomRemovesRoomWithoutOpponent(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Code = allocateCode(t, s, room)
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	s.addRoom(room)
+
+	s.expireWaitingRoom(room)
+
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected abandoned waiting room to be removed")
+	}
+	if got := s.getRoomByCode(room.Code); got != nil {
+		t.Fatalf("expected expired code to be unindexed")
+	}
+}
+
+func TestExpireWaitingRoomKeepsStartedRoom(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Code = allocateCode(t, s, room)
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	room.Players[1] = game.NewPlayer("p2", "Bob", "s2", nil)
+	s.addRoom(room)
+	room.StartGame()
+
+	s.expireWaitingRoom(room)
+
+	if got := s.getRoom(room.ID); got != room {
+		t.Fatalf("expected started room to survive waiting-room expiry")
+	}
+}
+
+func TestWaitingRoomExpiryStopsWhenGameStartsOrRoomCloses(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	s := NewServer(store.NewMemoryStore(), clk)
+	idle := clk.Pending()
+
+	room, host, guest := newLobby(t, s)
+	if clk.Pending() != idle+1 {
+		t.Fatalf("expected the waiting room to arm its expiry, %d timers pending", clk.Pending())
+	}
+	s.handleReady(host, ws.ReadyPayload{Ready: true})
+	s.handleReady(guest, ws.ReadyPayload{Ready: true})
+	if room.GetPhase() != game.PhasePlacement || clk.Pending() != idle {
+		t.Fatalf("expected the expiry stopped once the game starts, %d timers pending", clk.Pending())
+	}
+	s.removeRoom(room.ID)
+
+	closing := registerClient(t, s, "session-closing")
+	s.handleCreateRoom(closing, ws.CreateRoomPayload{Nickname: "Host"})
+	waiting, _ := s.findPlayerRoom(closing.SessionID)
+	s.removeRoom(waiting.ID)
+	if clk.Pending() != idle {
+		t.Fatalf("expected the expiry stopped with the room, %d timers pending", clk.Pending())
+	}
+}
+
+func allocateCode(t *testing.T, s *Server, room *game.Room) string {
+	t.Helper()
+	code, err := s.codeAlloc.Allocate(context.Background(), room.ID)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	return code
+}
+
+// newLobby creates an invite room through the handlers, returning the host
+// and guest clients after the guest has joined.
+func newLobby(t *testing.T, s *Server) (*game.Room, *ws.Client, *ws.Client) {
+	t.Helper()
+
+	host := registerClient(t, s, "session-host")
+	guest := registerClient(t, s, "session-guest")
+
+	create := ws.CreateRoomPayload{Nickname: "Host", PlateCount: 4}
+	s.handleCreateRoom(host, create)
+
+	room, _ := s.findPlayerRoom(host.SessionID)
+	if room == nil {
+		t.Fatalf("expected host to be seated after create_room")
+	}
+
+	join := ws.JoinRoomPayload{Nickname: "Guest", RoomCode: room.Code}
+	s.handleJoinRoom(guest, join)
+
+	if got, _ := s.findPlayerRoom(guest.SessionID); got != room {
+		t.Fatalf("expected guest to be seated after join_room")
+	}
+	return room, host, guest
+}
+
+func registerClient(t *testing.T, s *Server, sessionID string) *ws.Client {
+	t.Helper()
+	client := ws.NewClient(s.hub, nil, sessionID)
+	s.hub.Register(client)
+	return client
+}
+
+func TestJoinRoomKeepsLobbyWaitingUntilReady(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	room, host, guest := newLobby(t, s)
+
+	if got := room.GetPhase(); got != game.PhaseWaiting {
+		t.Fatalf("expected lobby to stay in waiting phase, got %s", got)
+	}
+	if host.GetState() != ws.ClientWaiting || guest.GetState() != ws.ClientWaiting {
+		t.Fatalf("expected both clients waiting, got host=%s guest=%s", host.GetState(), guest.GetState())
+	}
+
+	ready := ws.ReadyPayload{Ready: true}
+	s.handleReady(host, ready)
+	if got := room.GetPhase(); got != game.PhaseWaiting {
+		t.Fatalf("expected game to wait for second ready, got %s", got)
+	}
+
+	s.handleReady(guest, ready)
+	if got := room.GetPhase(); got != game.PhasePlacement {
+		t.Fatalf("expected game to start once both ready, got %s", got)
+	}
+	if host.GetState() != ws.ClientInGame || guest.GetState() != ws.ClientInGame {
+		t.Fatalf("expected both clients in game, got host=%s guest=%s", host.GetState(), guest.GetState())
+	}
+}
+
+func TestUpdateSettingsOnlyByHost(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	room, host, guest := newLobby(t, s)
+
+	update := ws.UpdateSettingsPayload{PlateCount: 8, Ruleset: "blitz"}
+	s.handleUpdateSettings(guest, update)
+	if room.PlateCount != 4 {
+		t.Fatalf("expected guest settings change to be rejected")
+	}
+
+	s.handleUpdateSettings(host, update)
+	if room.PlateCount != 8 || room.Ruleset.Name != "blitz" {
+		t.Fatalf("expected host to change settings, got plates=%d ruleset=%s", room.PlateCount, room.Ruleset.Name)
+	}
+
+	policy := ws.UpdateSettingsPayload{FirstPlayer: "alternate"}
+	s.handleUpdateSettings(host, policy)
+	if got := room.Settings(); got.FirstPlayerPolicy != game.FirstPlayerAlternate || got.PlateCount != 8 {
+		t.Fatalf("expected policy change to keep other settings, got %+v", got)
+	}
+}
+
+func TestEndGameRecordsMatch(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewServer(st, nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", nil)
+	room.Players[1] = game.NewPlayer("p2", "Bob", "s2", nil)
+	room.UpdateSettings(game.RoomSettings{PlateCount: 4, Ruleset: game.RulesetClassic, FirstPlayerPolicy: game.FirstPlayerAlternate})
+	room.SetPreviousFirstPlayer(1)
+	room.StartGame()
+
+	s.addRoom(room)
+	s.endGame(room, 1, "tokens")
+
+	record, err := st.GetMatch(context.Background(), room.ID)
+	if err != nil || record == nil {
+		t.Fatalf("expected match record, got %v, %v", record, err)
+	}
+	if record.FirstPlayerPolicy != "alternate" || record.FirstPlayer != 0 {
+		t.Fatalf("expected alternate policy to start player 0, got %s/%d", record.FirstPlayerPolicy, record.FirstPlayer)
+	}
+	if record.Winner != 1 || record.Reason != "tokens" || len(record.Players) != 2 {
+		t.Fatalf("unexpected match record %+v", record)
+	}
+}
+
+func TestKickPlayerFreesGuestSeat(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	room, host, guest := newLobby(t, s)
+
+	kick := ws.KickPlayerPayload{}
+	s.handleKickPlayer(guest, kick)
+	if !room.IsFull() {
+		t.Fatalf("expected guest kick attempt to be rejected")
+	}
+
+	s.handleKickPlayer(host, kick)
+	if room.IsFull() {
+		t.Fatalf("expected guest to be removed from the room")
+	}
+	if got, _ := s.findPlayerRoom(guest.SessionID); got != nil {
+		t.Fatalf("expected kicked guest to be unindexed")
+	}
+	if got := guest.GetState(); got != ws.ClientLobby {
+		t.Fatalf("expected kicked guest back in lobby, got %s", got)
+	}
+	if got := s.getRoom(room.ID); got != room {
+		t.Fatalf("expected room to stay open for a new guest")
+	}
+}
+
+func TestLeaveLobbyIsNotForfeit(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	room, host, guest := newLobby(t, s)
+
+	leave := ws.LeaveRoomPayload{}
+	s.handleLeaveRoom(guest, leave)
+
+	if got := s.getRoom(room.ID); got != room {
+		t.Fatalf("expected room to stay open when guest leaves the lobby")
+	}
+	if room.GetPhase() != game.PhaseWaiting {
+		t.Fatalf("expected lobby to remain waiting, got %s", room.GetPhase())
+	}
+
+	s.handleLeaveRoom(host, leave)
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected room to close when host leaves the lobby")
+	}
+}
+
+// newFinishedGame starts a game between two registered clients and ends it,
+// leaving the post-game rematch window open
+func newFinishedGame(t *testing.T, s *Server) (*game.Room, [2]*ws.Client) {
+	t.Helper()
+
+	clients := [2]*ws.Client{
+		registerClient(t, s, "session-p1"),
+		registerClient(t, s, "session-p2"),
+	}
+
+	room := game.NewRoom(6, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", clients[0].SessionID, &websocket.Conn{})
+	room.Players[1] = game.NewPlayer("p2", "Bob", clients[1].SessionID, &websocket.Conn{})
+	room.UpdateSettings(game.RoomSettings{PlateCount: 6, Ruleset: game.RulesetBlitz, FirstPlayerPolicy: game.FirstPlayerAlternate})
+	s.startGame(room)
+	s.addRoom(room)
+	for _, c := range clients {
+		if err := c.Transition(ws.ClientInGame); err != nil {
+			t.Fatalf("failed to enter game: %v", err)
+		}
+	}
+
+	s.endGame(room, 0, "tokens")
+	return room, clients
+}
+
+// nextMessageOfType drains a client's send queue until a message of the given type
+func nextMessageOfType(t *testing.T, client *ws.Client, msgType ws.MessageType) *ws.Message {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		data, ok := client.Next()
+		if !ok {
+			select {
+			case <-client.Ready():
+				continue
+			case <-timeout:
+				t.Fatalf("expected %s message for session %s", msgType, client.SessionID)
+				return nil
+			}
+		}
+
+		var msg ws.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("failed to decode queued message: %v", err)
+		}
+		if msg.Type == msgType {
+			return &msg
+		}
+	}
+}
+
+func TestRematchStartsNewRoomWithSwappedFirstPlayer(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	finished, clients := newFinishedGame(t, s)
+
+	request := ws.RematchRequestPayload{}
+	s.handleRematchRequest(clients[0], request)
+	nextMessageOfType(t, clients[1], ws.MsgRematchOffered)
+
+	accept := ws.RematchResponsePayload{Accept: true}
+	s.handleRematchResponse(clients[1], accept)
+
+	room, playerIndex := s.findPlayerRoom(clients[1].SessionID)
+	if room == nil || room == finished {
+		t.Fatalf("expected players to be seated in a new room")
+	}
+	if playerIndex != 1 {
+		t.Fatalf("expected players to keep their seats, got index %d", playerIndex)
+	}
+	if got := room.Settings(); got.PlateCount != 6 || got.Ruleset.Name != "blitz" {
+		t.Fatalf("expected rematch to keep settings, got %+v", got)
+	}
+	if got, want := room.GetFirstPlayer(), 1-finished.GetFirstPlayer(); got != want {
+		t.Fatalf("expected first player %d in rematch, got %d", want, got)
+	}
+	for _, c := range clients {
+		if got := c.GetState(); got != ws.ClientInGame {
+			t.Fatalf("expected %s in game after rematch, got %s", c.SessionID, got)
+		}
+	}
+}
+
+func TestRematchDeclineNotifiesRequester(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	_, clients := newFinishedGame(t, s)
+
+	request := ws.RematchRequestPayload{}
+	s.handleRematchRequest(clients[0], request)
+
+	decline := ws.RematchResponsePayload{Accept: false}
+	s.handleRematchResponse(clients[1], decline)
+
+	msg := nextMessageOfType(t, clients[0], ws.MsgRematchDeclined)
+	var payload ws.RematchDeclinedPayload
+	json.Unmarshal(msg.Payload, &payload)
+	if payload.Reason != "declined" {
+		t.Fatalf("expected declined reason, got %q", payload.Reason)
+	}
+
+	for _, c := range clients {
+		if got := c.GetState(); got != ws.ClientLobby {
+			t.Fatalf("expected %s back in the lobby after decline, got %s", c.SessionID, got)
+		}
+	}
+
+	s.handleRematchRequest(clients[0], request)
+	nextMessageOfType(t, clients[0], ws.MsgError)
+	if room, _ := s.findPlayerRoom(clients[0].SessionID); room != nil {
+		t.Fatalf("expected no rematch room after decline")
+	}
+}
+
+func TestRematchWindowExpires(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	_, clients := newFinishedGame(t, s)
+
+	s.rematchMu.Lock()
+	window := s.rematches[clients[0].SessionID]
+	s.rematchMu.Unlock()
+	if window == nil {
+		t.Fatalf("expected rematch window after game end")
+	}
+
+	s.expireRematch(window)
+
+	for _, c := range clients {
+		msg := nextMessageOfType(t, c, ws.MsgRematchDeclined)
+		var payload ws.RematchDeclinedPayload
+		json.Unmarshal(msg.Payload, &payload)
+		if payload.Reason != "expired" {
+			t.Fatalf("expected expired reason, got %q", payload.Reason)
+		}
+	}
+
+	s.rematchMu.Lock()
+	defer s.rematchMu.Unlock()
+	if len(s.rematches) != 0 {
+		t.Fatalf("expected expired window to be removed, got %d entries", len(s.rematches))
+	}
+}
+
+func TestNoRematchAfterForfeitByDisconnect(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", "s1", &websocket.Conn{})
+	room.Players[1] = game.NewPlayer("p2", "Bob", "s2", nil)
+	room.StartGame()
+	s.addRoom(room)
+
+	s.endGame(room, 0, "forfeit")
+
+	s.rematchMu.Lock()
+	defer s.rematchMu.Unlock()
+	if len(s.rematches) != 0 {
+		t.Fatalf("expected no rematch window when a player is gone")
+	}
+}
+
+func TestLeaveRoomInGameForfeitsThroughRoomLoop(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewServer(st, nil)
+
+	leaver := registerClient(t, s, "session-leaver")
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", leaver.SessionID, &websocket.Conn{})
+	room.Players[1] = game.NewPlayer("p2", "Bob", "session-stayer", &websocket.Conn{})
+	room.StartGame()
+	s.addRoom(room)
+	s.runRoom(room)
+
+	leave := ws.LeaveRoomPayload{}
+	s.handleLeaveRoom(leaver, leave)
+
+	if got := s.getRoom(room.ID); got != nil {
+		t.Fatalf("expected room to be removed after forfeit")
+	}
+	record, _ := st.GetMatch(context.Background(), room.ID)
+	if record == nil || record.Winner != 1 || record.Reason != "forfeit" {
+		t.Fatalf("expected forfeit win for player 1, got %+v", record)
+	}
+	if err := room.PlaceToken(1, 0); err != game.ErrRoomClosed {
+		t.Fatalf("expected room loop to stop after the game, got %v", err)
+	}
+}
+
+func TestSecondTabTakesOverSeatAndResyncs(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	clients := [2]*ws.Client{
+		registerClient(t, s, "session-p1"),
+		registerClient(t, s, "session-p2"),
+	}
+	room := game.NewRoom(4, nil)
+	room.Hub = s.hub
+	room.Players[0] = game.NewPlayer("p1", "Alice", clients[0].SessionID, &websocket.Conn{})
+	room.Players[1] = game.NewPlayer("p2", "Bob", clients[1].SessionID, &websocket.Conn{})
+	room.StartGame()
+	s.addRoom(room)
+	s.runRoom(room)
+	defer room.Close()
+
+	// Same session opened in a second tab
+	newTab := ws.NewClient(s.hub, nil, clients[0].SessionID)
+	replaced, err := s.hub.Register(newTab)
+	if err != nil || !replaced {
+		t.Fatalf("expected new tab to replace the old one, got replaced=%v err=%v", replaced, err)
+	}
+	s.resumeSession(newTab)
+
+	nextMessageOfType(t, clients[0], ws.MsgSessionReplaced)
+	if !clients[0].IsClosed() {
+		t.Fatal("expected old tab to be closed")
+	}
+	if newTab.GetState() != ws.ClientInGame {
+		t.Fatalf("expected new tab to be rebound in game, got %s", newTab.GetState())
+	}
+	nextMessageOfType(t, newTab, ws.MsgReconnected)
+	nextMessageOfType(t, newTab, ws.MsgGameState)
+
+	// The old socket going away is not a disconnect of the seat
+	s.handleClientDisconnect(clients[0])
+	for data, ok := clients[1].Next(); ok; data, ok = clients[1].Next() {
+		var msg ws.Message
+		json.Unmarshal(data, &msg)
+		if msg.Type == ws.MsgPlayerLeft {
+			t.Fatal("expected no player_left for a replaced socket")
+		}
+	}
+}
+
+func TestAskPolicyWaitsForTakeover(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	s.hub.SetTakeoverPolicy(ws.TakeoverAsk)
+	room, host, _ := newLobby(t, s)
+
+	newTab := ws.NewClient(s.hub, nil, host.SessionID)
+	if _, err := s.hub.Register(newTab); err != ws.ErrTakeoverPending {
+		t.Fatalf("expected takeover to be offered, got %v", err)
+	}
+	conflict := nextMessageOfType(t, newTab, ws.MsgSessionConflict)
+	if !strings.Contains(string(conflict.Payload), `"canTakeover":true`) {
+		t.Fatalf("expected takeover offer, got %s", conflict.Payload)
+	}
+
+	readyMsg, _ := ws.NewMessage(ws.MsgReady, ws.ReadyPayload{Ready: true})
+	s.handleMessage(newTab, readyMsg)
+	if errMsg := nextMessageOfType(t, newTab, ws.MsgError); !strings.Contains(string(errMsg.Payload), "session_inactive") {
+		t.Fatalf("expected idle tab to be refused, got %s", errMsg.Payload)
+	}
+	if host.IsClosed() {
+		t.Fatal("expected old tab to keep the session until takeover")
+	}
+
+	takeoverMsg, _ := ws.NewMessage(ws.MsgTakeover, ws.TakeoverPayload{})
+	s.handleMessage(newTab, takeoverMsg)
+	if s.hub.GetClient(host.SessionID) != newTab || !host.IsClosed() {
+		t.Fatal("expected takeover to move the session to the new tab")
+	}
+	if newTab.GetState() != ws.ClientWaiting {
+		t.Fatalf("expected new tab back in the lobby of room %s, got %s", room.ID, newTab.GetState())
+	}
+	nextMessageOfType(t, newTab, ws.MsgLobbyState)
+}
+
+func TestLeavingPostGameDeclinesRematch(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	_, clients := newFinishedGame(t, s)
+	for _, c := range clients {
+		if got := c.GetState(); got != ws.ClientPostGame {
+			t.Fatalf("expected %s in post-game, got %s", c.SessionID, got)
+		}
+	}
+
+	s.handleJoinQueue(clients[0], ws.JoinQueuePayload{Nickname: "Alice"})
+	if got := clients[0].GetState(); got != ws.ClientWaiting {
+		t.Fatalf("expected queueing player to wait, got %s", got)
+	}
+	msg := nextMessageOfType(t, clients[1], ws.MsgRematchDeclined)
+	if !strings.Contains(string(msg.Payload), `"left"`) {
+		t.Fatalf("expected rematch declined as left, got %s", msg.Payload)
+	}
+	if got := clients[1].GetState(); got != ws.ClientLobby {
+		t.Fatalf("expected opponent back in the lobby, got %s", got)
+	}
+}
+
+func TestSpectatorFollowsGameUntilItEnds(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	room, host, guest := newLobby(t, s)
+	s.handleReady(host, ws.ReadyPayload{Ready: true})
+	s.handleReady(guest, ws.ReadyPayload{Ready: true})
+
+	watcher := registerClient(t, s, "session-watcher")
+	s.handleSpectate(watcher, ws.SpectatePayload{RoomCode: room.Code})
+	if got := watcher.GetState(); got != ws.ClientSpectating {
+		t.Fatalf("expected spectating, got %s", got)
+	}
+	nextMessageOfType(t, watcher, ws.MsgSpectating)
+	nextMessageOfType(t, watcher, ws.MsgGameState)
+
+	s.endGame(room, 0, "forfeit")
+	nextMessageOfType(t, watcher, ws.MsgGameEnd)
+	if got := watcher.GetState(); got != ws.ClientLobby {
+		t.Fatalf("expected spectator back in the lobby, got %s", got)
+	}
+	if s.spectatedRoom(watcher.SessionID) != nil || len(room.Spectators()) != 0 {
+		t.Fatal("expected spectator to be forgotten with the room")
+	}
+
+	// A session without a seat cannot skip straight into a game
+	stray := registerClient(t, s, "session-stray")
+	if err := stray.Transition(ws.ClientInGame); err == nil {
+		t.Fatal("expected in_game without a seat to be refused")
+	}
+}
+
+func TestLateSpectatorGetsCurrentServerTime(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	s := NewServer(store.NewMemoryStore(), clk)
+
+	room, host, guest := newLobby(t, s)
+	s.handleReady(host, ws.ReadyPayload{Ready: true})
+	s.handleReady(guest, ws.ReadyPayload{Ready: true})
+
+	// The first spectator's broadcast caches the spectator encoding
+	early := registerClient(t, s, "session-early")
+	s.handleSpectate(early, ws.SpectatePayload{RoomCode: room.Code})
+	room.BroadcastState()
+	clk.Advance(game.StateCoalesceWindow)
+	nextMessageOfType(t, early, ws.MsgGameState)
+
+	clk.Advance(40 * time.Second)
+	watcher := registerClient(t, s, "session-watcher")
+	s.handleSpectate(watcher, ws.SpectatePayload{RoomCode: room.Code})
+	var state ws.GameStatePayload
+	if err := json.Unmarshal(nextMessageOfType(t, watcher, ws.MsgGameState).Payload, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.ServerTime != clk.Now().UnixMilli() {
+		t.Fatalf("expected serverTime %d for a late spectator, got %d", clk.Now().UnixMilli(), state.ServerTime)
+	}
+}
+
+func TestRoutesMatchPublishedSchema(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+
+	routes := s.router.Routes()
+	if len(routes) != len(ws.ClientPayloads) {
+		t.Fatalf("expected %d routes, got %d", len(ws.ClientPayloads), len(routes))
+	}
+	for _, route := range routes {
+		payload, ok := ws.ClientPayloads[route.Type]
+		if !ok {
+			t.Fatalf("%s is routed but missing from the protocol schema", route.Type)
+		}
+		want, _ := json.Marshal(ws.PayloadSchema(payload))
+		got, _ := json.Marshal(route.Schema)
+		if string(got) != string(want) {
+			t.Fatalf("%s: route schema %s differs from published %s", route.Type, got, want)
+		}
+	}
+
+	handler, err := newProtocolSchemaHandler()
+	if err != nil {
+		t.Fatalf("failed to build schema handler: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", protocolSchemaPath, nil))
+	var doc ws.Schema
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.Defs["JoinRoomPayload"] == nil {
+		t.Fatalf("expected the schema document, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestJoinRoomNormalizesCodeAndReportsFields(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	host := registerClient(t, s, "session-host")
+	guest := registerClient(t, s, "session-guest")
+	s.handleCreateRoom(host, ws.CreateRoomPayload{Nickname: "Host"})
+	room, _ := s.findPlayerRoom(host.SessionID)
+
+	raw := fmt.Sprintf(`{"nickname":"Guest","roomCode":"%s","colour":"red"}`, strings.ToLower(room.Code))
+	s.router.Dispatch(guest, &ws.Message{Type: ws.MsgJoinRoom, Payload: json.RawMessage(raw)})
+
+	var payload ws.ErrorPayload
+	if err := json.Unmarshal(nextMessageOfType(t, guest, ws.MsgError).Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != "invalid_payload" || len(payload.Fields) != 1 || payload.Fields[0].Field != "/colour" {
+		t.Fatalf("expected the unknown field to be reported, got %+v", payload)
+	}
+
+	raw = fmt.Sprintf(`{"nickname":" Guest ","roomCode":" %s "}`, strings.ToLower(room.Code))
+	s.router.Dispatch(guest, &ws.Message{Type: ws.MsgJoinRoom, Payload: json.RawMessage(raw)})
+	if got, _ := s.findPlayerRoom(guest.SessionID); got != room {
+		t.Fatalf("expected a lowercase code to join the room")
+	}
+	if p := room.GetPlayer(1); p == nil || p.Nickname != "Guest" {
+		t.Fatalf("expected a trimmed nickname, got %+v", p)
+	}
+}
+
+func TestJoinRelaysToInstanceRunningRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shared := store.NewMemoryStore()
+	bus := cluster.NewMemoryBus()
+	a := NewServer(shared, nil)
+	b := NewServer(shared, nil)
+	if err := a.joinCluster(ctx, "a", bus, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.joinCluster(ctx, "b", bus, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	host := registerClient(t, a, "session-host")
+	a.handleCreateRoom(host, ws.CreateRoomPayload{Nickname: "Host", PlateCount: 4})
+	room, _ := a.findPlayerRoom(host.SessionID)
+
+	guest := registerClient(t, b, "session-guest")
+	raw := fmt.Sprintf(`{"nickname":"Guest","roomCode":"%s"}`, room.Code)
+	b.handleMessage(guest, &ws.Message{Type: ws.MsgJoinRoom, Payload: json.RawMessage(raw)})
+
+	nextMessageOfType(t, guest, ws.MsgRoomJoined)
+	nextMessageOfType(t, guest, ws.MsgLobbyState)
+	if got, _ := a.findPlayerRoom(guest.SessionID); got != room {
+		t.Fatal("expected guest to be seated in the room on instance a")
+	}
+	if got, _ := b.findPlayerRoom(guest.SessionID); got != nil {
+		t.Fatal("expected instance b to run no room for the guest")
+	}
+
+	b.handleMessage(guest, &ws.Message{Type: ws.MsgReady, Payload: json.RawMessage(`{"ready":true}`)})
+	var lobby ws.LobbyStatePayload
+	if err := json.Unmarshal(nextMessageOfType(t, guest, ws.MsgLobbyState).Payload, &lobby); err != nil {
+		t.Fatal(err)
+	}
+	if len(lobby.Players) != 2 || !lobby.Players[1].Ready || !lobby.Players[1].IsConnected {
+		t.Fatalf("expected the relayed ready to reach the room, got %+v", lobby.Players)
+	}
+
+	b.handleClientDisconnect(guest)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if got, _ := a.findPlayerRoom(guest.SessionID); got == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a disconnect on instance b to free the guest seat on instance a")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.relayTarget(guest.SessionID) != "" {
+		t.Fatal("expected the relay to end with the socket")
+	}
+}
+
+func TestHandleEnvelopeRecoversWithKindAndRoom(t *testing.T) {
+	room := game.NewRoom(4, nil)
+	// Without a hub every envelope kind panics
+	s := &Server{sessions: map[string]seat{"session-1": {room: room}}}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	s.handleEnvelope(&cluster.Envelope{Kind: cluster.KindFrame, From: "b", SessionID: "session-1"})
+
+	if got := logged.String(); !strings.Contains(got, "panic handling frame envelope") || !strings.Contains(got, room.ID) {
+		t.Fatalf("expected the panic logged with the envelope kind and room, got %q", got)
+	}
+}
+
+func TestQueueMatchesPlayersOnDifferentInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shared := store.NewMemoryStore()
+	queue := game.NewMemoryQueue()
+	bus := cluster.NewMemoryBus()
+	a := NewServer(shared, nil)
+	b := NewServer(shared, nil)
+	if err := a.joinCluster(ctx, "a", bus, queue); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.joinCluster(ctx, "b", bus, queue); err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := registerClient(t, a, "session-waiting")
+	a.handleMessage(waiting, &ws.Message{Type: ws.MsgJoinQueue, Payload: json.RawMessage(`{"nickname":"Alice"}`)})
+	nextMessageOfType(t, waiting, ws.MsgQueueJoined)
+
+	joining := registerClient(t, b, "session-joining")
+	b.handleMessage(joining, &ws.Message{Type: ws.MsgJoinQueue, Payload: json.RawMessage(`{"nickname":"Bob"}`)})
+
+	var matched ws.MatchedPayload
+	if err := json.Unmarshal(nextMessageOfType(t, waiting, ws.MsgMatched).Payload, &matched); err != nil {
+		t.Fatal(err)
+	}
+	if matched.PlayerIndex != 0 || matched.Opponent != "Bob" {
+		t.Fatalf("expected the waiting player seated first against Bob, got %+v", matched)
+	}
+	nextMessageOfType(t, waiting, ws.MsgGameState)
+	nextMessageOfType(t, joining, ws.MsgMatched)
+
+	room, _ := b.findPlayerRoom(waiting.SessionID)
+	if room == nil || room.ID != matched.RoomID {
+		t.Fatal("expected the room to run on the instance that made the match")
+	}
+	if a.relayTarget(waiting.SessionID) != "b" {
+		t.Fatal("expected the waiting player's instance to relay it to the room")
+	}
+	if got := waiting.GetState(); got != ws.ClientLobby {
+		t.Fatalf("expected the relayed socket to leave waiting, got %s", got)
+	}
+	if got := a.matchmaker.GetEntryBySessionID(waiting.SessionID); got != nil {
+		t.Fatal("expected the local queue entry to be released")
+	}
+}
+
+// flakyBus refuses subscriptions until up is set, like Redis during an
+// outage
+type flakyBus struct {
+	*cluster.MemoryBus
+	up       atomic.Bool
+	attempts atomic.Int32
+}
+
+func (b *flakyBus) Subscribe(ctx context.Context, instance string, handle func(*cluster.Envelope)) error {
+	b.attempts.Add(1)
+	if !b.up.Load() {
+		return fmt.Errorf("connection refused")
+	}
+	return b.MemoryBus.Subscribe(ctx, instance, handle)
+}
+
+func TestJoinClusterWhenUpMovesLocalQueueToShared(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := clock.NewFake(time.Unix(0, 0))
+	shared := store.NewMemoryStore()
+	queue := game.NewMemoryQueue()
+	bus := &flakyBus{MemoryBus: cluster.NewMemoryBus()}
+	a := NewServer(shared, fake)
+	b := NewServer(shared, nil)
+	if err := b.joinCluster(ctx, "b", bus.MemoryBus, queue); err != nil {
+		t.Fatal(err)
+	}
+
+	// Started during the outage, a queues locally
+	waiting := registerClient(t, a, "session-waiting")
+	a.handleMessage(waiting, &ws.Message{Type: ws.MsgJoinQueue, Payload: json.RawMessage(`{"nickname":"Alice"}`)})
+	nextMessageOfType(t, waiting, ws.MsgQueueJoined)
+
+	joined := make(chan struct{})
+	go func() {
+		a.joinClusterWhenUp(ctx, "a", bus, queue)
+		close(joined)
+	}()
+	retryUntil := func(done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatal("expected another attempt to join the cluster")
+			}
+			fake.Advance(time.Second)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	retryUntil(func() bool { return bus.attempts.Load() >= 2 })
+	if _, got := a.member(); got != nil {
+		t.Fatal("expected no bus while Redis is down")
+	}
+
+	bus.up.Store(true)
+	retryUntil(func() bool {
+		select {
+		case <-joined:
+			return true
+		default:
+			return false
+		}
+	})
+	if got := b.matchmaker.GetQueuePosition(waiting.SessionID); got != 1 {
+		t.Fatalf("expected the waiting player moved to the shared queue, got position %d", got)
+	}
+
+	joining := registerClient(t, b, "session-joining")
+	b.handleMessage(joining, &ws.Message{Type: ws.MsgJoinQueue, Payload: json.RawMessage(`{"nickname":"Bob"}`)})
+	nextMessageOfType(t, waiting, ws.MsgMatched)
+	if a.relayTarget(waiting.SessionID) != "b" {
+		t.Fatal("expected the waiting player relayed to the instance that matched it")
+	}
+}
+
+// unreachableStore is a MemoryStore whose backend never answers a ping
+type unreachableStore struct {
+	*store.MemoryStore
+}
+
+func (unreachableStore) Ping(context.Context) error {
+	return fmt.Errorf("connection refused")
+}
+
+func TestReadinessReportsStoreHealth(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	readinessHandler(store.NewMemoryStore())(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("expected a local store to be ready, got %d", recorder.Code)
+	}
+
+	health := store.NewHealthStore(unreachableStore{store.NewMemoryStore()}, nil)
+	health.Check(context.Background())
+	health.SaveSession(context.Background(), "s1", "room-1", 0)
+
+	recorder = httptest.NewRecorder()
+	readinessHandler(health)(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("expected to stay ready while buffering for a down store, got %d", recorder.Code)
+	}
+	var status store.HealthStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Healthy || status.Buffered != 1 || status.LastError == "" {
+		t.Fatalf("expected the outage and its buffered write in the status, got %+v", status)
+	}
+}
+
+func TestStartGameUsesRatingsAndLastFirstPlayer(t *testing.T) {
+	s := NewServer(store.NewMemoryStore(), nil)
+	newGame := func(policy game.FirstPlayerPolicy, seat0, seat1 string) *game.Room {
+		room := game.NewRoom(4, nil)
+		room.Hub = s.hub
+		room.Players[0] = game.NewPlayer("p-"+seat0, seat0, seat0, nil)
+		room.Players[1] = game.NewPlayer("p-"+seat1, seat1, seat1, nil)
+		room.UpdateSettings(game.RoomSettings{PlateCount: 4, Ruleset: game.RulesetClassic, FirstPlayerPolicy: policy})
+		s.addRoom(room)
+		s.startGame(room)
+		return room
+	}
+
+	first := newGame(game.FirstPlayerAlternate, "s1", "s2")
+	starter := first.GetPlayer(first.GetFirstPlayer()).SessionID
+	s.endGame(first, 0, "tokens")
+
+	// A new room, not a rematch, still alternates between the same sessions
+	second := newGame(game.FirstPlayerAlternate, "s2", "s1")
+	if got := second.GetPlayer(second.GetFirstPlayer()).SessionID; got == starter {
+		t.Fatalf("expected alternate to pass the first move from %s, got %s again", starter, got)
+	}
+	s.endGame(second, -1, "tokens")
+
+	// s1 won the first game, so s2 is now rated lower
+	third := newGame(game.FirstPlayerLowerRated, "s1", "s2")
+	if got := third.GetFirstPlayer(); got != 1 {
+		t.Fatalf("expected lower-rated s2 in seat 1 to start, got seat %d", got)
+	}
+	if !strings.Contains(firstPlayerMessage(third), "낮은 레이팅 선공") {
+		t.Fatalf("expected announcement to name the lower_rated policy, got %q", firstPlayerMessage(third))
+	}
+}
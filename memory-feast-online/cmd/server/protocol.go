@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"memory-feast-online/internal/ws"
+)
+
+// protocolSchemaPath serves the JSON Schema of every WebSocket message
+const protocolSchemaPath = "/protocol/schema.json"
+
+// newProtocolSchemaHandler serves the protocol schema, encoded once
+func newProtocolSchemaHandler() (http.HandlerFunc, error) {
+	body, err := json.MarshalIndent(ws.ProtocolSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(body)
+	}, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"memory-feast-online/internal/ws"
+)
+
+// Inbound message allowance per client
+const (
+	messageRate  = 20 // Messages per second on average
+	messageBurst = 40
+)
+
+var (
+	inLobby    = []ws.ClientState{ws.ClientLobby, ws.ClientPostGame}
+	inPostGame = []ws.ClientState{ws.ClientPostGame}
+	inWaiting  = []ws.ClientState{ws.ClientWaiting}
+	inGame     = []ws.ClientState{ws.ClientInGame}
+	leavable   = []ws.ClientState{ws.ClientWaiting, ws.ClientInGame, ws.ClientSpectating, ws.ClientPostGame}
+)
+
+// newRouter registers every client message with its payload type, allowed
+// client states and handler
+func (s *Server) newRouter() *ws.Router {
+	r := ws.NewRouter(func(client *ws.Client, err *ws.RouteError) {
+		s.sendError(client, err.Code, err.Message, err.Fields...)
+	})
+	r.Use(
+		s.metrics.Middleware(),
+		ws.Logging(),
+		ws.Recover(),
+		ws.RateLimit(messageRate, messageBurst, s.clock),
+		s.requireActiveSession,
+		ws.CheckState(),
+		ws.DecodePayload(),
+	)
+
+	// Lobby, also open after a game while the rematch window is
+	ws.Handle(r, ws.MsgJoinQueue, inLobby, s.handleJoinQueue)
+	ws.Handle(r, ws.MsgCreateRoom, inLobby, s.handleCreateRoom)
+	ws.Handle(r, ws.MsgJoinRoom, inLobby, s.handleJoinRoom)
+	ws.Handle(r, ws.MsgSpectate, inLobby, s.handleSpectate)
+
+	// Post-game
+	ws.Handle(r, ws.MsgRematchRequest, inPostGame, s.handleRematchRequest)
+	ws.Handle(r, ws.MsgRematchResponse, inPostGame, s.handleRematchResponse)
+
+	// Waiting room
+	ws.Handle(r, ws.MsgReady, inWaiting, s.handleReady)
+	ws.Handle(r, ws.MsgUpdateSettings, inWaiting, s.handleUpdateSettings)
+	ws.Handle(r, ws.MsgKickPlayer, inWaiting, s.handleKickPlayer)
+
+	// In game
+	ws.Handle(r, ws.MsgPlaceToken, inGame, s.handlePlaceToken)
+	ws.Handle(r, ws.MsgSelectPlate, inGame, s.handleSelectPlate)
+	ws.Handle(r, ws.MsgConfirmMatch, inGame, s.handleConfirmMatch)
+	ws.Handle(r, ws.MsgAddToken, inGame, s.handleAddToken)
+
+	// Back to the lobby from anywhere but the lobby
+	ws.Handle(r, ws.MsgLeaveRoom, leavable, s.handleLeaveRoom)
+
+	// Any state: a socket that already resumed its session on connect, as a
+	// second tab does, gets a resync
+	ws.Handle(r, ws.MsgReconnect, nil, s.handleReconnect)
+
+	// Any state, including a socket that does not hold its session yet
+	ws.Handle(r, ws.MsgTakeover, nil, s.handleTakeover)
+
+	return r
+}
+
+// requireActiveSession refuses messages from a socket that lost its session,
+// or has not taken it over yet. Only takeover gets through.
+func (s *Server) requireActiveSession(next ws.Handler) ws.Handler {
+	return func(req *ws.Request) error {
+		if req.Message.Type != ws.MsgTakeover && s.hub.GetClient(req.Client.SessionID) != req.Client {
+			return &ws.RouteError{Code: "session_inactive", Message: "Session is active in another connection"}
+		}
+		return next(req)
+	}
+}
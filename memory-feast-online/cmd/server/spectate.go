@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+
+	"memory-feast-online/internal/game"
+	"memory-feast-online/internal/ws"
+)
+
+// handleSpectate lets a lobby client watch a running invite room
+func (s *Server) handleSpectate(client *ws.Client, payload ws.SpectatePayload) {
+	room := s.getRoomByCode(payload.RoomCode)
+	if room == nil {
+		s.sendError(client, "room_not_found", "Room not found")
+		return
+	}
+	if room.GetPhase() == game.PhaseWaiting {
+		s.sendError(client, "not_started", "The game has not started yet")
+		return
+	}
+	if !s.isRoomActive(room) {
+		s.sendError(client, "game_finished", "Game has already ended")
+		return
+	}
+	if seated, _ := s.findPlayerRoom(client.SessionID); seated != nil {
+		s.sendError(client, "invalid_action", "Players cannot spectate")
+		return
+	}
+
+	s.roomsMu.Lock()
+	s.spectators[client.SessionID] = room
+	s.roomsMu.Unlock()
+	room.AddSpectator(client.SessionID)
+
+	if !s.setState(client, ws.ClientSpectating) {
+		s.stopSpectating(client.SessionID)
+		s.sendError(client, "invalid_action", "Cannot spectate now")
+		return
+	}
+
+	s.sendSpectating(client, room)
+
+	// The room may have closed while the spectator was joining
+	if !s.isRoomActive(room) {
+		s.releaseSpectators(room)
+	}
+}
+
+// sendSpectating confirms a spectator and sends the current game state
+func (s *Server) sendSpectating(client *ws.Client, room *game.Room) {
+	msg, err := ws.NewMessage(ws.MsgSpectating, ws.SpectatingPayload{
+		RoomID:   room.ID,
+		RoomCode: room.Code,
+	})
+	if err != nil {
+		log.Printf("failed to create spectating message for session %s: %v", client.SessionID, err)
+		return
+	}
+	client.SendMessage(msg)
+
+	data, err := room.EncodedState(game.SpectatorViewer)
+	if err != nil {
+		log.Printf("failed to encode spectator state for room %s: %v", room.ID, err)
+		return
+	}
+	client.Enqueue(ws.FrameState, data)
+}
+
+// spectatedRoom returns the room a session is watching, or nil
+func (s *Server) spectatedRoom(sessionID string) *game.Room {
+	s.roomsMu.RLock()
+	defer s.roomsMu.RUnlock()
+	return s.spectators[sessionID]
+}
+
+// stopSpectating forgets a spectator and stops sending it the room
+func (s *Server) stopSpectating(sessionID string) {
+	s.roomsMu.Lock()
+	room := s.spectators[sessionID]
+	delete(s.spectators, sessionID)
+	s.roomsMu.Unlock()
+
+	if room != nil {
+		room.RemoveSpectator(sessionID)
+	}
+}
+
+// releaseSpectators sends the spectators of a removed room back to the lobby
+func (s *Server) releaseSpectators(room *game.Room) {
+	for _, session := range room.Spectators() {
+		if c := s.hub.GetClient(session); c != nil {
+			c.TransitionFrom(ws.ClientSpectating, ws.ClientLobby)
+		}
+		if s.spectatedRoom(session) == room {
+			s.stopSpectating(session)
+		}
+	}
+}
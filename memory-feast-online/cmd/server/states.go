@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"memory-feast-online/internal/game"
+	"memory-feast-online/internal/ws"
+)
+
+var (
+	errNotSeated     = errors.New("not seated in a room")
+	errNotSpectating = errors.New("not watching a room")
+)
+
+// installStateHooks guards client state changes and ties what each state
+// holds on the server to leaving it
+func (s *Server) installStateHooks() {
+	states := s.hub.States()
+
+	// Only a seated session can be in game, and only a registered spectator
+	// can spectate
+	states.Guard(ws.ClientInGame, func(change ws.StateChange) error {
+		if room, _ := s.findPlayerRoom(change.Client.SessionID); room == nil {
+			return errNotSeated
+		}
+		return nil
+	})
+	states.Guard(ws.ClientSpectating, func(change ws.StateChange) error {
+		if s.spectatedRoom(change.Client.SessionID) == nil {
+			return errNotSpectating
+		}
+		return nil
+	})
+
+	// Back to the lobby from waiting gives up the queue entry and lobby seat
+	states.OnExit(ws.ClientWaiting, func(change ws.StateChange) {
+		if change.To == ws.ClientLobby {
+			s.releaseWaiting(change.Client.SessionID)
+		}
+	})
+
+	// Leaving post-game for anything but the rematch itself declines it
+	states.OnExit(ws.ClientPostGame, func(change ws.StateChange) {
+		s.cancelRematch(change.Client.SessionID, "left")
+	})
+
+	states.OnExit(ws.ClientSpectating, func(change ws.StateChange) {
+		s.stopSpectating(change.Client.SessionID)
+	})
+}
+
+// setState moves a client to a new state, logging refused transitions
+func (s *Server) setState(client *ws.Client, to ws.ClientState) bool {
+	if err := client.Transition(to); err != nil {
+		log.Printf("failed to move session %s to %s: %v", client.SessionID, to, err)
+		return false
+	}
+	return true
+}
+
+// releaseWaiting removes a session from the queue and frees its lobby seat
+func (s *Server) releaseWaiting(sessionID string) {
+	s.matchmaker.LeaveQueue(sessionID)
+
+	room, playerIndex := s.findPlayerRoom(sessionID)
+	if s.isRoomActive(room) && room.GetPhase() == game.PhaseWaiting {
+		s.leaveLobby(room, playerIndex)
+	}
+}
+
+// endPostGame returns a session whose rematch window closed to the lobby
+func (s *Server) endPostGame(sessionID string) {
+	if c := s.hub.GetClient(sessionID); c != nil {
+		if err := c.TransitionFrom(ws.ClientPostGame, ws.ClientLobby); err != nil && !errors.Is(err, ws.ErrStateChanged) {
+			log.Printf("failed to end post-game for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// finishPlayers moves the players of a finished game out of in_game: to
+// post-game when a rematch window is open, otherwise to the lobby. Players
+// who already left are not touched.
+func (s *Server) finishPlayers(room *game.Room, rematch bool) {
+	to := ws.ClientLobby
+	if rematch {
+		to = ws.ClientPostGame
+	}
+
+	for i := 0; i < 2; i++ {
+		p := room.GetPlayer(i)
+		if p == nil {
+			continue
+		}
+		c := s.hub.GetClient(p.SessionID)
+		if c == nil {
+			continue
+		}
+		if err := c.TransitionFrom(ws.ClientInGame, to); err != nil && !errors.Is(err, ws.ErrStateChanged) {
+			log.Printf("failed to finish game for session %s: %v", p.SessionID, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a manually advanced clock for tests. Timers and tickers fire only
+// from Advance, in deadline order. A channel tick is handed over directly:
+// Advance waits until the receiver takes it or the timer is stopped, so no
+// tick is dropped however far the clock jumps. AfterFunc callbacks run on the
+// goroutine calling Advance.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake creates a fake clock set to start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTimer creates a timer firing d after the current fake time
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0, nil)
+}
+
+// NewTicker creates a ticker firing every d
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d, nil)}
+}
+
+// AfterFunc calls fn from Advance once d has elapsed
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(d, 0, fn)
+}
+
+// Pending returns the number of active timers and tickers
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// Advance moves the clock forward by d, firing everything due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+
+	for {
+		f.mu.Lock()
+		t := f.nextDueLocked(end)
+		if t == nil {
+			f.now = end
+			f.mu.Unlock()
+			return
+		}
+
+		f.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			f.removeLocked(t)
+		}
+		now, fn, ch, stopped := f.now, t.fn, t.ch, t.stopped
+		f.mu.Unlock()
+
+		if fn != nil {
+			fn()
+			continue
+		}
+		select {
+		case ch <- now:
+		case <-stopped:
+		}
+	}
+}
+
+func (f *Fake) add(d, period time.Duration, fn func()) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:   f,
+		when:    f.now.Add(d),
+		period:  period,
+		fn:      fn,
+		ch:      make(chan time.Time),
+		stopped: make(chan struct{}),
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *Fake) nextDueLocked(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range f.timers {
+		if t.when.After(end) {
+			continue
+		}
+		if next == nil || t.when.Before(next.when) {
+			next = t
+		}
+	}
+	return next
+}
+
+// removeLocked deactivates t, reporting whether it was active
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock   *Fake
+	when    time.Time
+	period  time.Duration
+	fn      func()
+	ch      chan time.Time
+	stopped chan struct{} // Closed by Stop, releases a pending hand-over
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.removeLocked(t)
+	select {
+	case <-t.stopped:
+	default:
+		close(t.stopped)
+	}
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Drop a tick still being handed over, as time.Timer.Reset would
+	active := f.removeLocked(t)
+	select {
+	case <-t.stopped:
+	default:
+		close(t.stopped)
+	}
+	t.stopped = make(chan struct{})
+	t.when = f.now.Add(d)
+	f.timers = append(f.timers, t)
+	return active
+}
+
+// fakeTicker adapts fakeTimer to the Ticker interface
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeAfterFuncFiresOnAdvance(t *testing.T) {
+	f := NewFake(epoch)
+	fired := false
+	f.AfterFunc(30*time.Second, func() { fired = true })
+
+	f.Advance(29 * time.Second)
+	if fired {
+		t.Fatalf("expected callback not to fire before its deadline")
+	}
+
+	f.Advance(time.Second)
+	if !fired {
+		t.Fatalf("expected callback to fire at its deadline")
+	}
+	if got := f.Since(epoch); got != 30*time.Second {
+		t.Fatalf("expected 30s elapsed, got %v", got)
+	}
+}
+
+func TestFakeTickerDeliversEveryTick(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+
+	got := make(chan int)
+	go func() {
+		n := 0
+		for range ticker.C() {
+			n++
+			if n == 60 {
+				ticker.Stop()
+				got <- n
+				return
+			}
+		}
+	}()
+
+	f.Advance(60 * time.Second)
+	if n := <-got; n != 60 {
+		t.Fatalf("expected 60 ticks, got %d", n)
+	}
+	if f.Pending() != 0 {
+		t.Fatalf("expected stopped ticker to be removed")
+	}
+}
+
+func TestFakeTimerStopAndReset(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(10 * time.Second)
+
+	if !timer.Stop() {
+		t.Fatalf("expected Stop to report an active timer")
+	}
+	f.Advance(time.Minute) // Must not block on the stopped timer
+
+	timer.Reset(5 * time.Second)
+	done := make(chan time.Time)
+	go func() { done <- <-timer.C() }()
+
+	f.Advance(5 * time.Second)
+	if at := <-done; !at.Equal(epoch.Add(65 * time.Second)) {
+		t.Fatalf("expected reset timer to fire at +65s, got %v", at.Sub(epoch))
+	}
+}
+
+func TestFakeFiresInDeadlineOrder(t *testing.T) {
+	f := NewFake(epoch)
+	var order []int
+	f.AfterFunc(3*time.Second, func() { order = append(order, 3) })
+	f.AfterFunc(1*time.Second, func() { order = append(order, 1) })
+	f.AfterFunc(2*time.Second, func() {
+		order = append(order, 2)
+		// Timers created while advancing still fire within the same Advance
+		f.AfterFunc(500*time.Millisecond, func() { order = append(order, 25) })
+	})
+
+	f.Advance(3 * time.Second)
+
+	want := []int{1, 2, 25, 3}
+	if len(order) != len(want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, order)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package cluster carries sessions between server instances. A room runs on
+// the instance that created it; an instance holding the socket of a player
+// seated there relays the player's messages to it and gets the player's
+// outbound frames back over a Bus.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"runtime/debug"
+
+	"memory-feast-online/internal/ws"
+)
+
+// Kind says what an envelope asks of the receiving instance
+type Kind string
+
+const (
+	// KindForward carries a client message to the instance running the
+	// session's room
+	KindForward Kind = "forward"
+	// KindResume asks for a full resync after the session's socket was
+	// replaced
+	KindResume Kind = "resume"
+	// KindDisconnect reports that the session's socket closed
+	KindDisconnect Kind = "disconnect"
+	// KindFrame carries an outbound frame to the instance holding the socket
+	KindFrame Kind = "frame"
+	// KindClose asks the instance holding the socket to close it
+	KindClose Kind = "close"
+	// KindRedirect moves the session's relay to Target, which is sent
+	// Message first
+	KindRedirect Kind = "redirect"
+	// KindMatched tells the instance holding the socket that the random
+	// queue matched the session into a room on the sender
+	KindMatched Kind = "matched"
+)
+
+// Envelope is one message between instances about one session
+type Envelope struct {
+	Kind      Kind            `json:"kind"`
+	From      string          `json:"from"` // Sending instance
+	SessionID string          `json:"sessionId"`
+	Message   *ws.Message     `json:"message,omitempty"`   // Forward, redirect
+	FrameKind ws.FrameKind    `json:"frameKind,omitempty"` // Frame
+	Frame     json.RawMessage `json:"frame,omitempty"`     // Frame: the encoded ws message
+	Target    string          `json:"target,omitempty"`    // Redirect
+}
+
+// Bus delivers envelopes between instances. Envelopes published by one
+// goroutine to one instance arrive in order.
+type Bus interface {
+	// Publish sends an envelope to an instance, reporting whether that
+	// instance is subscribed
+	Publish(ctx context.Context, instance string, env *Envelope) (bool, error)
+	// Subscribe delivers the envelopes addressed to instance to handle, one
+	// at a time, until ctx is done. It returns once the subscription is live.
+	Subscribe(ctx context.Context, instance string, handle func(*Envelope)) error
+}
+
+// deliver hands an envelope to a subscriber's handler. A panic is logged
+// and drops only that envelope, so the subscription keeps running.
+func deliver(handle func(*Envelope), env *Envelope) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic handling %s envelope from instance %s for session %s: %v\n%s", env.Kind, env.From, env.SessionID, p, debug.Stack())
+		}
+	}()
+	handle(env)
+}
@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"memory-feast-online/internal/ws"
+)
+
+func TestMemoryBus(t *testing.T) {
+	testBus(t, NewMemoryBus())
+}
+
+func TestPanickingHandlerKeepsSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewMemoryBus()
+	received := make(chan string, 1)
+	err := bus.Subscribe(ctx, "a", func(env *Envelope) {
+		if env.SessionID == "bad" {
+			panic("handler bug")
+		}
+		received <- env.SessionID
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Publish(ctx, "a", &Envelope{Kind: KindForward, SessionID: "bad"})
+	bus.Publish(ctx, "a", &Envelope{Kind: KindForward, SessionID: "good"})
+	select {
+	case sessionID := <-received:
+		if sessionID != "good" {
+			t.Fatalf("expected the envelope after the panic, got %s", sessionID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the subscription to survive a panicking handler")
+	}
+}
+
+// TestRedisBus runs against the server at REDIS_TEST_ADDR
+func TestRedisBus(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	testBus(t, NewRedisBus(client, "test:"))
+
+	// Buses of other environments do not hear each other
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	instance := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	if err := NewRedisBus(client, "staging:").Subscribe(ctx, instance, func(*Envelope) {}); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, err := NewRedisBus(client, "prod:").Publish(ctx, instance, &Envelope{Kind: KindForward}); err != nil || delivered {
+		t.Fatalf("expected no delivery across prefixes, got %v %v", delivered, err)
+	}
+	if delivered, _ := NewRedisBus(client, "staging:").Publish(ctx, instance, &Envelope{Kind: KindForward}); !delivered {
+		t.Fatal("expected delivery within the prefix")
+	}
+}
+
+func testBus(t *testing.T, bus Bus) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	instance := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	ok, err := bus.Publish(ctx, instance, &Envelope{Kind: KindFrame, SessionID: "s1"})
+	if err != nil || ok {
+		t.Fatalf("expected publish without subscriber to report false, got %v, %v", ok, err)
+	}
+
+	received := make(chan *Envelope, 16)
+	subCtx, unsubscribe := context.WithCancel(ctx)
+	if err := bus.Subscribe(subCtx, instance, func(env *Envelope) { received <- env }); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	const count = 10
+	for i := 0; i < count; i++ {
+		env := &Envelope{
+			Kind:      KindForward,
+			From:      "sender",
+			SessionID: fmt.Sprintf("s%d", i),
+			Message:   &ws.Message{Type: ws.MsgSelectPlate, Payload: []byte(fmt.Sprintf(`{"index":%d}`, i))},
+		}
+		ok, err := bus.Publish(ctx, instance, env)
+		if err != nil || !ok {
+			t.Fatalf("expected publish to subscriber to succeed, got %v, %v", ok, err)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case env := <-received:
+			if env.Kind != KindForward || env.From != "sender" || env.SessionID != fmt.Sprintf("s%d", i) {
+				t.Fatalf("envelope %d arrived out of order or changed: %+v", i, env)
+			}
+			if env.Message == nil || string(env.Message.Payload) != fmt.Sprintf(`{"index":%d}`, i) {
+				t.Fatalf("envelope %d lost its message: %+v", i, env.Message)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected envelope %d", i)
+		}
+	}
+
+	unsubscribe()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ok, err := bus.Publish(ctx, instance, &Envelope{Kind: KindClose, SessionID: "s1"})
+		if err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected instance to leave the bus once its context is done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
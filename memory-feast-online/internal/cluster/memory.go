@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// MemoryBus connects instances within one process, for tests and local runs
+// of several servers. Envelopes are encoded like on Redis, so receivers never
+// share memory with the sender.
+type MemoryBus struct {
+	mu          sync.Mutex
+	subscribers map[string]*memorySubscriber
+}
+
+type memorySubscriber struct {
+	mu    sync.Mutex
+	queue [][]byte
+	ready chan struct{}
+}
+
+// NewMemoryBus creates an in-process bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subscribers: make(map[string]*memorySubscriber)}
+}
+
+// Publish queues an envelope for the instance. It never blocks on the
+// receiver.
+func (b *MemoryBus) Publish(ctx context.Context, instance string, env *Envelope) (bool, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	sub := b.subscribers[instance]
+	b.mu.Unlock()
+	if sub == nil {
+		return false, nil
+	}
+
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, data)
+	sub.mu.Unlock()
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Subscribe registers the instance and delivers its envelopes until ctx is
+// done
+func (b *MemoryBus) Subscribe(ctx context.Context, instance string, handle func(*Envelope)) error {
+	sub := &memorySubscriber{ready: make(chan struct{}, 1)}
+	b.mu.Lock()
+	b.subscribers[instance] = sub
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			if b.subscribers[instance] == sub {
+				delete(b.subscribers, instance)
+			}
+			b.mu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.ready:
+			}
+
+			for {
+				sub.mu.Lock()
+				if len(sub.queue) == 0 {
+					sub.mu.Unlock()
+					break
+				}
+				data := sub.queue[0]
+				sub.queue = sub.queue[1:]
+				sub.mu.Unlock()
+
+				var env Envelope
+				if err := json.Unmarshal(data, &env); err == nil {
+					deliver(handle, &env)
+				}
+			}
+		}
+	}()
+	return nil
+}
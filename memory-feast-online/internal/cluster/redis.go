@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// instanceChannelPrefix names the pub/sub channel each instance listens on
+const instanceChannelPrefix = "instance:"
+
+// RedisBus delivers envelopes over Redis pub/sub, one channel per instance
+type RedisBus struct {
+	client redis.UniversalClient
+	prefix string // Environment prefix of the channels
+}
+
+// NewRedisBus creates a bus on a Redis client, typically the store's.
+// Channels start with prefix, so environments sharing Redis stay apart;
+// pub/sub ignores the DB index.
+func NewRedisBus(client redis.UniversalClient, prefix string) *RedisBus {
+	return &RedisBus{client: client, prefix: prefix}
+}
+
+// channel names the instance's channel
+func (b *RedisBus) channel(instance string) string {
+	return b.prefix + instanceChannelPrefix + instance
+}
+
+// Publish sends an envelope to the instance's channel. PUBLISH reports how
+// many subscribers received it, so an instance that is gone reads as false.
+func (b *RedisBus) Publish(ctx context.Context, instance string, env *Envelope) (bool, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+
+	receivers, err := b.client.Publish(ctx, b.channel(instance), data).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to publish envelope: %w", err)
+	}
+	return receivers > 0, nil
+}
+
+// Subscribe listens on the instance's channel until ctx is done
+func (b *RedisBus) Subscribe(ctx context.Context, instance string, handle func(*Envelope)) error {
+	pubsub := b.client.Subscribe(ctx, b.channel(instance))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to instance channel: %w", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var env Envelope
+				if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+					log.Printf("failed to unmarshal envelope on %s: %v", msg.Channel, err)
+					continue
+				}
+				deliver(handle, &env)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,189 @@
+package game
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/ws"
+)
+
+// StateCoalesceWindow is how long state changes are batched before one
+// snapshot per viewer is sent
+const StateCoalesceWindow = 25 * time.Millisecond
+
+// SpectatorViewer is the viewer role of anyone not seated in the room
+const SpectatorViewer = -1
+
+// stateBroadcaster batches state change notifications and caches the
+// encoded snapshot of each viewer role
+type stateBroadcaster struct {
+	version atomic.Uint64 // Bumped by every state change notification
+
+	mu    sync.Mutex  // Serializes flushes with other room sends
+	timer clock.Timer // Pending flush, nil when idle
+
+	cacheMu      sync.Mutex
+	cacheVersion uint64
+	cache        map[int]*encodedState // Encoded game_state per viewer role at cacheVersion
+}
+
+// encodedState is a viewer's game_state payload without timeLeft and
+// serverTime, which stamp writes ahead of it on every send
+type encodedState struct {
+	rest     []byte    // The other payload fields, closing brace included
+	timeLeft int       // Sent while no turn deadline runs
+	deadline time.Time // Turn deadline, zero while stopped
+}
+
+// stableState is a game_state payload without its clock fields: the nil
+// fields take the JSON names of the payload's own and are omitted
+type stableState struct {
+	ws.GameStatePayload
+	TimeLeft   *int `json:"timeLeft,omitempty"`
+	ServerTime *int `json:"serverTime,omitempty"`
+}
+
+// gameStatePrefix opens every game_state message, up to the timeLeft value
+var gameStatePrefix = []byte(`{"type":"` + string(ws.MsgGameState) + `","payload":{"timeLeft":`)
+
+// BroadcastState notifies the players that the game state changed. Changes
+// within StateCoalesceWindow are sent as one snapshot per player.
+func (r *Room) BroadcastState() {
+	b := &r.states
+	b.version.Add(1)
+
+	r.mu.RLock()
+	hub := r.Hub
+	r.mu.RUnlock()
+	if hub == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.timer != nil {
+		return
+	}
+	b.timer = r.clock.AfterFunc(StateCoalesceWindow, r.flushState)
+}
+
+// flushState sends the pending snapshot now, if there is one
+func (r *Room) flushState() {
+	b := &r.states
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.stopLocked() {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		client := r.clientFor(i)
+		if client == nil {
+			continue
+		}
+
+		data, err := r.EncodedState(i)
+		if err != nil {
+			log.Printf("Error encoding game state for player %d in room %s: %v", i, r.ID, err)
+			continue
+		}
+
+		if err := client.Enqueue(ws.FrameState, data); err != nil {
+			log.Printf("Error sending game state to player %d in room %s: %v", i, r.ID, err)
+		}
+	}
+
+	spectators := r.spectatorClients()
+	if len(spectators) == 0 {
+		return
+	}
+	data, err := r.EncodedState(SpectatorViewer)
+	if err != nil {
+		log.Printf("Error encoding spectator game state in room %s: %v", r.ID, err)
+		return
+	}
+	for _, client := range spectators {
+		if err := client.Enqueue(ws.FrameState, data); err != nil {
+			log.Printf("Error sending game state to spectator %s in room %s: %v", client.SessionID, r.ID, err)
+		}
+	}
+}
+
+// stopLocked cancels the pending flush, reporting whether there was one
+func (b *stateBroadcaster) stopLocked() bool {
+	if b.timer == nil {
+		return false
+	}
+	b.timer.Stop()
+	b.timer = nil
+	return true
+}
+
+// EncodedState returns the serialized game_state message for a viewer: 0 or
+// 1 for a seated player, SpectatorViewer for anyone else. The encoding is
+// cached until the next state change, so repeat sends and viewers sharing a
+// role reuse it; only timeLeft and serverTime are filled in per call.
+func (r *Room) EncodedState(viewer int) ([]byte, error) {
+	if viewer != 0 && viewer != 1 {
+		viewer = SpectatorViewer
+	}
+
+	b := &r.states
+	b.cacheMu.Lock()
+	defer b.cacheMu.Unlock()
+
+	version := b.version.Load()
+	if b.cache == nil || b.cacheVersion != version {
+		b.cache = make(map[int]*encodedState, 3)
+		b.cacheVersion = version
+	}
+	enc, ok := b.cache[viewer]
+	if !ok {
+		var err error
+		if enc, err = encodeState(r.GetGameStateForPlayer(viewer)); err != nil {
+			return nil, err
+		}
+		b.cache[viewer] = enc
+	}
+	return enc.stamp(r.clock.Now()), nil
+}
+
+func encodeState(state ws.GameStatePayload) (*encodedState, error) {
+	enc := &encodedState{timeLeft: state.TimeLeft}
+	if state.TurnDeadline != 0 {
+		enc.deadline = time.UnixMilli(state.TurnDeadline)
+	}
+
+	data, err := json.Marshal(stableState{GameStatePayload: state})
+	if err != nil {
+		return nil, err
+	}
+	enc.rest = data[1:]
+	return enc, nil
+}
+
+// stamp returns the message with the clock fields as of now
+func (e *encodedState) stamp(now time.Time) []byte {
+	timeLeft := e.timeLeft
+	if !e.deadline.IsZero() {
+		timeLeft = secondsUntil(e.deadline, now)
+	}
+
+	data := make([]byte, 0, len(gameStatePrefix)+len(e.rest)+48)
+	data = append(data, gameStatePrefix...)
+	data = strconv.AppendInt(data, int64(timeLeft), 10)
+	data = append(data, `,"serverTime":`...)
+	data = strconv.AppendInt(data, now.UnixMilli(), 10)
+	if len(e.rest) > 1 {
+		data = append(data, ',')
+	}
+	data = append(data, e.rest...)
+	return append(data, '}')
+}
@@ -0,0 +1,157 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/ws"
+)
+
+// newBroadcastRoom seats two players whose hub clients queue without a socket
+func newBroadcastRoom(t *testing.T, clk clock.Clock) (*Room, [2]*ws.Client) {
+	t.Helper()
+
+	hub := ws.NewHub()
+
+	room := NewRoom(4, clk)
+	room.Hub = hub
+
+	var clients [2]*ws.Client
+	for i, session := range []string{"s1", "s2"} {
+		clients[i] = ws.NewClient(hub, nil, session)
+		hub.Register(clients[i])
+		room.AddPlayer(&Player{SessionID: session})
+	}
+	return room, clients
+}
+
+func queuedFrames(c *ws.Client) int {
+	n := 0
+	for _, ok := c.Next(); ok; _, ok = c.Next() {
+		n++
+	}
+	return n
+}
+
+func TestBroadcastStateCoalescesWithinWindow(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	room, clients := newBroadcastRoom(t, fake)
+
+	room.BroadcastState()
+	room.BroadcastState()
+	room.BroadcastState()
+
+	if got := queuedFrames(clients[0]); got != 0 {
+		t.Fatalf("expected nothing sent before the window closes, got %d frames", got)
+	}
+
+	fake.Advance(StateCoalesceWindow)
+	for i, c := range clients {
+		if got := queuedFrames(c); got != 1 {
+			t.Fatalf("expected one coalesced snapshot for player %d, got %d", i, got)
+		}
+	}
+}
+
+func TestBroadcastMessageFlushesPendingStateFirst(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	room, clients := newBroadcastRoom(t, fake)
+
+	room.BroadcastState()
+	msg, _ := ws.NewMessage(ws.MsgGameEnd, ws.GameEndPayload{Winner: 1, Reason: "forfeit"})
+	room.BroadcastMessage(msg)
+
+	if got := queuedFrames(clients[1]); got != 2 {
+		t.Fatalf("expected state then message, got %d frames", got)
+	}
+	if fake.Pending() != 0 {
+		t.Fatalf("expected flush timer to be cancelled, %d pending", fake.Pending())
+	}
+}
+
+func TestEncodedStateCachedPerVersion(t *testing.T) {
+	room := NewRoom(4, nil)
+
+	if _, err := room.EncodedState(SpectatorViewer); err != nil {
+		t.Fatalf("EncodedState failed: %v", err)
+	}
+	first := room.states.cache[SpectatorViewer]
+	room.EncodedState(SpectatorViewer)
+	if room.states.cache[SpectatorViewer] != first {
+		t.Fatal("expected repeat encoding to reuse the cached encoding")
+	}
+
+	room.BroadcastState()
+	room.EncodedState(SpectatorViewer)
+	if room.states.cache[SpectatorViewer] == first {
+		t.Fatal("expected a state change to invalidate the cache")
+	}
+}
+
+func TestEncodedStateStampsClockOnEverySend(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	room := NewRoom(4, fake)
+	room.State.TurnDeadline = fake.Now().Add(30 * time.Second)
+	room.BroadcastState()
+
+	decode := func() ws.GameStatePayload {
+		t.Helper()
+		data, err := room.EncodedState(SpectatorViewer)
+		if err != nil {
+			t.Fatalf("EncodedState failed: %v", err)
+		}
+		var msg ws.Message
+		var state ws.GameStatePayload
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(msg.Payload, &state); err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	if state := decode(); state.TimeLeft != 30 || state.ServerTime != fake.Now().UnixMilli() {
+		t.Fatalf("expected 30s left at %d, got %ds at %d", fake.Now().UnixMilli(), state.TimeLeft, state.ServerTime)
+	}
+	fake.Advance(18 * time.Second)
+	if state := decode(); state.TimeLeft != 12 || state.ServerTime != fake.Now().UnixMilli() {
+		t.Fatalf("expected 12s left at %d from the cache, got %ds at %d", fake.Now().UnixMilli(), state.TimeLeft, state.ServerTime)
+	}
+}
+
+func TestEncodedStateKeepsHostileNicknames(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1000, 0))
+	room := NewRoom(4, fake)
+	hostile := `"timeLeft":99,"serverTime":1}`
+	room.AddPlayer(&Player{SessionID: "s1", Nickname: hostile})
+	room.State.TurnDeadline = fake.Now().Add(30 * time.Second)
+
+	data, err := room.EncodedState(0)
+	if err != nil {
+		t.Fatalf("EncodedState failed: %v", err)
+	}
+	var msg ws.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("expected valid JSON, got %s: %v", data, err)
+	}
+
+	// Compared as decoded, as the client reads it
+	state := room.GetGameStateForPlayer(0)
+	state.TimeLeft, state.ServerTime = 30, fake.Now().UnixMilli()
+	plain, _ := json.Marshal(state)
+	var want, got ws.GameStatePayload
+	json.Unmarshal(plain, &want)
+	if err := json.Unmarshal(msg.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != ws.MsgGameState || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if got.Players[0].Nickname != hostile {
+		t.Fatalf("expected the nickname untouched, got %q", got.Players[0].Nickname)
+	}
+}
@@ -0,0 +1,149 @@
+package game
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// RoomCodeLength is the number of characters in an invite code.
+	RoomCodeLength = 6
+
+	// WaitingRoomCodeTTL is how long an invite code stays reserved while
+	// the room waits for an opponent.
+	WaitingRoomCodeTTL = 10 * time.Minute
+
+	// ActiveRoomCodeTTL is how long an invite code stays reserved once the
+	// game has started.
+	ActiveRoomCodeTTL = 24 * time.Hour
+
+	// roomCodeCharset excludes easily confused characters (I, O, 0, 1).
+	roomCodeCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	maxCodeAttempts = 32
+)
+
+// CodeReserver reserves invite codes in storage shared between server
+// instances. ReserveCode must be atomic (SETNX-style) and report false when
+// another room already holds the code.
+type CodeReserver interface {
+	ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error)
+	RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error
+	ReleaseCode(ctx context.Context, code, roomID string) error
+}
+
+// CodeAllocator hands out invite codes that are unique within the process
+// and, when a CodeReserver is configured, across every instance sharing it.
+type CodeAllocator struct {
+	mu       sync.Mutex
+	leases   map[string]string // code -> room ID
+	reserver CodeReserver
+}
+
+// NewCodeAllocator creates a code allocator. reserver may be nil, in which
+// case codes are only guaranteed unique within this process.
+func NewCodeAllocator(reserver CodeReserver) *CodeAllocator {
+	return &CodeAllocator{
+		leases:   make(map[string]string),
+		reserver: reserver,
+	}
+}
+
+// Allocate reserves a fresh invite code for roomID. The shared reservation
+// expires after WaitingRoomCodeTTL unless refreshed with Retain.
+func (a *CodeAllocator) Allocate(ctx context.Context, roomID string) (string, error) {
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := GenerateRoomCode()
+		if err != nil {
+			return "", err
+		}
+
+		if !a.leaseLocal(code, roomID) {
+			continue
+		}
+
+		if a.reserver == nil {
+			return code, nil
+		}
+
+		ok, err := a.reserver.ReserveCode(ctx, code, roomID, WaitingRoomCodeTTL)
+		if err != nil {
+			a.releaseLocal(code, roomID)
+			return "", err
+		}
+		if !ok {
+			a.releaseLocal(code, roomID)
+			continue
+		}
+		return code, nil
+	}
+
+	return "", ErrNoRoomCode
+}
+
+// Retain extends the shared reservation of code, e.g. when the game starts.
+func (a *CodeAllocator) Retain(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	if a.reserver == nil || code == "" {
+		return nil
+	}
+	return a.reserver.RefreshCode(ctx, code, roomID, ttl)
+}
+
+// Release frees code if it is still held by roomID.
+func (a *CodeAllocator) Release(ctx context.Context, code, roomID string) error {
+	if code == "" {
+		return nil
+	}
+	a.releaseLocal(code, roomID)
+
+	if a.reserver == nil {
+		return nil
+	}
+	return a.reserver.ReleaseCode(ctx, code, roomID)
+}
+
+// InUse reports whether code is currently leased in this process.
+func (a *CodeAllocator) InUse(code string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.leases[code]
+	return ok
+}
+
+func (a *CodeAllocator) leaseLocal(code, roomID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, taken := a.leases[code]; taken {
+		return false
+	}
+	a.leases[code] = roomID
+	return true
+}
+
+func (a *CodeAllocator) releaseLocal(code, roomID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.leases[code] == roomID {
+		delete(a.leases, code)
+	}
+}
+
+// GenerateRoomCode draws a random invite code. Each character is sampled
+// uniformly from the charset, without modulo bias.
+func GenerateRoomCode() (string, error) {
+	max := big.NewInt(int64(len(roomCodeCharset)))
+	code := make([]byte, RoomCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = roomCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,166 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReserver struct {
+	mu       sync.Mutex
+	held     map[string]string
+	refuse   map[string]bool
+	refusals int
+	err      error
+}
+
+func newFakeReserver() *fakeReserver {
+	return &fakeReserver{held: make(map[string]string), refuse: make(map[string]bool)}
+}
+
+func (f *fakeReserver) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	if f.refusals > 0 {
+		f.refusals--
+		return false, nil
+	}
+	if _, ok := f.held[code]; ok {
+		return false, nil
+	}
+	f.held[code] = roomID
+	return true, nil
+}
+
+func (f *fakeReserver) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	return nil
+}
+
+func (f *fakeReserver) ReleaseCode(ctx context.Context, code, roomID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.held[code] == roomID {
+		delete(f.held, code)
+	}
+	return nil
+}
+
+func TestGenerateRoomCodeUsesCharset(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		code, err := GenerateRoomCode()
+		if err != nil {
+			t.Fatalf("GenerateRoomCode failed: %v", err)
+		}
+		if len(code) != RoomCodeLength {
+			t.Fatalf("expected %d chars, got %q", RoomCodeLength, code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(roomCodeCharset, c) {
+				t.Fatalf("code %q contains %q outside charset", code, c)
+			}
+		}
+	}
+}
+
+func TestCodeAllocatorCodesAreUnique(t *testing.T) {
+	alloc := NewCodeAllocator(newFakeReserver())
+	ctx := context.Background()
+
+	const workers = 8
+	const perWorker = 200
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				code, err := alloc.Allocate(ctx, GenerateID())
+				if err != nil {
+					t.Errorf("Allocate failed: %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[code] {
+					t.Errorf("code %s allocated twice", code)
+				}
+				seen[code] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCodeAllocatorRetriesWhenStoreHoldsCode(t *testing.T) {
+	reserver := newFakeReserver()
+	reserver.refusals = 3
+	alloc := NewCodeAllocator(reserver)
+
+	code, err := alloc.Allocate(context.Background(), "room-1")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if reserver.held[code] != "room-1" {
+		t.Fatalf("expected code %s to be reserved for room-1", code)
+	}
+	if len(alloc.leases) != 1 {
+		t.Fatalf("expected refused codes not to stay leased, got %d leases", len(alloc.leases))
+	}
+}
+
+func TestCodeAllocatorGivesUpAfterMaxAttempts(t *testing.T) {
+	reserver := newFakeReserver()
+	reserver.refusals = maxCodeAttempts
+	alloc := NewCodeAllocator(reserver)
+
+	if _, err := alloc.Allocate(context.Background(), "room-1"); !errors.Is(err, ErrNoRoomCode) {
+		t.Fatalf("expected ErrNoRoomCode, got %v", err)
+	}
+}
+
+func TestCodeAllocatorPropagatesStoreError(t *testing.T) {
+	reserver := newFakeReserver()
+	reserver.err = errors.New("store down")
+	alloc := NewCodeAllocator(reserver)
+
+	if _, err := alloc.Allocate(context.Background(), "room-1"); err == nil {
+		t.Fatalf("expected store error to be returned")
+	}
+	if len(alloc.leases) != 0 {
+		t.Fatalf("expected no leases after failed reservation")
+	}
+}
+
+func TestCodeAllocatorReleaseOnlyByOwner(t *testing.T) {
+	reserver := newFakeReserver()
+	alloc := NewCodeAllocator(reserver)
+	ctx := context.Background()
+
+	code, err := alloc.Allocate(ctx, "room-1")
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	alloc.Release(ctx, code, "room-2")
+	if !alloc.InUse(code) {
+		t.Fatalf("expected release by non-owner to be ignored")
+	}
+
+	alloc.Release(ctx, code, "room-1")
+	if alloc.InUse(code) {
+		t.Fatalf("expected release by owner to free code")
+	}
+	if _, ok := reserver.held[code]; ok {
+		t.Fatalf("expected store reservation to be released")
+	}
+}
@@ -0,0 +1,82 @@
+package game
+
+import (
+	"crypto/rand"
+	"math/big"
+	"slices"
+
+	"memory-feast-online/internal/ws"
+)
+
+// FirstPlayerPolicy decides who places (and matches) first
+type FirstPlayerPolicy string
+
+const (
+	// FirstPlayerRandom flips a coin every game
+	FirstPlayerRandom FirstPlayerPolicy = "random"
+	// FirstPlayerAlternate swaps the previous game's first player, flipping
+	// a coin when there is no previous game
+	FirstPlayerAlternate FirstPlayerPolicy = "alternate"
+	// FirstPlayerLowerRated lets the lower-rated player start, flipping a
+	// coin on equal ratings
+	FirstPlayerLowerRated FirstPlayerPolicy = "lower_rated"
+)
+
+var firstPlayerPolicies = []FirstPlayerPolicy{FirstPlayerRandom, FirstPlayerAlternate, FirstPlayerLowerRated}
+
+func init() {
+	ws.RegisterEnum("firstPlayerPolicy", FirstPlayerPolicyNames)
+}
+
+// FirstPlayerPolicyNames lists the policies by name, as the firstPlayer
+// fields of the protocol schema enumerate them
+func FirstPlayerPolicyNames() []string {
+	names := make([]string, len(firstPlayerPolicies))
+	for i, policy := range firstPlayerPolicies {
+		names[i] = string(policy)
+	}
+	return names
+}
+
+// ParseFirstPlayerPolicy validates a policy name. An empty name yields
+// FirstPlayerRandom.
+func ParseFirstPlayerPolicy(name string) (FirstPlayerPolicy, bool) {
+	if name == "" {
+		return FirstPlayerRandom, true
+	}
+	policy := FirstPlayerPolicy(name)
+	if !slices.Contains(firstPlayerPolicies, policy) {
+		return "", false
+	}
+	return policy, true
+}
+
+// choose picks the first player index. previous is the first player of the
+// preceding game between the same players, or -1.
+func (p FirstPlayerPolicy) choose(players [2]*Player, previous int) int {
+	switch p {
+	case FirstPlayerAlternate:
+		if previous == 0 || previous == 1 {
+			return 1 - previous
+		}
+	case FirstPlayerLowerRated:
+		if players[0] != nil && players[1] != nil {
+			if players[0].Rating < players[1].Rating {
+				return 0
+			}
+			if players[1].Rating < players[0].Rating {
+				return 1
+			}
+		}
+	}
+	return coinFlip()
+}
+
+// coinFlip returns 0 or 1 with equal probability
+func coinFlip() int {
+	n, err := rand.Int(rand.Reader, big.NewInt(2))
+	if err != nil {
+		return 0
+	}
+	return int(n.Int64())
+}
@@ -0,0 +1,63 @@
+package game
+
+import "testing"
+
+func TestParseFirstPlayerPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		want FirstPlayerPolicy
+		ok   bool
+	}{
+		{"", FirstPlayerRandom, true},
+		{"random", FirstPlayerRandom, true},
+		{"alternate", FirstPlayerAlternate, true},
+		{"lower_rated", FirstPlayerLowerRated, true},
+		{"host", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseFirstPlayerPolicy(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Fatalf("ParseFirstPlayerPolicy(%q) = %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFirstPlayerPolicyChoose(t *testing.T) {
+	low := &Player{Rating: 1000}
+	high := &Player{Rating: 1800}
+
+	tests := []struct {
+		name     string
+		policy   FirstPlayerPolicy
+		players  [2]*Player
+		previous int
+		want     int
+	}{
+		{"alternate after player 0", FirstPlayerAlternate, [2]*Player{low, high}, 0, 1},
+		{"alternate after player 1", FirstPlayerAlternate, [2]*Player{low, high}, 1, 0},
+		{"lower rated seat 0", FirstPlayerLowerRated, [2]*Player{low, high}, -1, 0},
+		{"lower rated seat 1", FirstPlayerLowerRated, [2]*Player{high, low}, -1, 1},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.choose(tt.players, tt.previous); got != tt.want {
+			t.Fatalf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFirstPlayerPolicyFallsBackToCoinFlip(t *testing.T) {
+	tied := [2]*Player{{Rating: 1500}, {Rating: 1500}}
+	policies := []FirstPlayerPolicy{FirstPlayerRandom, FirstPlayerAlternate, FirstPlayerLowerRated}
+
+	for _, policy := range policies {
+		var seen [2]bool
+		for i := 0; i < 200 && !(seen[0] && seen[1]); i++ {
+			seen[policy.choose(tied, -1)] = true
+		}
+		if !seen[0] || !seen[1] {
+			t.Fatalf("%s: expected both seats to start at least once, got %v", policy, seen)
+		}
+	}
+}
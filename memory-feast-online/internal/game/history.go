@@ -0,0 +1,119 @@
+package game
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// InitialRating is the rating of a session with no finished games.
+	InitialRating = 1500
+
+	// ratingK is the most a single game can move a rating.
+	ratingK = 32
+)
+
+// PlayerHistory remembers what FirstPlayerAlternate and FirstPlayerLowerRated
+// need from earlier games: an Elo rating per session, updated from each
+// finished game, and who started the last game between each pair of
+// sessions.
+type PlayerHistory struct {
+	mu        sync.Mutex
+	ratings   map[string]int       // session ID -> rating
+	lastFirst map[[2]string]string // sorted session pair -> session that started
+}
+
+// NewPlayerHistory creates an empty history
+func NewPlayerHistory() *PlayerHistory {
+	return &PlayerHistory{
+		ratings:   make(map[string]int),
+		lastFirst: make(map[[2]string]string),
+	}
+}
+
+// Rating returns the session's rating, InitialRating before its first game
+func (h *PlayerHistory) Rating(sessionID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rating(sessionID)
+}
+
+func (h *PlayerHistory) rating(sessionID string) int {
+	if rating, ok := h.ratings[sessionID]; ok {
+		return rating
+	}
+	return InitialRating
+}
+
+// Seed gives the room's players their ratings and tells the room who started
+// the last game between them. Call it before StartGame.
+func (h *PlayerHistory) Seed(room *Room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	p0, p1 := room.Players[0], room.Players[1]
+	if p0 == nil || p1 == nil {
+		return
+	}
+	p0.Rating = h.rating(p0.SessionID)
+	p1.Rating = h.rating(p1.SessionID)
+
+	switch h.lastFirst[sessionPair(p0.SessionID, p1.SessionID)] {
+	case p0.SessionID:
+		room.previousFirst = 0
+	case p1.SessionID:
+		room.previousFirst = 1
+	}
+}
+
+// RecordStart remembers who started the room's game
+func (h *PlayerHistory) RecordStart(room *Room) {
+	room.mu.RLock()
+	p0, p1 := room.Players[0], room.Players[1]
+	first := room.State.FirstPlayer
+	room.mu.RUnlock()
+	if p0 == nil || p1 == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastFirst[sessionPair(p0.SessionID, p1.SessionID)] = [2]*Player{p0, p1}[first].SessionID
+}
+
+// RecordResult updates both players' ratings from the room's result.
+// winner is -1 for a draw.
+func (h *PlayerHistory) RecordResult(room *Room, winner int) {
+	room.mu.RLock()
+	p0, p1 := room.Players[0], room.Players[1]
+	room.mu.RUnlock()
+	if p0 == nil || p1 == nil || p0.SessionID == p1.SessionID {
+		return
+	}
+
+	score := 0.5 // Player 0's score
+	switch winner {
+	case 0:
+		score = 1
+	case 1:
+		score = 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r0, r1 := h.rating(p0.SessionID), h.rating(p1.SessionID)
+	expected := 1 / (1 + math.Pow(10, float64(r1-r0)/400))
+	delta := int(math.Round(ratingK * (score - expected)))
+	h.ratings[p0.SessionID] = r0 + delta
+	h.ratings[p1.SessionID] = r1 - delta
+}
+
+// sessionPair keys a pair of sessions regardless of seat order
+func sessionPair(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
@@ -0,0 +1,44 @@
+package game
+
+import "testing"
+
+func TestPlayerHistoryRatesFinishedGames(t *testing.T) {
+	h := NewPlayerHistory()
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+
+	h.RecordResult(room, -1)
+	if h.Rating("s1") != InitialRating || h.Rating("s2") != InitialRating {
+		t.Fatalf("expected a draw between equals to keep ratings, got %d/%d", h.Rating("s1"), h.Rating("s2"))
+	}
+
+	h.RecordResult(room, 1)
+	if h.Rating("s1") != InitialRating-16 || h.Rating("s2") != InitialRating+16 {
+		t.Fatalf("expected an even game to move ratings by 16, got %d/%d", h.Rating("s1"), h.Rating("s2"))
+	}
+}
+
+func TestPlayerHistorySeedsNextGameBetweenSamePlayers(t *testing.T) {
+	h := NewPlayerHistory()
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.UpdateSettings(RoomSettings{PlateCount: 4, Ruleset: RulesetClassic, FirstPlayerPolicy: FirstPlayerAlternate})
+	h.Seed(room)
+	room.StartGame()
+	h.RecordStart(room)
+	starter := room.GetPlayer(room.GetFirstPlayer()).SessionID
+
+	// The same players meet again in a new room with swapped seats
+	next := NewRoom(4, nil)
+	next.Players[0] = &Player{SessionID: "s2"}
+	next.Players[1] = &Player{SessionID: "s1"}
+	next.UpdateSettings(RoomSettings{PlateCount: 4, Ruleset: RulesetClassic, FirstPlayerPolicy: FirstPlayerAlternate})
+	h.Seed(next)
+	next.StartGame()
+
+	if got := next.GetPlayer(next.GetFirstPlayer()).SessionID; got == starter {
+		t.Fatalf("expected the session that moved second to start next, got %s again", got)
+	}
+}
@@ -0,0 +1,376 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// Delays between a move and the next step, so both players can see the result
+const (
+	PlacementRevealDelay = 1500 * time.Millisecond // Placed token stays visible before it is covered
+	MatchRevealDelay     = 2 * time.Second         // Selected plates stay revealed before the outcome
+	PenaltyDelay         = 2 * time.Second         // Penalty message stays up before the turn passes
+	AddTokenDelay        = 1500 * time.Millisecond // Token animation before the turn passes
+)
+
+// RoomHooks lets the server react to game flow decided by the room's event loop
+type RoomHooks struct {
+	// OnGameEnd runs on the event loop once the game is over.
+	// winner is -1 for a draw; reason is tokens, no_matches or forfeit.
+	OnGameEnd func(room *Room, winner int, reason string)
+}
+
+type eventKind int
+
+const (
+	eventPlaceToken eventKind = iota
+	eventSelectPlate
+	eventConfirmMatch
+	eventAddToken
+	eventForfeit
+	eventDisconnect
+)
+
+// roomEvent is a player command or notice processed by the event loop
+type roomEvent struct {
+	kind   eventKind
+	player int
+	plate  int
+	reply  chan error
+}
+
+// Run starts the room's event loop. Player commands, turn timer ticks,
+// delayed steps and disconnect notices are all handled by this one goroutine,
+// in the order they arrive. Calling Run more than once has no effect.
+func (r *Room) Run(hooks RoomHooks) {
+	r.runOnce.Do(func() {
+		r.mu.Lock()
+		r.running = true
+		r.mu.Unlock()
+
+		r.hooks = hooks
+		go r.loop()
+	})
+}
+
+// Close stops the event loop. It is safe to call from the loop itself and
+// more than once.
+func (r *Room) Close() {
+	r.closeOnce.Do(func() {
+		close(r.quit)
+
+		r.states.mu.Lock()
+		r.states.stopLocked()
+		r.states.mu.Unlock()
+
+		r.mu.RLock()
+		hub := r.Hub
+		r.mu.RUnlock()
+		if hub != nil {
+			hub.DropTopic(r.Topic())
+		}
+	})
+}
+
+// PlaceToken places the player's token on a plate
+func (r *Room) PlaceToken(playerIndex, plateIndex int) error {
+	return r.submit(roomEvent{kind: eventPlaceToken, player: playerIndex, plate: plateIndex})
+}
+
+// SelectPlate toggles a plate selection during matching
+func (r *Room) SelectPlate(playerIndex, plateIndex int) error {
+	return r.submit(roomEvent{kind: eventSelectPlate, player: playerIndex, plate: plateIndex})
+}
+
+// ConfirmMatch reveals the two selected plates
+func (r *Room) ConfirmMatch(playerIndex int) error {
+	return r.submit(roomEvent{kind: eventConfirmMatch, player: playerIndex})
+}
+
+// AddToken adds one of the player's tokens to a matched plate
+func (r *Room) AddToken(playerIndex, plateIndex int) error {
+	return r.submit(roomEvent{kind: eventAddToken, player: playerIndex, plate: plateIndex})
+}
+
+// Forfeit ends the game in the opponent's favour
+func (r *Room) Forfeit(playerIndex int) error {
+	return r.submit(roomEvent{kind: eventForfeit, player: playerIndex})
+}
+
+// Disconnected starts the reconnect grace period for a player. The game is
+// forfeited if the player is still disconnected when it runs out.
+func (r *Room) Disconnected(playerIndex int) error {
+	return r.submit(roomEvent{kind: eventDisconnect, player: playerIndex})
+}
+
+// submit hands an event to the loop and waits until it has been processed
+func (r *Room) submit(ev roomEvent) error {
+	r.mu.RLock()
+	running := r.running
+	r.mu.RUnlock()
+	if !running {
+		return ErrRoomClosed
+	}
+
+	ev.reply = make(chan error, 1)
+	select {
+	case r.events <- ev:
+	case <-r.quit:
+		return ErrRoomClosed
+	}
+
+	select {
+	case err := <-ev.reply:
+		return err
+	case <-r.quit:
+		// The event may have been the one that ended the game
+		select {
+		case err := <-ev.reply:
+			return err
+		default:
+			return ErrRoomClosed
+		}
+	}
+}
+
+func (r *Room) loop() {
+	defer r.Close()
+	defer r.stopTimers()
+
+	for !r.done {
+		var expired, step, grace0, grace1 <-chan time.Time
+		if r.turnTimer != nil {
+			expired = r.turnTimer.C()
+		}
+		if r.step != nil {
+			step = r.step.C()
+		}
+		if r.grace[0] != nil {
+			grace0 = r.grace[0].C()
+		}
+		if r.grace[1] != nil {
+			grace1 = r.grace[1].C()
+		}
+
+		select {
+		case <-r.quit:
+			return
+		case ev := <-r.events:
+			ev.reply <- r.handleEvent(ev)
+		case <-expired:
+			r.onTurnExpired()
+		case <-step:
+			next := r.nextStep
+			r.step, r.nextStep = nil, nil
+			next()
+		case <-grace0:
+			r.onGraceExpired(0)
+		case <-grace1:
+			r.onGraceExpired(1)
+		}
+	}
+}
+
+func (r *Room) handleEvent(ev roomEvent) error {
+	if r.done {
+		return ErrInvalidPhase
+	}
+
+	switch ev.kind {
+	case eventForfeit:
+		if r.GetPhase() == PhaseWaiting {
+			return ErrInvalidPhase
+		}
+		r.finish(1-ev.player, "forfeit")
+		return nil
+	case eventDisconnect:
+		if ev.player < 0 || ev.player > 1 {
+			return ErrNotInRoom
+		}
+		if r.grace[ev.player] != nil {
+			r.grace[ev.player].Stop()
+		}
+		r.grace[ev.player] = r.clock.NewTimer(ReconnectGracePeriod)
+		return nil
+	}
+
+	// Moves wait until the previous move has played out
+	if r.nextStep != nil {
+		return ErrActionPending
+	}
+
+	switch ev.kind {
+	case eventPlaceToken:
+		return r.onPlaceToken(ev.player, ev.plate)
+	case eventSelectPlate:
+		if !r.handleSelectPlate(ev.player, ev.plate) {
+			return ErrInvalidAction
+		}
+		r.BroadcastState()
+		return nil
+	case eventConfirmMatch:
+		return r.onConfirmMatch(ev.player)
+	case eventAddToken:
+		return r.onAddToken(ev.player, ev.plate)
+	}
+	return ErrInvalidAction
+}
+
+func (r *Room) onPlaceToken(playerIndex, plateIndex int) error {
+	if !r.handlePlaceToken(playerIndex, plateIndex) {
+		return ErrInvalidAction
+	}
+
+	// Show token briefly, then cover it and pass the turn
+	r.BroadcastState()
+	r.schedule(PlacementRevealDelay, func() {
+		r.CoverPlate(plateIndex)
+		if r.advancePlacement() {
+			r.StartMatchingPhase()
+			r.startTurnTimer()
+		}
+		r.BroadcastState()
+	})
+	return nil
+}
+
+func (r *Room) onConfirmMatch(playerIndex int) error {
+	success, matched, _, _ := r.handleConfirmMatch(playerIndex)
+	if !success {
+		return ErrInvalidAction
+	}
+
+	r.stopTurnTimer()
+	r.BroadcastState()
+	r.schedule(MatchRevealDelay, func() {
+		if matched {
+			r.setAddTokenPhase()
+			r.BroadcastStateWithMessage("매치 성공! 토큰을 추가할 접시를 선택하세요.", "success")
+			return
+		}
+
+		r.handleMatchFail(playerIndex)
+		nickname := "해당 플레이어"
+		if p := r.GetPlayer(playerIndex); p != nil {
+			nickname = p.Nickname
+		}
+		r.BroadcastStateWithMessage(fmt.Sprintf("매치 실패! %s에게 페널티 토큰 +%d", nickname, r.Ruleset.FailPenalty), "fail")
+		r.schedule(PenaltyDelay, r.nextMatchingTurn)
+	})
+	return nil
+}
+
+func (r *Room) onAddToken(playerIndex, plateIndex int) error {
+	success, _, playerWon := r.handleAddToken(playerIndex, plateIndex)
+	if !success {
+		return ErrInvalidAction
+	}
+
+	// Broadcast state with lastActionPlate for animation
+	r.BroadcastState()
+	r.schedule(AddTokenDelay, func() {
+		if playerWon {
+			r.finish(playerIndex, "tokens")
+			return
+		}
+		r.nextMatchingTurn()
+	})
+	return nil
+}
+
+// nextMatchingTurn passes the turn, or ends the game when no pair is left
+func (r *Room) nextMatchingTurn() {
+	if !r.advanceMatching() {
+		r.finish(r.GetWinner(), "no_matches")
+		return
+	}
+	r.startTurnTimer()
+	r.BroadcastState()
+}
+
+// onTurnExpired penalizes the player whose turn deadline passed
+func (r *Room) onTurnExpired() {
+	r.turnTimer = nil
+	r.stopTurnTimer()
+	r.handleTimeout(r.GetCurrentTurn())
+	r.BroadcastStateWithMessage(fmt.Sprintf("시간 초과! 페널티 토큰 +%d", r.Ruleset.TimeoutPenalty), "fail")
+	r.schedule(PenaltyDelay, r.nextMatchingTurn)
+}
+
+func (r *Room) onGraceExpired(playerIndex int) {
+	r.grace[playerIndex] = nil
+
+	if p := r.GetPlayer(playerIndex); p == nil || p.IsConnected() {
+		return
+	}
+	r.finish(1-playerIndex, "forfeit")
+}
+
+// finish ends the game and hands the result to the server
+func (r *Room) finish(winner int, reason string) {
+	r.done = true
+	r.stopTimers()
+	r.SetFinished()
+
+	if r.hooks.OnGameEnd != nil {
+		r.hooks.OnGameEnd(r, winner, reason)
+	}
+}
+
+// schedule runs next on the loop after delay. Moves are rejected meanwhile.
+func (r *Room) schedule(delay time.Duration, next func()) {
+	r.step = r.clock.NewTimer(delay)
+	r.nextStep = next
+}
+
+// startTurnTimer sets the turn deadline. Clients count down to it locally;
+// the loop alone decides when the turn has expired.
+func (r *Room) startTurnTimer() {
+	r.stopTurnTimer()
+
+	limit := time.Duration(r.State.TurnTimeLimit) * time.Second
+	r.mu.Lock()
+	r.State.TimeLeft = r.State.TurnTimeLimit
+	r.State.TurnDeadline = r.clock.Now().Add(limit)
+	r.mu.Unlock()
+
+	r.turnTimer = r.clock.NewTimer(limit)
+}
+
+// stopTurnTimer clears the deadline, freezing the seconds left
+func (r *Room) stopTurnTimer() {
+	if r.turnTimer != nil {
+		r.turnTimer.Stop()
+		r.turnTimer = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.State.TurnDeadline.IsZero() {
+		r.State.TimeLeft = secondsUntil(r.State.TurnDeadline, r.clock.Now())
+		r.State.TurnDeadline = time.Time{}
+	}
+}
+
+// secondsUntil rounds the time left up to whole seconds, never below zero
+func secondsUntil(deadline, now time.Time) int {
+	left := deadline.Sub(now)
+	if left <= 0 {
+		return 0
+	}
+	return int((left + time.Second - 1) / time.Second)
+}
+
+func (r *Room) stopTimers() {
+	r.stopTurnTimer()
+	if r.step != nil {
+		r.step.Stop()
+		r.step, r.nextStep = nil, nil
+	}
+	for i, t := range r.grace {
+		if t != nil {
+			t.Stop()
+			r.grace[i] = nil
+		}
+	}
+}
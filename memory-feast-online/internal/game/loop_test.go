@@ -0,0 +1,190 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+// settle round-trips an ignored event through the loop, so every tick the
+// fake clock has handed over is fully processed when it returns
+func settle(r *Room) {
+	r.submit(roomEvent{kind: eventKind(-1)})
+}
+
+func TestCommandsRejectedWhenLoopNotRunning(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.StartGame()
+
+	if err := room.PlaceToken(0, 0); err != ErrRoomClosed {
+		t.Fatalf("expected ErrRoomClosed before Run, got %v", err)
+	}
+}
+
+func TestForfeitEndsGameThroughHook(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.StartGame()
+
+	type result struct {
+		winner int
+		reason string
+	}
+	ended := make(chan result, 1)
+	room.Run(RoomHooks{OnGameEnd: func(r *Room, winner int, reason string) {
+		ended <- result{winner, reason}
+	}})
+
+	if err := room.Forfeit(0); err != nil {
+		t.Fatalf("Forfeit failed: %v", err)
+	}
+
+	got := <-ended
+	if got.winner != 1 || got.reason != "forfeit" {
+		t.Fatalf("expected player 1 to win by forfeit, got %+v", got)
+	}
+	if room.GetPhase() != PhaseFinished {
+		t.Fatalf("expected finished phase, got %s", room.GetPhase())
+	}
+	if err := room.PlaceToken(1, 0); err != ErrRoomClosed {
+		t.Fatalf("expected loop to stop after the game ends, got %v", err)
+	}
+}
+
+func TestCommandsProcessedInOrder(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.StartGame()
+	room.Run(RoomHooks{})
+	defer room.Close()
+
+	first := room.GetFirstPlayer()
+	if err := room.PlaceToken(1-first, 0); err != ErrInvalidAction {
+		t.Fatalf("expected out-of-turn placement to be rejected, got %v", err)
+	}
+	if err := room.PlaceToken(first, 0); err != nil {
+		t.Fatalf("expected placement to succeed, got %v", err)
+	}
+	if err := room.PlaceToken(first, 1); err != ErrActionPending {
+		t.Fatalf("expected second placement to wait for the reveal, got %v", err)
+	}
+}
+
+func TestMatchingTurnTimesOutOnFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	room := NewRoom(4, fake)
+	room.AddPlayer(&Player{SessionID: "s1"})
+	room.AddPlayer(&Player{SessionID: "s2"})
+	room.StartGame()
+	room.Run(RoomHooks{})
+	defer room.Close()
+
+	first := room.GetFirstPlayer()
+	if err := room.PlaceToken(first, 0); err != nil {
+		t.Fatalf("first placement failed: %v", err)
+	}
+	fake.Advance(PlacementRevealDelay)
+	if err := room.PlaceToken(1-first, 1); err != nil {
+		t.Fatalf("second placement failed: %v", err)
+	}
+	fake.Advance(PlacementRevealDelay)
+	settle(room)
+
+	if room.GetPhase() != PhaseMatching {
+		t.Fatalf("expected matching phase after placement, got %s", room.GetPhase())
+	}
+	tokens := room.GetPlayer(first).Tokens
+
+	fake.Advance(MatchingTimeLimit * time.Second)
+	settle(room)
+
+	if got := room.GetPlayer(first).Tokens; got != tokens+RulesetClassic.TimeoutPenalty {
+		t.Fatalf("expected timeout penalty to give %d tokens, got %d", tokens+RulesetClassic.TimeoutPenalty, got)
+	}
+
+	fake.Advance(PenaltyDelay)
+	settle(room)
+
+	if got := room.GetCurrentTurn(); got != 1-first {
+		t.Fatalf("expected turn to pass to player %d after the penalty, got %d", 1-first, got)
+	}
+}
+
+func TestReconnectGraceForfeitsOnFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	room := NewRoom(4, fake)
+	room.AddPlayer(&Player{SessionID: "s1"})
+	room.AddPlayer(&Player{SessionID: "s2"})
+	room.StartGame()
+
+	ended := make(chan int, 1)
+	room.Run(RoomHooks{OnGameEnd: func(r *Room, winner int, reason string) {
+		ended <- winner
+	}})
+	defer room.Close()
+
+	room.GetPlayer(0).ClearConnection()
+	if err := room.Disconnected(0); err != nil {
+		t.Fatalf("Disconnected failed: %v", err)
+	}
+
+	fake.Advance(ReconnectGracePeriod - time.Second)
+	settle(room)
+	if room.GetPhase() == PhaseFinished {
+		t.Fatal("expected game to continue within the grace period")
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case winner := <-ended:
+		if winner != 1 {
+			t.Fatalf("expected player 1 to win by forfeit, got %d", winner)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected forfeit once the grace period ran out")
+	}
+	if got := room.GetPlayer(0).DisconnectedDuration(); got != ReconnectGracePeriod {
+		t.Fatalf("expected disconnected duration %v on the room clock, got %v", ReconnectGracePeriod, got)
+	}
+}
+
+func TestTurnDeadlineSentOncePerTurn(t *testing.T) {
+	start := time.Unix(1000, 0)
+	fake := clock.NewFake(start)
+	room, clients := newBroadcastRoom(t, fake)
+	room.StartGame()
+	room.Run(RoomHooks{})
+	defer room.Close()
+
+	first := room.GetFirstPlayer()
+	room.PlaceToken(first, 0)
+	fake.Advance(PlacementRevealDelay)
+	room.PlaceToken(1-first, 1)
+	fake.Advance(PlacementRevealDelay)
+	settle(room)
+	fake.Advance(StateCoalesceWindow)
+	queuedFrames(clients[0])
+
+	state := room.GetGameStateForPlayer(0)
+	wantDeadline := start.Add(2*PlacementRevealDelay + MatchingTimeLimit*time.Second).UnixMilli()
+	if state.TurnDeadline != wantDeadline {
+		t.Fatalf("expected deadline %d, got %d", wantDeadline, state.TurnDeadline)
+	}
+
+	fake.Advance(10 * time.Second)
+	settle(room)
+	if got := queuedFrames(clients[0]); got != 0 {
+		t.Fatalf("expected no per-second state while the turn runs, got %d frames", got)
+	}
+
+	state = room.GetGameStateForPlayer(0)
+	if state.TimeLeft != MatchingTimeLimit-10 || state.TurnDeadline != wantDeadline {
+		t.Fatalf("expected %ds left before deadline %d, got %ds and %d", MatchingTimeLimit-10, wantDeadline, state.TimeLeft, state.TurnDeadline)
+	}
+	if state.ServerTime != fake.Now().UnixMilli() {
+		t.Fatalf("expected server time %d, got %d", fake.Now().UnixMilli(), state.ServerTime)
+	}
+}
@@ -1,172 +1,318 @@
 package game
 
 import (
+	"context"
+	"log"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"memory-feast-online/internal/clock"
 )
 
 const (
 	QueueTimeout = 60 * time.Second
+
+	queueCleanupInterval = 10 * time.Second
+	queueBackendTimeout  = 5 * time.Second
 )
 
 // QueueEntry represents a player waiting for a match
 type QueueEntry struct {
 	Player     *Player
-	Conn       *websocket.Conn
+	Conn       *websocket.Conn // nil for an opponent queued through another instance
 	JoinedAt   time.Time
 	PlateCount int
+	Instance   string // Instance holding the player's socket when it is not this one
 }
 
-// Matchmaker handles random matchmaking
+// Matchmaker handles random matchmaking. The queue itself is kept by a
+// QueueBackend; the matchmaker tracks the entries queued through this
+// instance, times them out and keeps them alive in a shared backend.
 type Matchmaker struct {
-	queue      []*QueueEntry
+	backend    QueueBackend
+	instance   string
+	local      map[string]*QueueEntry // session ID -> entry queued here
 	mu         sync.Mutex
 	onMatched  func(entry1, entry2 *QueueEntry) *Room
 	onTimedOut func(entry *QueueEntry)
+	clock      clock.Clock
 }
 
-// NewMatchmaker creates a new matchmaker instance
+// NewMatchmaker creates a new matchmaker instance with an in-memory queue.
+// clk may be nil, in which case the wall clock is used.
 func NewMatchmaker(
 	onMatched func(entry1, entry2 *QueueEntry) *Room,
 	onTimedOut func(entry *QueueEntry),
+	clk clock.Clock,
 ) *Matchmaker {
 	mm := &Matchmaker{
-		queue:      make([]*QueueEntry, 0),
+		backend:    NewMemoryQueue(),
+		local:      make(map[string]*QueueEntry),
 		onMatched:  onMatched,
 		onTimedOut: onTimedOut,
+		clock:      clock.OrReal(clk),
 	}
 
-	// Start cleanup goroutine
-	go mm.cleanupLoop()
+	// Start cleanup goroutine. The ticker is created here so a fake clock
+	// advanced right after construction already sees it.
+	go mm.cleanupLoop(mm.clock.NewTicker(queueCleanupInterval))
 
 	return mm
 }
 
-// JoinQueue adds a player to the matchmaking queue
-// Returns: position in queue, matched room (if immediately matched), or nil
-func (mm *Matchmaker) JoinQueue(player *Player, conn *websocket.Conn, plateCount int) (int, *Room) {
+// SetBackend moves the queue to a backend shared with other instances.
+// instance names this one in the tickets it queues. The entries queued on
+// the previous backend are dropped and returned, for the caller to Join
+// again.
+func (mm *Matchmaker) SetBackend(backend QueueBackend, instance string) []*QueueEntry {
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
+	mm.backend = backend
+	mm.instance = instance
+
+	queued := make([]*QueueEntry, 0, len(mm.local))
+	for _, entry := range mm.local {
+		queued = append(queued, entry)
+	}
+	clear(mm.local)
+	slices.SortFunc(queued, func(a, b *QueueEntry) int { return a.JoinedAt.Compare(b.JoinedAt) })
+	return queued
+}
+
+// JoinQueue adds a player whose socket this instance holds to the queue
+// Returns: position in queue, matched room (if immediately matched), or nil
+func (mm *Matchmaker) JoinQueue(player *Player, conn *websocket.Conn, plateCount int) (int, *Room) {
+	return mm.Join(&QueueEntry{Player: player, Conn: conn, PlateCount: plateCount})
+}
+
+// Join adds an entry to the queue, pairing it with the longest waiting
+// player if there is one. Position 0 without a room means the queue could
+// not be reached. The matchmaker's lock is not held while the backend or
+// onMatched runs.
+func (mm *Matchmaker) Join(entry *QueueEntry) (int, *Room) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
+
+	sessionID := entry.Player.SessionID
 
 	// Check if player is already in queue
-	for i, entry := range mm.queue {
-		if entry.Player.SessionID == player.SessionID {
-			// Update connection
-			entry.Conn = conn
-			entry.Player = player
-			return i + 1, nil
+	mm.mu.Lock()
+	backend := mm.backend
+	queued := mm.local[sessionID]
+	if queued != nil {
+		// Update connection
+		queued.Player = entry.Player
+		queued.Conn = entry.Conn
+		queued.Instance = entry.Instance
+	}
+	mm.mu.Unlock()
+
+	if queued != nil {
+		position, err := backend.Position(ctx, sessionID)
+		if err != nil {
+			log.Printf("failed to read queue position for session %s: %v", sessionID, err)
+			return 0, nil
+		}
+		if position > 0 {
+			return position, nil
 		}
+		// The ticket is gone, e.g. pruned after missing heartbeats while
+		// the backend was down, so the player queues again
 	}
 
-	entry := &QueueEntry{
-		Player:     player,
-		Conn:       conn,
-		JoinedAt:   time.Now(),
-		PlateCount: ClampPlateCount(plateCount),
+	entry.JoinedAt = mm.clock.Now()
+	entry.PlateCount = ClampPlateCount(entry.PlateCount)
+	ticket := &QueueTicket{
+		SessionID:  sessionID,
+		Nickname:   entry.Player.Nickname,
+		PlateCount: entry.PlateCount,
+		Instance:   entry.Instance,
+		JoinedAt:   entry.JoinedAt,
 	}
 
-	// Check for a match (FIFO - take first available)
-	if len(mm.queue) > 0 {
-		// Match with first player in queue
-		opponent := mm.queue[0]
-		mm.queue = mm.queue[1:]
+	// The entry is tracked before its ticket is pushed, so a Join pairing
+	// with the ticket meanwhile finds it
+	mm.mu.Lock()
+	if ticket.Instance == "" {
+		ticket.Instance = mm.instance
+	}
+	instance := mm.instance
+	mm.local[sessionID] = entry
+	mm.mu.Unlock()
+
+	for {
+		position, opponent, err := backend.Push(ctx, ticket)
+		if err != nil {
+			log.Printf("failed to join queue for session %s: %v", sessionID, err)
+			mm.untrack(sessionID, entry)
+			return 0, nil
+		}
+		if opponent == nil {
+			// No match found, added to queue
+			return position, nil
+		}
+
+		mm.mu.Lock()
+		if mm.local[sessionID] == entry {
+			delete(mm.local, sessionID)
+		}
+		match := mm.local[opponent.SessionID]
+		delete(mm.local, opponent.SessionID)
+		mm.mu.Unlock()
+
+		if match == nil {
+			if opponent.Instance == instance {
+				// Left behind by an earlier run of this instance
+				mm.mu.Lock()
+				mm.local[sessionID] = entry
+				mm.mu.Unlock()
+				continue
+			}
+			match = &QueueEntry{
+				JoinedAt:   opponent.JoinedAt,
+				PlateCount: opponent.PlateCount,
+				Instance:   opponent.Instance,
+			}
+			match.Player = NewPlayer(opponent.SessionID, opponent.Nickname, opponent.SessionID, nil)
+		}
 
 		// Create room via callback
-		if mm.onMatched != nil {
-			room := mm.onMatched(opponent, entry)
-			return 0, room
+		if mm.onMatched == nil {
+			return 0, nil
 		}
+		return 0, mm.onMatched(match, entry)
 	}
+}
 
-	// No match found, add to queue
-	mm.queue = append(mm.queue, entry)
-	return len(mm.queue), nil
+// untrack forgets the entry queued for a session, unless a later Join
+// replaced it
+func (mm *Matchmaker) untrack(sessionID string, entry *QueueEntry) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if mm.local[sessionID] == entry {
+		delete(mm.local, sessionID)
+	}
 }
 
 // LeaveQueue removes a player from the queue
 func (mm *Matchmaker) LeaveQueue(sessionID string) bool {
 	mm.mu.Lock()
-	defer mm.mu.Unlock()
+	delete(mm.local, sessionID)
+	backend := mm.backend
+	mm.mu.Unlock()
 
-	for i, entry := range mm.queue {
-		if entry.Player.SessionID == sessionID {
-			mm.queue = append(mm.queue[:i], mm.queue[i+1:]...)
-			return true
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
+
+	removed, err := backend.Remove(ctx, sessionID)
+	if err != nil {
+		log.Printf("failed to leave queue for session %s: %v", sessionID, err)
 	}
-	return false
+	return removed
 }
 
 // GetQueuePosition returns the player's position in queue (1-indexed)
 // Returns 0 if not in queue
 func (mm *Matchmaker) GetQueuePosition(sessionID string) int {
-	mm.mu.Lock()
-	defer mm.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
 
-	for i, entry := range mm.queue {
-		if entry.Player.SessionID == sessionID {
-			return i + 1
-		}
+	position, err := mm.queue().Position(ctx, sessionID)
+	if err != nil {
+		log.Printf("failed to read queue position for session %s: %v", sessionID, err)
 	}
-	return 0
+	return position
 }
 
 // QueueSize returns the current queue size
 func (mm *Matchmaker) QueueSize() int {
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
+
+	size, err := mm.queue().Size(ctx)
+	if err != nil {
+		log.Printf("failed to read queue size: %v", err)
+	}
+	return size
+}
+
+// queue returns the current backend
+func (mm *Matchmaker) queue() QueueBackend {
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
-	return len(mm.queue)
+	return mm.backend
 }
 
-// cleanupLoop removes timed-out entries from the queue
-func (mm *Matchmaker) cleanupLoop() {
-	ticker := time.NewTicker(10 * time.Second)
+// cleanupLoop removes timed-out entries from the queue and keeps the others
+// alive
+func (mm *Matchmaker) cleanupLoop(ticker clock.Ticker) {
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for range ticker.C() {
 		mm.cleanupTimedOut()
+		mm.heartbeat()
 	}
 }
 
 func (mm *Matchmaker) cleanupTimedOut() {
 	mm.mu.Lock()
-
-	now := time.Now()
-	newQueue := make([]*QueueEntry, 0, len(mm.queue))
-	timedOut := make([]*QueueEntry, 0)
+	now := mm.clock.Now()
+	expired := make([]*QueueEntry, 0)
+	for sessionID, entry := range mm.local {
+		if now.Sub(entry.JoinedAt) < QueueTimeout {
+			continue
+		}
+		delete(mm.local, sessionID)
+		expired = append(expired, entry)
+	}
+	backend := mm.backend
 	onTimedOut := mm.onTimedOut
+	mm.mu.Unlock()
 
-	for _, entry := range mm.queue {
-		if now.Sub(entry.JoinedAt) < QueueTimeout {
-			newQueue = append(newQueue, entry)
-		} else {
-			timedOut = append(timedOut, entry)
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
+
+	for _, entry := range expired {
+		// Another instance may have matched the player meanwhile
+		removed, err := backend.Remove(ctx, entry.Player.SessionID)
+		if err != nil {
+			log.Printf("failed to time out queue entry for session %s: %v", entry.Player.SessionID, err)
+			continue
+		}
+		if removed && onTimedOut != nil {
+			onTimedOut(entry)
 		}
 	}
+}
 
-	mm.queue = newQueue
+// heartbeat keeps the entries queued through this instance alive
+func (mm *Matchmaker) heartbeat() {
+	mm.mu.Lock()
+	sessionIDs := make([]string, 0, len(mm.local))
+	for sessionID := range mm.local {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	backend := mm.backend
 	mm.mu.Unlock()
 
-	if onTimedOut == nil {
+	if len(sessionIDs) == 0 {
 		return
 	}
 
-	for _, entry := range timedOut {
-		onTimedOut(entry)
+	ctx, cancel := context.WithTimeout(context.Background(), queueBackendTimeout)
+	defer cancel()
+
+	if err := backend.Heartbeat(ctx, sessionIDs); err != nil {
+		log.Printf("failed to refresh %d queue entries: %v", len(sessionIDs), err)
 	}
 }
 
-// GetEntryBySessionID finds a queue entry by session ID
+// GetEntryBySessionID finds a queue entry queued through this instance by
+// session ID
 func (mm *Matchmaker) GetEntryBySessionID(sessionID string) *QueueEntry {
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
-
-	for _, entry := range mm.queue {
-		if entry.Player.SessionID == sessionID {
-			return entry
-		}
-	}
-	return nil
+	return mm.local[sessionID]
 }
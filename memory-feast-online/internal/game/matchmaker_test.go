@@ -1,15 +1,18 @@
 package game
 
 import (
+	"context"
 	"testing"
 	"time"
+
+	"memory-feast-online/internal/clock"
 )
 
 func TestCleanupTimedOutRemovesEntryAndCallsCallback(t *testing.T) {
 	timedOutSessions := make([]string, 0, 1)
 	mm := NewMatchmaker(nil, func(entry *QueueEntry) {
 		timedOutSessions = append(timedOutSessions, entry.Player.SessionID)
-	})
+	}, nil)
 
 	player := NewPlayer("player-1", "Tester", "session-timeout", nil)
 	position, room := mm.JoinQueue(player, nil, 20)
@@ -20,11 +23,11 @@ func TestCleanupTimedOutRemovesEntryAndCallsCallback(t *testing.T) {
 		t.Fatalf("expected no room, got %v", room)
 	}
 
-	if len(mm.queue) != 1 {
-		t.Fatalf("expected one queue entry, got %d", len(mm.queue))
+	if len(mm.local) != 1 {
+		t.Fatalf("expected one queue entry, got %d", len(mm.local))
 	}
 
-	mm.queue[0].JoinedAt = time.Now().Add(-QueueTimeout - time.Second)
+	mm.local["session-timeout"].JoinedAt = time.Now().Add(-QueueTimeout - time.Second)
 	mm.cleanupTimedOut()
 
 	if got := mm.QueueSize(); got != 0 {
@@ -42,7 +45,7 @@ func TestCleanupTimedOutKeepsRecentEntries(t *testing.T) {
 	callbackCalled := false
 	mm := NewMatchmaker(nil, func(entry *QueueEntry) {
 		callbackCalled = true
-	})
+	}, nil)
 
 	player := NewPlayer("player-2", "Active", "session-active", nil)
 	position, room := mm.JoinQueue(player, nil, 20)
@@ -64,7 +67,7 @@ func TestCleanupTimedOutKeepsRecentEntries(t *testing.T) {
 }
 
 func TestCleanupTimedOutWithNilCallback(t *testing.T) {
-	mm := NewMatchmaker(nil, nil)
+	mm := NewMatchmaker(nil, nil, nil)
 
 	player := NewPlayer("player-3", "NoCallback", "session-no-callback", nil)
 	position, room := mm.JoinQueue(player, nil, 20)
@@ -75,10 +78,141 @@ func TestCleanupTimedOutWithNilCallback(t *testing.T) {
 		t.Fatalf("expected no room, got %v", room)
 	}
 
-	mm.queue[0].JoinedAt = time.Now().Add(-QueueTimeout - time.Second)
+	mm.local["session-no-callback"].JoinedAt = time.Now().Add(-QueueTimeout - time.Second)
 	mm.cleanupTimedOut()
 
 	if got := mm.QueueSize(); got != 0 {
 		t.Fatalf("expected empty queue after cleanup, got %d", got)
 	}
 }
+
+func TestQueueTimesOutOnFakeClock(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	timedOut := make(chan string, 1)
+	mm := NewMatchmaker(nil, func(entry *QueueEntry) {
+		timedOut <- entry.Player.SessionID
+	}, fake)
+
+	mm.JoinQueue(NewPlayer("player-4", "Waiting", "session-fake", nil), nil, 20)
+
+	fake.Advance(QueueTimeout - time.Second)
+	if got := mm.QueueSize(); got != 1 {
+		t.Fatalf("expected player still queued before the timeout, got queue size %d", got)
+	}
+
+	fake.Advance(queueCleanupInterval)
+	select {
+	case sessionID := <-timedOut:
+		if sessionID != "session-fake" {
+			t.Fatalf("expected timeout for 'session-fake', got %q", sessionID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected queue timeout after advancing the clock")
+	}
+	if got := mm.QueueSize(); got != 0 {
+		t.Fatalf("expected empty queue after timeout, got %d", got)
+	}
+}
+
+func TestSharedQueueMatchesAcrossInstances(t *testing.T) {
+	queue := NewMemoryQueue()
+	matched := make(chan [2]*QueueEntry, 1)
+	onMatched := func(entry1, entry2 *QueueEntry) *Room {
+		matched <- [2]*QueueEntry{entry1, entry2}
+		return nil
+	}
+
+	a := NewMatchmaker(onMatched, nil, nil)
+	a.SetBackend(queue, "a")
+	b := NewMatchmaker(onMatched, nil, nil)
+	b.SetBackend(queue, "b")
+
+	if position, _ := a.JoinQueue(NewPlayer("session-a", "Alice", "session-a", nil), nil, 8); position != 1 {
+		t.Fatalf("expected queue position 1, got %d", position)
+	}
+	if got := b.GetQueuePosition("session-a"); got != 1 {
+		t.Fatalf("expected the other instance to see the queued player, got position %d", got)
+	}
+
+	b.JoinQueue(NewPlayer("session-b", "Bob", "session-b", nil), nil, 20)
+	entries := <-matched
+	opponent := entries[0]
+	if opponent.Player.SessionID != "session-a" || opponent.Player.Nickname != "Alice" {
+		t.Fatalf("expected the opponent queued on instance a, got %+v", opponent.Player)
+	}
+	if opponent.Instance != "a" || opponent.Conn != nil || opponent.PlateCount != 8 {
+		t.Fatalf("expected the opponent to be seated through instance a, got %+v", opponent)
+	}
+	if entries[1].Player.SessionID != "session-b" {
+		t.Fatalf("expected the joining player second, got %s", entries[1].Player.SessionID)
+	}
+	if got := queue.tickets; len(got) != 0 {
+		t.Fatalf("expected the pair to leave the queue, got %d tickets", len(got))
+	}
+}
+
+func TestRejoinQueuesAgainAfterTicketIsPruned(t *testing.T) {
+	queue := NewMemoryQueue()
+	mm := NewMatchmaker(nil, nil, nil)
+	mm.SetBackend(queue, "a")
+
+	player := NewPlayer("session-pruned", "Pruned", "session-pruned", nil)
+	mm.JoinQueue(player, nil, 20)
+
+	// The backend drops the ticket, as a shared queue does after missed heartbeats
+	if removed, _ := queue.Remove(context.Background(), "session-pruned"); !removed {
+		t.Fatal("expected the ticket to be removed")
+	}
+
+	if position, _ := mm.JoinQueue(player, nil, 20); position != 1 {
+		t.Fatalf("expected the player to queue again at position 1, got %d", position)
+	}
+	if got := mm.GetQueuePosition("session-pruned"); got != 1 {
+		t.Fatalf("expected the ticket back in the queue, got position %d", got)
+	}
+}
+
+func TestOnMatchedMayCallBackIntoMatchmaker(t *testing.T) {
+	var mm *Matchmaker
+	sizes := make(chan int, 1)
+	mm = NewMatchmaker(func(entry1, entry2 *QueueEntry) *Room {
+		sizes <- mm.QueueSize()
+		return nil
+	}, nil, nil)
+
+	mm.JoinQueue(NewPlayer("session-1", "One", "session-1", nil), nil, 20)
+
+	done := make(chan struct{})
+	go func() {
+		mm.JoinQueue(NewPlayer("session-2", "Two", "session-2", nil), nil, 20)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Join to return while onMatched reads the queue")
+	}
+	if got := <-sizes; got != 0 {
+		t.Fatalf("expected an empty queue once the pair matched, got %d", got)
+	}
+}
+
+func TestSetBackendReturnsLocallyQueuedEntries(t *testing.T) {
+	mm := NewMatchmaker(nil, nil, nil)
+	mm.JoinQueue(NewPlayer("session-local", "Local", "session-local", nil), nil, 20)
+
+	queued := mm.SetBackend(NewMemoryQueue(), "a")
+	if len(queued) != 1 || queued[0].Player.SessionID != "session-local" {
+		t.Fatalf("expected the locally queued entry back, got %v", queued)
+	}
+	if got := mm.GetEntryBySessionID("session-local"); got != nil {
+		t.Fatal("expected the local entry to be dropped")
+	}
+	if got := mm.QueueSize(); got != 0 {
+		t.Fatalf("expected the new backend to start empty, got %d", got)
+	}
+
+	if position, _ := mm.Join(queued[0]); position != 1 {
+		t.Fatalf("expected the entry to queue on the new backend, got position %d", position)
+	}
+}
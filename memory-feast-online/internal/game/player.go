@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"memory-feast-online/internal/clock"
 )
 
 // Player represents a connected player
@@ -13,10 +14,13 @@ type Player struct {
 	Nickname       string
 	SessionID      string
 	Tokens         int
+	Rating         int // Skill rating, used by FirstPlayerLowerRated
 	Conn           *websocket.Conn
 	ConnMu         sync.Mutex
 	WriteMu        sync.Mutex // Mutex for serializing writes to connection
 	DisconnectedAt *time.Time
+
+	clock clock.Clock // Set by the room the player is seated in; guarded by ConnMu
 }
 
 // NewPlayer creates a new player
@@ -50,7 +54,7 @@ func (p *Player) ClearConnection() {
 	p.ConnMu.Lock()
 	defer p.ConnMu.Unlock()
 	p.Conn = nil
-	now := time.Now()
+	now := clock.OrReal(p.clock).Now()
 	p.DisconnectedAt = &now
 }
 
@@ -64,11 +68,18 @@ func (p *Player) ClearConnectionIf(expected *websocket.Conn) bool {
 	}
 
 	p.Conn = nil
-	now := time.Now()
+	now := clock.OrReal(p.clock).Now()
 	p.DisconnectedAt = &now
 	return true
 }
 
+// setClock makes the player's disconnect bookkeeping follow the room's clock
+func (p *Player) setClock(c clock.Clock) {
+	p.ConnMu.Lock()
+	defer p.ConnMu.Unlock()
+	p.clock = c
+}
+
 // GetConnection returns the current connection (thread-safe)
 func (p *Player) GetConnection() *websocket.Conn {
 	p.ConnMu.Lock()
@@ -83,7 +94,7 @@ func (p *Player) DisconnectedDuration() time.Duration {
 	if p.DisconnectedAt == nil {
 		return 0
 	}
-	return time.Since(*p.DisconnectedAt)
+	return clock.OrReal(p.clock).Since(*p.DisconnectedAt)
 }
 
 // WriteMessage sends a message to the player's connection (thread-safe)
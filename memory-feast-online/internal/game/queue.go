@@ -0,0 +1,108 @@
+package game
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// QueueHeartbeatTTL is how long a queued player survives without a
+// heartbeat from the instance it queued through. Entries of instances that
+// went away expire after it.
+const QueueHeartbeatTTL = 3 * queueCleanupInterval
+
+// QueueTicket is a queued player as a QueueBackend keeps it: what any
+// instance needs to seat the player
+type QueueTicket struct {
+	SessionID  string    `json:"sessionId"`
+	Nickname   string    `json:"nickname"`
+	PlateCount int       `json:"plateCount"`
+	Instance   string    `json:"instance,omitempty"` // Instance holding the player's socket
+	JoinedAt   time.Time `json:"joinedAt"`
+}
+
+// QueueBackend holds the matchmaking queue, possibly shared between server
+// instances. Push must pair atomically, so two instances pushing at once
+// never take the same opponent.
+type QueueBackend interface {
+	// Push takes the longest waiting ticket as the ticket's opponent, or
+	// queues the ticket and returns its 1-indexed position. A session that
+	// is already queued keeps its place.
+	Push(ctx context.Context, ticket *QueueTicket) (int, *QueueTicket, error)
+	// Remove takes a session out of the queue, reporting whether it was queued
+	Remove(ctx context.Context, sessionID string) (bool, error)
+	// Position returns the session's 1-indexed position, or 0
+	Position(ctx context.Context, sessionID string) (int, error)
+	Size(ctx context.Context) (int, error)
+	// Heartbeat keeps the tickets of the sessions alive for QueueHeartbeatTTL
+	Heartbeat(ctx context.Context, sessionIDs []string) error
+}
+
+// MemoryQueue is a QueueBackend within one process. Its tickets live as
+// long as the process, so heartbeats are not needed.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	tickets []*QueueTicket
+}
+
+// NewMemoryQueue creates an empty in-process queue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) Push(_ context.Context, ticket *QueueTicket) (int, *QueueTicket, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.tickets {
+		if queued.SessionID == ticket.SessionID {
+			q.tickets[i] = ticket
+			return i + 1, nil, nil
+		}
+	}
+
+	// FIFO: the first player in the queue is the opponent
+	if len(q.tickets) > 0 {
+		opponent := q.tickets[0]
+		q.tickets = q.tickets[1:]
+		return 0, opponent, nil
+	}
+
+	q.tickets = append(q.tickets, ticket)
+	return len(q.tickets), nil, nil
+}
+
+func (q *MemoryQueue) Remove(_ context.Context, sessionID string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.tickets {
+		if queued.SessionID == sessionID {
+			q.tickets = append(q.tickets[:i], q.tickets[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (q *MemoryQueue) Position(_ context.Context, sessionID string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.tickets {
+		if queued.SessionID == sessionID {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (q *MemoryQueue) Size(_ context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tickets), nil
+}
+
+func (q *MemoryQueue) Heartbeat(_ context.Context, _ []string) error {
+	return nil
+}
@@ -8,46 +8,84 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"memory-feast-online/internal/clock"
 	"memory-feast-online/internal/ws"
 )
 
 const (
 	ReconnectGracePeriod = 30 * time.Second
+	RematchTimeout       = 30 * time.Second // Post-game window to request and accept a rematch
 	DefaultPlateCount    = 20
 	MatchingTimeLimit    = 60
 )
 
+// RoomSettings are the host-adjustable settings of a room
+type RoomSettings struct {
+	PlateCount        int
+	Ruleset           Ruleset
+	FirstPlayerPolicy FirstPlayerPolicy
+}
+
 // Room represents a game room
 type Room struct {
-	ID         string
-	Code       string // 6-char invite code
-	Players    [2]*Player
-	State      *GameState
-	PlateCount int
-	Hub        *ws.Hub // Hub for sending messages
-
-	mu               sync.RWMutex
-	timer            *time.Timer
-	timerTicker      *time.Ticker
-	timerDone        chan struct{}
-	placementPending bool // Lock to prevent multiple placements per turn
-	confirmPending   bool // Lock to block selections during confirm reveal
-	addTokenPending  bool // Lock to prevent multiple token additions per turn
+	ID                string
+	Code              string // 6-char invite code, assigned by CodeAllocator for invite rooms
+	Players           [2]*Player
+	State             *GameState
+	PlateCount        int
+	Ruleset           Ruleset
+	FirstPlayerPolicy FirstPlayerPolicy
+	Hub               *ws.Hub // Hub for sending messages
+
+	mu            sync.RWMutex
+	ready         [2]bool // Lobby ready check, only meaningful in PhaseWaiting
+	previousFirst int     // First player of the previous game between these players, or -1
+	startedAt     time.Time
+	clock         clock.Clock
+	topicSeats    [2]string           // Seated sessions last joined to the hub topic
+	spectators    map[string]struct{} // Sessions watching without a seat
+	codeExpiry    clock.Timer         // Closes the room if its game never starts
+	createdAt     time.Time
+
+	recordMu      sync.Mutex // Serializes saves of the room's store record
+	recordVersion int64      // Version the store record was last saved at, see SaveRecord
+
+	// Event loop, see loop.go. Fields below are owned by the loop goroutine.
+	events    chan roomEvent
+	quit      chan struct{}
+	runOnce   sync.Once
+	closeOnce sync.Once
+	running   bool // Guarded by mu
+	hooks     RoomHooks
+	turnTimer clock.Timer // Fires at State.TurnDeadline
+	step      clock.Timer // Pending delayed step, e.g. covering a placed token
+	nextStep  func()
+	grace     [2]clock.Timer
+	done      bool
+
+	states stateBroadcaster // Coalesced state sends, see broadcast.go
 
 	// Callbacks
 	onEmpty func(roomID string)
 }
 
-// NewRoom creates a new room
-func NewRoom(plateCount int) *Room {
+// NewRoom creates a new room. clk drives the room's timers; nil means the
+// wall clock.
+func NewRoom(plateCount int, clk clock.Clock) *Room {
 	plateCount = ClampPlateCount(plateCount)
+	clk = clock.OrReal(clk)
 
 	return &Room{
-		ID:         GenerateID(),
-		Code:       generateRoomCode(),
-		PlateCount: plateCount,
-		State:      NewGameState(plateCount),
+		ID:                GenerateID(),
+		PlateCount:        plateCount,
+		Ruleset:           RulesetClassic,
+		FirstPlayerPolicy: FirstPlayerRandom,
+		State:             NewGameState(plateCount),
+		previousFirst:     -1,
+		clock:             clk,
+		createdAt:         clk.Now(),
+		events:            make(chan roomEvent),
+		quit:              make(chan struct{}),
 	}
 }
 
@@ -60,11 +98,14 @@ func (r *Room) SetOnEmpty(callback func(roomID string)) {
 
 // AddPlayer adds a player to the room
 func (r *Room) AddPlayer(player *Player) (int, error) {
+	defer r.syncTopic()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	for i := 0; i < 2; i++ {
 		if r.Players[i] == nil {
+			player.setClock(r.clock)
 			r.Players[i] = player
 			return i, nil
 		}
@@ -75,14 +116,16 @@ func (r *Room) AddPlayer(player *Player) (int, error) {
 
 // RemovePlayer removes a player from the room
 func (r *Room) RemovePlayer(playerIndex int) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	if playerIndex < 0 || playerIndex > 1 {
 		return
 	}
+	defer r.syncTopic()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	r.Players[playerIndex] = nil
+	r.ready = [2]bool{} // Roster changed, everyone confirms again
 
 	// Check if room is empty
 	if r.Players[0] == nil && r.Players[1] == nil {
@@ -132,14 +175,215 @@ func (r *Room) GetOpponentNickname(playerIndex int) string {
 	return ""
 }
 
-// StartGame begins the game
+// SetReady records a player's ready flag while the room is in the lobby.
+// Returns true if both seats are filled and ready.
+func (r *Room) SetReady(playerIndex int, ready bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.State.Phase != PhaseWaiting {
+		return false, ErrInvalidPhase
+	}
+	if playerIndex < 0 || playerIndex > 1 || r.Players[playerIndex] == nil {
+		return false, ErrNotInRoom
+	}
+
+	r.ready[playerIndex] = ready
+	return r.allReadyLocked(), nil
+}
+
+// AllReady reports whether both seats are filled and ready
+func (r *Room) AllReady() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.allReadyLocked()
+}
+
+func (r *Room) allReadyLocked() bool {
+	return r.Players[0] != nil && r.Players[1] != nil && r.ready[0] && r.ready[1]
+}
+
+// Settings returns the room's current settings
+func (r *Room) Settings() RoomSettings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return RoomSettings{
+		PlateCount:        r.PlateCount,
+		Ruleset:           r.Ruleset,
+		FirstPlayerPolicy: r.FirstPlayerPolicy,
+	}
+}
+
+// UpdateSettings changes the room settings while in the lobby.
+// Ready flags are cleared so both players confirm the new settings.
+func (r *Room) UpdateSettings(settings RoomSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.State.Phase != PhaseWaiting {
+		return ErrInvalidPhase
+	}
+
+	plateCount := ClampPlateCount(settings.PlateCount)
+	r.PlateCount = plateCount
+	r.Ruleset = settings.Ruleset
+	if settings.FirstPlayerPolicy != "" {
+		r.FirstPlayerPolicy = settings.FirstPlayerPolicy
+	}
+	r.State = NewGameState(plateCount)
+	r.State.TurnTimeLimit = settings.Ruleset.TurnTimeLimit
+	r.State.TimeLeft = settings.Ruleset.TurnTimeLimit
+	r.ready = [2]bool{}
+	return nil
+}
+
+// SetPreviousFirstPlayer records who started the previous game between the
+// same players, used by FirstPlayerAlternate
+func (r *Room) SetPreviousFirstPlayer(index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.previousFirst = index
+}
+
+// SetCodeExpiry keeps the timer that closes the waiting room, stopping any
+// earlier one
+func (r *Room) SetCodeExpiry(t clock.Timer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.codeExpiry != nil {
+		r.codeExpiry.Stop()
+	}
+	r.codeExpiry = t
+}
+
+// StopCodeExpiry stops the waiting room's expiry once its game starts or
+// the room closes
+func (r *Room) StopCodeExpiry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.codeExpiry != nil {
+		r.codeExpiry.Stop()
+		r.codeExpiry = nil
+	}
+}
+
+// CreatedAt returns when the room was created
+func (r *Room) CreatedAt() time.Time {
+	return r.createdAt
+}
+
+// SaveRecord runs save with the version the room's store record was last
+// saved at, 0 before the first save, and keeps the version it returns.
+// Saves of one room run one at a time, so each builds its record from the
+// room as it is then. An error, such as a version conflict, keeps the old
+// version.
+func (r *Room) SaveRecord(save func(version int64) (int64, error)) error {
+	r.recordMu.Lock()
+	defer r.recordMu.Unlock()
+
+	version, err := save(r.recordVersion)
+	if err != nil {
+		return err
+	}
+	r.recordVersion = version
+	return nil
+}
+
+// Rematch creates a new waiting room with the same players, ratings and
+// settings, remembering who started this game
+func (r *Room) Rematch() *Room {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	next := NewRoom(r.PlateCount, r.clock)
+	next.Hub = r.Hub
+	next.Ruleset = r.Ruleset
+	next.FirstPlayerPolicy = r.FirstPlayerPolicy
+	next.State.TurnTimeLimit = r.Ruleset.TurnTimeLimit
+	next.State.TimeLeft = r.Ruleset.TurnTimeLimit
+	next.previousFirst = r.State.FirstPlayer
+
+	for i, p := range r.Players {
+		if p == nil {
+			continue
+		}
+		p.ConnMu.Lock()
+		conn := p.Conn
+		p.ConnMu.Unlock()
+
+		player := NewPlayer(p.ID, p.Nickname, p.SessionID, conn)
+		player.Rating = p.Rating
+		player.setClock(next.clock)
+		next.Players[i] = player
+	}
+	return next
+}
+
+// GetFirstPlayer returns the index of the player who started this game
+func (r *Room) GetFirstPlayer() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.State.FirstPlayer
+}
+
+// StartedAt returns when the game left the lobby
+func (r *Room) StartedAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.startedAt
+}
+
+// GetLobbyState returns the pre-game lobby snapshot
+func (r *Room) GetLobbyState() ws.LobbyStatePayload {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	players := make([]ws.LobbyPlayerInfo, 2)
+	for i, p := range r.Players {
+		if p != nil {
+			players[i] = ws.LobbyPlayerInfo{
+				Nickname:    p.Nickname,
+				Seated:      true,
+				Ready:       r.ready[i],
+				IsConnected: p.IsConnected(),
+			}
+		}
+	}
+
+	return ws.LobbyStatePayload{
+		RoomID:            r.ID,
+		RoomCode:          r.Code,
+		HostIndex:         0,
+		PlateCount:        r.PlateCount,
+		Ruleset:           r.Ruleset.Name,
+		FirstPlayerPolicy: string(r.FirstPlayerPolicy),
+		Players:           players,
+	}
+}
+
+// BroadcastLobbyState sends the lobby snapshot to all seated players
+func (r *Room) BroadcastLobbyState() {
+	msg, err := ws.NewMessage(ws.MsgLobbyState, r.GetLobbyState())
+	if err != nil {
+		log.Printf("Error creating lobby state message: %v", err)
+		return
+	}
+	r.BroadcastMessage(msg)
+}
+
+// StartGame begins the game with the first player picked by the room's
+// FirstPlayerPolicy
 func (r *Room) StartGame() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	first := r.FirstPlayerPolicy.choose(r.Players, r.previousFirst)
+
 	r.State.Phase = PhasePlacement
-	r.State.CurrentTurn = 0
+	r.State.FirstPlayer = first
+	r.State.CurrentTurn = first
 	r.State.PlacementRound = 1
+	r.startedAt = r.clock.Now()
 }
 
 // StartMatchingPhase transitions to matching phase
@@ -158,8 +402,8 @@ func (r *Room) StartMatchingPhase() {
 	r.State.StartMatchingPhase(initialTokens)
 }
 
-// HandlePlaceToken handles a token placement
-func (r *Room) HandlePlaceToken(playerIndex, plateIndex int) bool {
+// handlePlaceToken handles a token placement
+func (r *Room) handlePlaceToken(playerIndex, plateIndex int) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -169,23 +413,15 @@ func (r *Room) HandlePlaceToken(playerIndex, plateIndex int) bool {
 	if r.State.CurrentTurn != playerIndex {
 		return false
 	}
-	if r.placementPending {
-		return false // Block multiple placements per turn
-	}
 
-	if r.State.PlaceToken(plateIndex) {
-		r.placementPending = true // Lock until turn advances
-		return true
-	}
-	return false
+	return r.State.PlaceToken(plateIndex)
 }
 
-// AdvancePlacement moves to next placement turn
+// advancePlacement moves to next placement turn
 // Returns true if placement phase is complete
-func (r *Room) AdvancePlacement() bool {
+func (r *Room) advancePlacement() bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.placementPending = false // Reset lock for next turn
 	return r.State.NextPlacementTurn()
 }
 
@@ -202,8 +438,8 @@ func (r *Room) CoverPlate(index int) bool {
 	return true
 }
 
-// HandleSelectPlate handles plate selection during matching
-func (r *Room) HandleSelectPlate(playerIndex, plateIndex int) bool {
+// handleSelectPlate handles plate selection during matching
+func (r *Room) handleSelectPlate(playerIndex, plateIndex int) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -213,16 +449,13 @@ func (r *Room) HandleSelectPlate(playerIndex, plateIndex int) bool {
 	if r.State.CurrentTurn != playerIndex {
 		return false
 	}
-	if r.confirmPending {
-		return false // Block selections during confirm reveal
-	}
 
 	return r.State.SelectPlate(plateIndex)
 }
 
-// HandleConfirmMatch handles match confirmation
+// handleConfirmMatch handles match confirmation
 // Returns: success, matched, plate1Tokens, plate2Tokens
-func (r *Room) HandleConfirmMatch(playerIndex int) (bool, bool, int, int) {
+func (r *Room) handleConfirmMatch(playerIndex int) (bool, bool, int, int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -235,26 +468,21 @@ func (r *Room) HandleConfirmMatch(playerIndex int) (bool, bool, int, int) {
 	if len(r.State.SelectedPlates) != 2 {
 		return false, false, 0, 0
 	}
-	if r.confirmPending {
-		return false, false, 0, 0
-	}
 
-	r.confirmPending = true // Lock selections during reveal
 	matched, t1, t2 := r.State.ConfirmMatch()
 	return true, matched, t1, t2
 }
 
-// SetAddTokenPhase transitions to add token phase
-func (r *Room) SetAddTokenPhase() {
+// setAddTokenPhase transitions to add token phase
+func (r *Room) setAddTokenPhase() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.confirmPending = false // Reset lock after transition
 	r.State.SetAddTokenPhase()
 }
 
-// HandleAddToken handles adding a token to matched plate
+// handleAddToken handles adding a token to matched plate
 // Returns: success, newTokenCount, playerWon
-func (r *Room) HandleAddToken(playerIndex, plateIndex int) (bool, int, bool) {
+func (r *Room) handleAddToken(playerIndex, plateIndex int) (bool, int, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -264,16 +492,11 @@ func (r *Room) HandleAddToken(playerIndex, plateIndex int) (bool, int, bool) {
 	if r.State.CurrentTurn != playerIndex {
 		return false, 0, false
 	}
-	if r.addTokenPending {
-		return false, 0, false // Block multiple token additions per turn
-	}
 
 	if !r.State.AddToken(plateIndex) {
 		return false, 0, false
 	}
 
-	r.addTokenPending = true // Lock until turn advances
-
 	// Decrease player tokens
 	r.Players[playerIndex].Tokens--
 	playerWon := r.Players[playerIndex].Tokens <= 0
@@ -281,8 +504,8 @@ func (r *Room) HandleAddToken(playerIndex, plateIndex int) (bool, int, bool) {
 	return true, r.State.Plates[plateIndex].Tokens, playerWon
 }
 
-// HandleMatchFail handles a failed match
-func (r *Room) HandleMatchFail(playerIndex int) {
+// handleMatchFail handles a failed match
+func (r *Room) handleMatchFail(playerIndex int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -294,11 +517,11 @@ func (r *Room) HandleMatchFail(playerIndex int) {
 	}
 
 	// Add penalty token
-	r.Players[playerIndex].Tokens++
+	r.Players[playerIndex].Tokens += r.Ruleset.FailPenalty
 }
 
-// HandleTimeout handles turn timeout
-func (r *Room) HandleTimeout(playerIndex int) {
+// handleTimeout handles turn timeout
+func (r *Room) handleTimeout(playerIndex int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -309,19 +532,16 @@ func (r *Room) HandleTimeout(playerIndex int) {
 		return
 	}
 
-	// Add penalty tokens (2 for timeout)
-	r.Players[playerIndex].Tokens += 2
+	// Add penalty tokens (2 for timeout under classic rules)
+	r.Players[playerIndex].Tokens += r.Ruleset.TimeoutPenalty
 }
 
-// AdvanceMatching moves to next matching turn
+// advanceMatching moves to next matching turn
 // Returns true if game should continue, false if no more matches
-func (r *Room) AdvanceMatching() bool {
+func (r *Room) advanceMatching() bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.confirmPending = false  // Reset lock for next turn
-	r.addTokenPending = false // Reset add-token lock for next turn
-
 	if !r.State.HasMatchingPairs() {
 		return false
 	}
@@ -367,65 +587,6 @@ func (r *Room) SetFinished() {
 	r.State.SetFinished()
 }
 
-// StartTimer starts the matching phase timer
-func (r *Room) StartTimer(onTick func(timeLeft int), onTimeout func()) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	r.stopTimerLocked()
-
-	r.State.TimeLeft = MatchingTimeLimit
-	r.timerDone = make(chan struct{})
-
-	r.timerTicker = time.NewTicker(1 * time.Second)
-	timerDone := r.timerDone
-	timerTick := r.timerTicker.C
-
-	go func(done <-chan struct{}, tick <-chan time.Time) {
-		for {
-			select {
-			case <-done:
-				return
-			case <-tick:
-				r.mu.Lock()
-				r.State.TimeLeft--
-				timeLeft := r.State.TimeLeft
-				r.mu.Unlock()
-
-				if onTick != nil {
-					onTick(timeLeft)
-				}
-
-				if timeLeft <= 0 {
-					r.StopTimer()
-					if onTimeout != nil {
-						onTimeout()
-					}
-					return
-				}
-			}
-		}
-	}(timerDone, timerTick)
-}
-
-// StopTimer stops the current timer
-func (r *Room) StopTimer() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.stopTimerLocked()
-}
-
-func (r *Room) stopTimerLocked() {
-	if r.timerTicker != nil {
-		r.timerTicker.Stop()
-		r.timerTicker = nil
-	}
-	if r.timerDone != nil {
-		close(r.timerDone)
-		r.timerDone = nil
-	}
-}
-
 // GetGameState returns a snapshot of the game state (generic, no player-specific data)
 func (r *Room) GetGameState() ws.GameStatePayload {
 	return r.GetGameStateForPlayer(-1) // -1 means generic state
@@ -471,26 +632,39 @@ func (r *Room) GetGameStateForPlayer(playerIndex int) ws.GameStatePayload {
 		}
 	}
 
+	now := r.clock.Now()
+	timeLeft := r.State.TimeLeft
+	var deadline int64
+	if !r.State.TurnDeadline.IsZero() {
+		timeLeft = secondsUntil(r.State.TurnDeadline, now)
+		deadline = r.State.TurnDeadline.UnixMilli()
+	}
+
 	state := ws.GameStatePayload{
-		Phase:           string(r.State.Phase),
-		CurrentTurn:     r.State.CurrentTurn,
-		PlacementRound:  r.State.PlacementRound,
-		MaxRound:        r.State.MaxRound,
-		TimeLeft:        r.State.TimeLeft,
-		Players:         players,
-		Plates:          plates,
-		SelectedPlates:  []int{},
-		MatchedPlates:   r.State.MatchedPlates,
-		LastActionPlate: r.State.LastActionPlate,
+		Phase:             string(r.State.Phase),
+		CurrentTurn:       r.State.CurrentTurn,
+		PlacementRound:    r.State.PlacementRound,
+		MaxRound:          r.State.MaxRound,
+		TimeLeft:          timeLeft,
+		TurnDeadline:      deadline,
+		ServerTime:        now.UnixMilli(),
+		Ruleset:           r.Ruleset.Name,
+		FirstPlayer:       r.State.FirstPlayer,
+		FirstPlayerPolicy: string(r.FirstPlayerPolicy),
+		Players:           players,
+		Plates:            plates,
+		SelectedPlates:    []int{},
+		MatchedPlates:     r.State.MatchedPlates,
+		LastActionPlate:   r.State.LastActionPlate,
 	}
 
 	// During matching phase, show selections appropriately
-	if r.State.Phase == PhaseMatching && playerIndex >= 0 {
+	if r.State.Phase == PhaseMatching {
 		if playerIndex == r.State.CurrentTurn {
 			// Current turn player sees their own selections as "selected"
 			state.SelectedPlates = r.State.SelectedPlates
 		} else {
-			// Opponent sees current turn player's selections as "opponent selected"
+			// Opponent and spectators see current turn player's selections as "opponent selected"
 			state.OpponentSelectedPlates = r.State.SelectedPlates
 		}
 	} else {
@@ -502,57 +676,56 @@ func (r *Room) GetGameStateForPlayer(playerIndex int) ws.GameStatePayload {
 	return state
 }
 
-// BroadcastState sends game state to all connected players
-// Each player receives state with appropriate selection visibility
-func (r *Room) BroadcastState() {
-	// Collect session IDs first while holding lock
-	r.mu.RLock()
-	sessionIDs := make([]string, 2)
-	playerIDs := make([]string, 2)
-	for i, p := range r.Players {
-		if p != nil {
-			sessionIDs[i] = p.SessionID
-			playerIDs[i] = p.ID
-		}
-	}
-	hub := r.Hub
-	r.mu.RUnlock()
+// BroadcastStateWithMessage sends game state with a one-off message to each
+// player right away. It supersedes a pending coalesced snapshot.
+func (r *Room) BroadcastStateWithMessage(message, messageType string) {
+	r.states.mu.Lock()
+	defer r.states.mu.Unlock()
+	r.states.stopLocked()
 
-	if hub == nil {
-		return
-	}
-
-	// Send to each player with their specific state
 	for i := 0; i < 2; i++ {
-		if sessionIDs[i] == "" {
-			continue
-		}
-
-		client := hub.GetClient(sessionIDs[i])
+		client := r.clientFor(i)
 		if client == nil {
 			continue
 		}
 
 		state := r.GetGameStateForPlayer(i)
+		state.Message = message
+		state.MessageType = messageType
+
 		msg, err := ws.NewMessage(ws.MsgGameState, state)
 		if err != nil {
 			log.Printf("Error creating game state message: %v", err)
 			continue
 		}
 
-		msgBytes, err := json.Marshal(msg)
-		if err != nil {
-			log.Printf("Error marshaling game state: %v", err)
-			continue
+		// Sent as a control frame so the message is never coalesced away
+		if err := client.SendMessage(msg); err != nil {
+			log.Printf("Error sending game state to player %d in room %s: %v", i, r.ID, err)
 		}
+	}
 
-		if err := client.WriteMessageDirect(websocket.TextMessage, msgBytes); err != nil {
-			log.Printf("Error sending game state to player %s: %v", playerIDs[i], err)
+	spectators := r.spectatorClients()
+	if len(spectators) == 0 {
+		return
+	}
+	state := r.GetGameStateForPlayer(SpectatorViewer)
+	state.Message = message
+	state.MessageType = messageType
+	msg, err := ws.NewMessage(ws.MsgGameState, state)
+	if err != nil {
+		log.Printf("Error creating spectator game state message: %v", err)
+		return
+	}
+	for _, client := range spectators {
+		if err := client.SendMessage(msg); err != nil {
+			log.Printf("Error sending game state to spectator %s in room %s: %v", client.SessionID, r.ID, err)
 		}
 	}
 }
 
-// BroadcastMessage sends a message to all connected players
+// BroadcastMessage sends a message to all connected players, after any
+// pending state snapshot
 func (r *Room) BroadcastMessage(msg *ws.Message) {
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
@@ -561,40 +734,145 @@ func (r *Room) BroadcastMessage(msg *ws.Message) {
 	}
 
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	hub := r.Hub
+	r.mu.RUnlock()
+	if hub == nil {
+		return
+	}
 
-	for _, p := range r.Players {
-		if p != nil && r.Hub != nil {
-			client := r.Hub.GetClient(p.SessionID)
-			if client != nil {
-				if err := client.WriteMessageDirect(websocket.TextMessage, msgBytes); err != nil {
-					log.Printf("Error sending message to player %s: %v", p.ID, err)
-				}
-			}
+	r.flushState()
+	r.syncTopic()
+	hub.Publish(r.Topic(), ws.FrameControl, msgBytes)
+}
+
+// SendToPlayer sends a message to a specific player, after any pending
+// state snapshot
+func (r *Room) SendToPlayer(playerIndex int, msg *ws.Message) error {
+	client := r.clientFor(playerIndex)
+	if client == nil {
+		return nil
+	}
+	r.flushState()
+	return client.SendMessage(msg)
+}
+
+// Topic is the hub topic fanning out the room's messages to its seated
+// players and anyone else following the room
+func (r *Room) Topic() string {
+	return "room:" + r.ID
+}
+
+// syncTopic keeps the hub topic in step with the seats. Seats may be filled
+// directly, so it also runs before every publish.
+func (r *Room) syncTopic() {
+	r.mu.Lock()
+	hub := r.Hub
+	if hub == nil {
+		r.mu.Unlock()
+		return
+	}
+
+	var left, joined []string
+	for i, p := range r.Players {
+		session := ""
+		if p != nil {
+			session = p.SessionID
+		}
+		if session == r.topicSeats[i] {
+			continue
+		}
+		if r.topicSeats[i] != "" {
+			left = append(left, r.topicSeats[i])
 		}
+		if session != "" {
+			joined = append(joined, session)
+		}
+		r.topicSeats[i] = session
+	}
+	r.mu.Unlock()
+
+	for _, session := range left {
+		hub.Leave(r.Topic(), session)
+	}
+	for _, session := range joined {
+		hub.Join(r.Topic(), session)
 	}
 }
 
-// SendToPlayer sends a message to a specific player
-func (r *Room) SendToPlayer(playerIndex int, msg *ws.Message) error {
+// AddSpectator lets a session without a seat follow the room's game state
+// and messages
+func (r *Room) AddSpectator(sessionID string) {
+	r.mu.Lock()
+	if r.spectators == nil {
+		r.spectators = make(map[string]struct{})
+	}
+	r.spectators[sessionID] = struct{}{}
+	hub := r.Hub
+	r.mu.Unlock()
+
+	if hub != nil {
+		hub.Join(r.Topic(), sessionID)
+	}
+}
+
+// RemoveSpectator stops sending the room to a spectator
+func (r *Room) RemoveSpectator(sessionID string) {
+	r.mu.Lock()
+	delete(r.spectators, sessionID)
+	hub := r.Hub
+	r.mu.Unlock()
+
+	if hub != nil {
+		hub.Leave(r.Topic(), sessionID)
+	}
+}
+
+// Spectators returns the sessions watching the room
+func (r *Room) Spectators() []string {
 	r.mu.RLock()
-	player := r.Players[playerIndex]
-	r.mu.RUnlock()
+	defer r.mu.RUnlock()
 
-	if player == nil || r.Hub == nil {
+	sessions := make([]string, 0, len(r.spectators))
+	for session := range r.spectators {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// spectatorClients returns the connected spectators' hub clients
+func (r *Room) spectatorClients() []*ws.Client {
+	r.mu.RLock()
+	hub := r.Hub
+	r.mu.RUnlock()
+	if hub == nil {
 		return nil
 	}
 
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		return err
+	sessions := r.Spectators()
+	clients := make([]*ws.Client, 0, len(sessions))
+	for _, session := range sessions {
+		if c := hub.GetClient(session); c != nil {
+			clients = append(clients, c)
+		}
 	}
+	return clients
+}
 
-	client := r.Hub.GetClient(player.SessionID)
-	if client != nil {
-		return client.WriteMessageDirect(websocket.TextMessage, msgBytes)
+// clientFor returns the hub client of a seated player, or nil. Sends happen
+// after the room lock is released; they only queue and never block on I/O.
+func (r *Room) clientFor(playerIndex int) *ws.Client {
+	if playerIndex < 0 || playerIndex > 1 {
+		return nil
 	}
-	return nil
+
+	r.mu.RLock()
+	player, hub := r.Players[playerIndex], r.Hub
+	r.mu.RUnlock()
+
+	if player == nil || hub == nil {
+		return nil
+	}
+	return hub.GetClient(player.SessionID)
 }
 
 // Error types
@@ -606,7 +884,13 @@ const (
 	ErrRoomFull     RoomError = "room is full"
 	ErrRoomNotFound RoomError = "room not found"
 	ErrNotYourTurn  RoomError = "not your turn"
+	ErrNotInRoom    RoomError = "player not in room"
 	ErrInvalidPhase RoomError = "invalid phase for this action"
+	ErrNoRoomCode   RoomError = "could not allocate room code"
+
+	ErrInvalidAction RoomError = "invalid action"
+	ErrActionPending RoomError = "previous action still in progress"
+	ErrRoomClosed    RoomError = "room is closed"
 )
 
 // Helper functions
@@ -618,16 +902,6 @@ func GenerateID() string {
 	return hex.EncodeToString(bytes)
 }
 
-func generateRoomCode() string {
-	const charset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Excluding confusing chars
-	code := make([]byte, 6)
-	rand.Read(code)
-	for i := range code {
-		code[i] = charset[int(code[i])%len(charset)]
-	}
-	return string(code)
-}
-
 // ClampPlateCount normalizes plate count to game constraints.
 func ClampPlateCount(plateCount int) int {
 	if plateCount%2 != 0 {
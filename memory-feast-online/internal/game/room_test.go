@@ -1,9 +1,14 @@
 package game
 
-import "testing"
+import (
+	"slices"
+	"testing"
+
+	"memory-feast-online/internal/ws"
+)
 
 func TestCoverPlateSetsCoveredForValidIndex(t *testing.T) {
-	room := NewRoom(4)
+	room := NewRoom(4, nil)
 	room.StartGame()
 
 	room.mu.Lock()
@@ -23,7 +28,7 @@ func TestCoverPlateSetsCoveredForValidIndex(t *testing.T) {
 }
 
 func TestCoverPlateReturnsFalseForInvalidIndex(t *testing.T) {
-	room := NewRoom(4)
+	room := NewRoom(4, nil)
 
 	if ok := room.CoverPlate(-1); ok {
 		t.Fatalf("expected CoverPlate(-1) to fail")
@@ -33,9 +38,11 @@ func TestCoverPlateReturnsFalseForInvalidIndex(t *testing.T) {
 	}
 }
 
-func TestHandleAddToken_BlocksDoubleAdd(t *testing.T) {
-	room := NewRoom(4)
+func TestAddTokenBlocksDoubleAdd(t *testing.T) {
+	room := NewRoom(4, nil)
 	room.StartGame()
+	room.Run(RoomHooks{})
+	defer room.Close()
 
 	// Setup: Initialize players and create PhaseAddToken state with matched plates
 	room.mu.Lock()
@@ -49,15 +56,13 @@ func TestHandleAddToken_BlocksDoubleAdd(t *testing.T) {
 	room.mu.Unlock()
 
 	// Act: First call (should succeed)
-	ok1, _, _ := room.HandleAddToken(0, 0)
-	if !ok1 {
-		t.Fatalf("expected first HandleAddToken to succeed")
+	if err := room.AddToken(0, 0); err != nil {
+		t.Fatalf("expected first AddToken to succeed, got %v", err)
 	}
 
-	// Act: Second call (currently succeeds — BUG; should fail after fix)
-	ok2, _, _ := room.HandleAddToken(0, 1)
-	if ok2 {
-		t.Fatalf("expected second HandleAddToken to be blocked (bug: currently succeeds)")
+	// Act: Second call while the first is still playing out
+	if err := room.AddToken(0, 1); err != ErrActionPending {
+		t.Fatalf("expected second AddToken to be blocked, got %v", err)
 	}
 }
 
@@ -89,14 +94,14 @@ func TestClampPlateCount(t *testing.T) {
 
 func TestGetWinnerHandlesMissingPlayers(t *testing.T) {
 	t.Run("both missing draw", func(t *testing.T) {
-		room := NewRoom(4)
+		room := NewRoom(4, nil)
 		if got := room.GetWinner(); got != -1 {
 			t.Fatalf("expected draw (-1), got %d", got)
 		}
 	})
 
 	t.Run("player0 missing player1 wins", func(t *testing.T) {
-		room := NewRoom(4)
+		room := NewRoom(4, nil)
 		room.mu.Lock()
 		room.Players[1] = &Player{Tokens: 3}
 		room.mu.Unlock()
@@ -107,7 +112,7 @@ func TestGetWinnerHandlesMissingPlayers(t *testing.T) {
 	})
 
 	t.Run("player1 missing player0 wins", func(t *testing.T) {
-		room := NewRoom(4)
+		room := NewRoom(4, nil)
 		room.mu.Lock()
 		room.Players[0] = &Player{Tokens: 2}
 		room.mu.Unlock()
@@ -118,8 +123,10 @@ func TestGetWinnerHandlesMissingPlayers(t *testing.T) {
 	})
 }
 
-func TestHandleConfirmMatchBlocksReentry(t *testing.T) {
-	room := NewRoom(4)
+func TestConfirmMatchBlocksReentry(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Run(RoomHooks{})
+	defer room.Close()
 
 	room.mu.Lock()
 	room.State.Phase = PhaseMatching
@@ -129,13 +136,182 @@ func TestHandleConfirmMatchBlocksReentry(t *testing.T) {
 	room.State.Plates[1].Tokens = 1
 	room.mu.Unlock()
 
-	ok, _, _, _ := room.HandleConfirmMatch(0)
-	if !ok {
-		t.Fatalf("expected first HandleConfirmMatch to succeed")
+	if err := room.ConfirmMatch(0); err != nil {
+		t.Fatalf("expected first ConfirmMatch to succeed, got %v", err)
+	}
+
+	if err := room.ConfirmMatch(0); err != ErrActionPending {
+		t.Fatalf("expected second ConfirmMatch to be blocked during the reveal, got %v", err)
+	}
+	if err := room.SelectPlate(0, 2); err != ErrActionPending {
+		t.Fatalf("expected selections to be blocked during the reveal, got %v", err)
+	}
+}
+
+func TestSetReadyStartsOnlyWhenBothSeatedAndReady(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+
+	allReady, err := room.SetReady(0, true)
+	if err != nil {
+		t.Fatalf("SetReady failed: %v", err)
+	}
+	if allReady {
+		t.Fatalf("expected lone ready player not to start the game")
+	}
+
+	room.Players[1] = &Player{SessionID: "s2"}
+	if allReady, _ := room.SetReady(1, true); !allReady {
+		t.Fatalf("expected both ready players to report all ready")
+	}
+
+	if _, err := room.SetReady(0, false); err != nil {
+		t.Fatalf("SetReady(false) failed: %v", err)
+	}
+	if room.AllReady() {
+		t.Fatalf("expected un-ready to clear all ready")
+	}
+}
+
+func TestSetReadyRejectedOutsideLobby(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.StartGame()
+
+	if _, err := room.SetReady(0, true); err != ErrInvalidPhase {
+		t.Fatalf("expected ErrInvalidPhase, got %v", err)
+	}
+}
+
+func TestUpdateSettingsResetsBoardAndReadyFlags(t *testing.T) {
+	room := NewRoom(20, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.SetReady(0, true)
+
+	if err := room.UpdateSettings(RoomSettings{PlateCount: 7, Ruleset: RulesetBlitz}); err != nil {
+		t.Fatalf("UpdateSettings failed: %v", err)
+	}
+
+	if room.PlateCount != 8 {
+		t.Fatalf("expected clamped plate count 8, got %d", room.PlateCount)
+	}
+	if len(room.State.Plates) != 8 {
+		t.Fatalf("expected board of 8 plates, got %d", len(room.State.Plates))
+	}
+	if room.State.TurnTimeLimit != RulesetBlitz.TurnTimeLimit {
+		t.Fatalf("expected turn limit %d, got %d", RulesetBlitz.TurnTimeLimit, room.State.TurnTimeLimit)
+	}
+	if lobby := room.GetLobbyState(); lobby.Players[0].Ready {
+		t.Fatalf("expected settings change to clear ready flags")
+	}
+
+	room.StartGame()
+	if err := room.UpdateSettings(RoomSettings{PlateCount: 4, Ruleset: RulesetClassic}); err != ErrInvalidPhase {
+		t.Fatalf("expected settings to be locked after start, got %v", err)
+	}
+}
+
+func TestRemovePlayerClearsReadyFlags(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.SetReady(0, true)
+	room.SetReady(1, true)
+
+	room.RemovePlayer(1)
+
+	lobby := room.GetLobbyState()
+	if lobby.Players[0].Ready || lobby.Players[1].Seated {
+		t.Fatalf("expected roster change to clear ready flags and free seat, got %+v", lobby.Players)
+	}
+}
+
+func TestRulesetPenalties(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.UpdateSettings(RoomSettings{
+		PlateCount: 4,
+		Ruleset:    Ruleset{Name: "custom", TurnTimeLimit: 10, FailPenalty: 3, TimeoutPenalty: 5},
+	})
+	room.Players[0] = &Player{Tokens: 0}
+
+	room.handleMatchFail(0)
+	room.handleTimeout(0)
+
+	if got := room.Players[0].Tokens; got != 8 {
+		t.Fatalf("expected ruleset penalties to total 8 tokens, got %d", got)
+	}
+}
+
+func TestSettingsSchemaEnumsFollowRegistries(t *testing.T) {
+	rulesets["test"] = Ruleset{Name: "test"}
+	defer delete(rulesets, "test")
+
+	for _, payload := range []any{ws.CreateRoomPayload{}, ws.UpdateSettingsPayload{}} {
+		sc := ws.PayloadSchema(payload)
+		if got := sc.Properties["ruleset"].Enum; !slices.Equal(got, RulesetNames()) || !slices.Contains(got, "test") {
+			t.Errorf("%T: expected ruleset enum %v, got %v", payload, RulesetNames(), got)
+		}
+		if got := sc.Properties["firstPlayer"].Enum; !slices.Equal(got, FirstPlayerPolicyNames()) {
+			t.Errorf("%T: expected firstPlayer enum %v, got %v", payload, FirstPlayerPolicyNames(), got)
+		}
+	}
+}
+
+func TestStartGameBeginsWithFirstPlayer(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{SessionID: "s1"}
+	room.Players[1] = &Player{SessionID: "s2"}
+	room.UpdateSettings(RoomSettings{PlateCount: 4, Ruleset: RulesetClassic, FirstPlayerPolicy: FirstPlayerAlternate})
+	room.SetPreviousFirstPlayer(0)
+
+	room.StartGame()
+
+	if room.State.FirstPlayer != 1 || room.State.CurrentTurn != 1 {
+		t.Fatalf("expected player 1 to start after alternating, got first=%d turn=%d", room.State.FirstPlayer, room.State.CurrentTurn)
+	}
+	if state := room.GetGameStateForPlayer(0); state.FirstPlayer != 1 || state.FirstPlayerPolicy != "alternate" {
+		t.Fatalf("expected state to announce first player 1 by alternate, got %d by %q", state.FirstPlayer, state.FirstPlayerPolicy)
+	}
+
+	// Rounds advance only once the turn returns to the first player
+	room.State.NextPlacementTurn()
+	if room.State.CurrentTurn != 0 || room.State.PlacementRound != 1 {
+		t.Fatalf("expected player 0 in round 1, got turn=%d round=%d", room.State.CurrentTurn, room.State.PlacementRound)
+	}
+	room.State.NextPlacementTurn()
+	if room.State.CurrentTurn != 1 || room.State.PlacementRound != 2 {
+		t.Fatalf("expected player 1 in round 2, got turn=%d round=%d", room.State.CurrentTurn, room.State.PlacementRound)
+	}
+
+	room.State.StartMatchingPhase(0)
+	if room.State.CurrentTurn != 1 {
+		t.Fatalf("expected matching to start with first player 1, got %d", room.State.CurrentTurn)
+	}
+}
+
+func TestRematchKeepsPlayersAndSettingsAndSwapsFirstPlayer(t *testing.T) {
+	room := NewRoom(4, nil)
+	room.Players[0] = &Player{ID: "p1", SessionID: "s1", Nickname: "Alice", Tokens: 3, Rating: 1200}
+	room.Players[1] = &Player{ID: "p2", SessionID: "s2", Nickname: "Bob", Tokens: 0}
+	room.UpdateSettings(RoomSettings{PlateCount: 10, Ruleset: RulesetBlitz, FirstPlayerPolicy: FirstPlayerAlternate})
+	room.StartGame()
+	first := room.GetFirstPlayer()
+
+	next := room.Rematch()
+	if next.ID == room.ID || next.GetPhase() != PhaseWaiting {
+		t.Fatalf("expected a fresh waiting room")
+	}
+	if got := next.Settings(); got != room.Settings() {
+		t.Fatalf("expected settings %+v, got %+v", room.Settings(), got)
+	}
+	if p := next.GetPlayer(0); p.SessionID != "s1" || p.Tokens != 0 || p.Rating != 1200 {
+		t.Fatalf("expected seat 0 to keep identity with reset tokens, got %+v", p)
 	}
 
-	ok, _, _, _ = room.HandleConfirmMatch(0)
-	if ok {
-		t.Fatalf("expected second HandleConfirmMatch to be blocked while confirmPending")
+	next.StartGame()
+	if got := next.GetFirstPlayer(); got != 1-first {
+		t.Fatalf("expected rematch to start with player %d, got %d", 1-first, got)
 	}
 }
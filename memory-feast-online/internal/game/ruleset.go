@@ -0,0 +1,62 @@
+package game
+
+import (
+	"sort"
+
+	"memory-feast-online/internal/ws"
+)
+
+// Ruleset holds the tunable rules of a game
+type Ruleset struct {
+	Name           string
+	TurnTimeLimit  int // seconds per matching turn
+	FailPenalty    int // tokens added on a failed match
+	TimeoutPenalty int // tokens added when the turn timer runs out
+}
+
+var (
+	// RulesetClassic is the default ruleset
+	RulesetClassic = Ruleset{
+		Name:           "classic",
+		TurnTimeLimit:  MatchingTimeLimit,
+		FailPenalty:    1,
+		TimeoutPenalty: 2,
+	}
+
+	// RulesetBlitz halves the turn timer
+	RulesetBlitz = Ruleset{
+		Name:           "blitz",
+		TurnTimeLimit:  MatchingTimeLimit / 2,
+		FailPenalty:    1,
+		TimeoutPenalty: 2,
+	}
+)
+
+var rulesets = map[string]Ruleset{
+	RulesetClassic.Name: RulesetClassic,
+	RulesetBlitz.Name:   RulesetBlitz,
+}
+
+func init() {
+	ws.RegisterEnum("ruleset", RulesetNames)
+}
+
+// LookupRuleset finds a ruleset by name. An empty name yields the classic rules.
+func LookupRuleset(name string) (Ruleset, bool) {
+	if name == "" {
+		return RulesetClassic, true
+	}
+	rs, ok := rulesets[name]
+	return rs, ok
+}
+
+// RulesetNames lists the registered rulesets by name, as the ruleset fields
+// of the protocol schema enumerate them
+func RulesetNames() []string {
+	names := make([]string, 0, len(rulesets))
+	for name := range rulesets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
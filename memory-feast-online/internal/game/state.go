@@ -1,5 +1,7 @@
 package game
 
+import "time"
+
 // Phase represents the current game phase
 type Phase string
 
@@ -22,9 +24,12 @@ type Plate struct {
 type GameState struct {
 	Phase           Phase
 	CurrentTurn     int // 0 or 1 (player index)
+	FirstPlayer     int // Player index who starts each round
 	PlacementRound  int
 	MaxRound        int
-	TimeLeft        int
+	TimeLeft        int       // Seconds left while no turn deadline is running
+	TurnDeadline    time.Time // When the current matching turn times out, zero when stopped
+	TurnTimeLimit   int       // Seconds per matching turn, set by the ruleset
 	Plates          []Plate
 	SelectedPlates  []int
 	MatchedPlates   []int
@@ -52,7 +57,8 @@ func NewGameState(plateCount int) *GameState {
 		CurrentTurn:    0,
 		PlacementRound: 1,
 		MaxRound:       maxRound,
-		TimeLeft:       60,
+		TimeLeft:       MatchingTimeLimit,
+		TurnTimeLimit:  MatchingTimeLimit,
 		Plates:         plates,
 		SelectedPlates: []int{},
 		MatchedPlates:  []int{},
@@ -84,8 +90,8 @@ func (gs *GameState) NextPlacementTurn() bool {
 	// Switch player
 	gs.CurrentTurn = 1 - gs.CurrentTurn
 
-	// If back to player 0, increment round
-	if gs.CurrentTurn == 0 {
+	// If back to the first player, increment round
+	if gs.CurrentTurn == gs.FirstPlayer {
 		gs.PlacementRound++
 	}
 
@@ -100,8 +106,8 @@ func (gs *GameState) NextPlacementTurn() bool {
 // StartMatchingPhase initializes the matching phase
 func (gs *GameState) StartMatchingPhase(initialTokens int) {
 	gs.Phase = PhaseMatching
-	gs.CurrentTurn = 0
-	gs.TimeLeft = 60
+	gs.CurrentTurn = gs.FirstPlayer
+	gs.TimeLeft = gs.TurnTimeLimit
 	gs.SelectedPlates = []int{}
 	gs.MatchedPlates = []int{}
 }
@@ -190,7 +196,7 @@ func (gs *GameState) ResetForNextTurn() {
 	gs.MatchedPlates = []int{}
 	gs.LastActionPlate = nil
 	gs.Phase = PhaseMatching
-	gs.TimeLeft = 60
+	gs.TimeLeft = gs.TurnTimeLimit
 }
 
 // NextMatchingTurn advances to the next matching turn
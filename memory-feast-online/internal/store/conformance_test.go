@@ -0,0 +1,335 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+// storeHarness is a Store under the conformance suite
+type storeHarness struct {
+	store Store
+	// advance moves the store's clock forward; nil when the clock cannot be
+	// controlled, which skips the expiry cases
+	advance func(time.Duration)
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	runStoreConformance(t, storeHarness{store: NewMemoryStoreWithClock(fake), advance: fake.Advance})
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	store, err := NewFileStore(t.TempDir(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	runStoreConformance(t, storeHarness{store: store, advance: fake.Advance})
+}
+
+// TestRedisStoreConformance runs against the server at REDIS_TEST_ADDR
+func TestRedisStoreConformance(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	store, err := NewRedisStore(RedisOptions{Addrs: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	runStoreConformance(t, storeHarness{store: store})
+}
+
+// TestRedisStoreConformanceWithClusterKeys runs the suite with the hash
+// tagged keys of Cluster mode against the server at REDIS_TEST_ADDR
+func TestRedisStoreConformanceWithClusterKeys(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	store, err := NewRedisStore(RedisOptions{Addrs: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	store.keys.cluster = true
+	runStoreConformance(t, storeHarness{store: store})
+}
+
+func runStoreConformance(t *testing.T, h storeHarness) {
+	t.Run("Rooms", func(t *testing.T) { testRooms(t, h) })
+	t.Run("RoomVersions", func(t *testing.T) { testRoomVersions(t, h) })
+	t.Run("Codes", func(t *testing.T) { testCodes(t, h) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, h) })
+	t.Run("Matches", func(t *testing.T) { testMatches(t, h) })
+	t.Run("Expiry", func(t *testing.T) {
+		if h.advance == nil {
+			t.Skip("store clock cannot be advanced")
+		}
+		testExpiry(t, h)
+	})
+}
+
+// uniqueSuffix keeps runs against a shared Redis apart
+func uniqueSuffix() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
+// uniqueCode is a six character code unlikely to be held already
+func uniqueCode(prefix string) string {
+	suffix := uniqueSuffix()
+	return prefix + suffix[len(suffix)-5:]
+}
+
+func testRooms(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	suffix := uniqueSuffix()
+	code := uniqueCode("R")
+
+	if got, err := h.store.GetRoom(ctx, "missing-"+suffix); err != nil || got != nil {
+		t.Fatalf("expected no room for an unknown ID, got %+v, %v", got, err)
+	}
+
+	room := &RoomData{
+		ID:         "room-" + suffix,
+		Code:       code,
+		Owner:      "a",
+		PlateCount: 12,
+		Players:    []PlayerData{{ID: "p1", Nickname: "Host", SessionID: "s1"}},
+	}
+	if err := h.store.SaveRoom(ctx, room); err != nil {
+		t.Fatalf("failed to save room: %v", err)
+	}
+
+	got, err := h.store.GetRoom(ctx, room.ID)
+	if err != nil || got == nil {
+		t.Fatalf("expected the saved room, got %+v, %v", got, err)
+	}
+	if got.Owner != "a" || got.PlateCount != 12 || len(got.Players) != 1 || got.Players[0].Nickname != "Host" {
+		t.Fatalf("expected the room as saved, got %+v", got)
+	}
+	got.Players[0].Nickname = "Changed"
+	if again, _ := h.store.GetRoom(ctx, room.ID); again.Players[0].Nickname != "Host" {
+		t.Fatal("expected the stored room not to change with a read copy")
+	}
+
+	if byCode, _ := h.store.GetRoomByCode(ctx, code); byCode == nil || byCode.ID != room.ID {
+		t.Fatalf("expected the room by its code, got %+v", byCode)
+	}
+
+	matchmade := &RoomData{ID: "matchmade-" + suffix}
+	if err := h.store.SaveRoom(ctx, matchmade); err != nil {
+		t.Fatalf("failed to save a room without code: %v", err)
+	}
+
+	for _, id := range []string{room.ID, matchmade.ID} {
+		if err := h.store.DeleteRoom(ctx, id); err != nil {
+			t.Fatalf("failed to delete room %s: %v", id, err)
+		}
+		if got, _ := h.store.GetRoom(ctx, id); got != nil {
+			t.Fatalf("expected room %s to be gone", id)
+		}
+	}
+	if got, _ := h.store.GetRoomByCode(ctx, code); got != nil {
+		t.Fatal("expected the code mapping to go with the room")
+	}
+	if err := h.store.DeleteRoom(ctx, room.ID); err != nil {
+		t.Fatalf("expected deleting a missing room to succeed, got %v", err)
+	}
+}
+
+func testRoomVersions(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	suffix := uniqueSuffix()
+	code := uniqueCode("V")
+
+	room := &RoomData{ID: "room-" + suffix, Code: code, PlateCount: 20}
+	if err := h.store.SaveRoom(ctx, room); err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+	if room.Version != 1 {
+		t.Fatalf("expected version 1 after creating the room, got %d", room.Version)
+	}
+
+	first, _ := h.store.GetRoom(ctx, room.ID)
+	second, _ := h.store.GetRoom(ctx, room.ID)
+	first.PlateCount = 10
+	if err := h.store.SaveRoom(ctx, first); err != nil {
+		t.Fatalf("failed to save read version: %v", err)
+	}
+	second.PlateCount = 12
+	if err := h.store.SaveRoom(ctx, second); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected a stale save to conflict, got %v", err)
+	}
+	if got, _ := h.store.GetRoom(ctx, room.ID); got.PlateCount != 10 || got.Version != 2 {
+		t.Fatalf("expected the first save to stand, got %+v", got)
+	}
+
+	// A save that conflicts leaves a free code free
+	unused := uniqueCode("U")
+	fresh := &RoomData{ID: "room-" + suffix, Code: unused, PlateCount: 20}
+	if err := h.store.SaveRoom(ctx, fresh); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected creating an existing room to conflict, got %v", err)
+	}
+	if got, _ := h.store.GetRoomByCode(ctx, unused); got != nil {
+		t.Fatalf("expected the conflicting save not to claim its code, got %+v", got)
+	}
+
+	other := &RoomData{ID: "other-" + suffix, Code: code}
+	if err := h.store.SaveRoom(ctx, other); !errors.Is(err, ErrCodeTaken) {
+		t.Fatalf("expected another room's code to be refused, got %v", err)
+	}
+
+	if err := h.store.DeleteRoom(ctx, room.ID); err != nil {
+		t.Fatalf("failed to delete room: %v", err)
+	}
+	if err := h.store.SaveRoom(ctx, other); err != nil {
+		t.Fatalf("expected the freed code to be usable, got %v", err)
+	}
+	h.store.DeleteRoom(ctx, other.ID)
+}
+
+func testCodes(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	code := uniqueCode("C")
+
+	ok, err := h.store.ReserveCode(ctx, code, "room-1", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected first reservation to succeed, got ok=%v err=%v", ok, err)
+	}
+	if ok, _ := h.store.ReserveCode(ctx, code, "room-2", time.Minute); ok {
+		t.Fatal("expected competing reservation to fail")
+	}
+
+	if err := h.store.ReleaseCode(ctx, code, "room-2"); err != nil {
+		t.Fatalf("ReleaseCode failed: %v", err)
+	}
+	if ok, _ := h.store.ReserveCode(ctx, code, "room-2", time.Minute); ok {
+		t.Fatal("expected release by non-owner to keep reservation")
+	}
+
+	if err := h.store.RefreshCode(ctx, code, "room-1", time.Hour); err != nil {
+		t.Fatalf("RefreshCode failed: %v", err)
+	}
+	if err := h.store.ReleaseCode(ctx, code, "room-1"); err != nil {
+		t.Fatalf("ReleaseCode failed: %v", err)
+	}
+	if ok, _ := h.store.ReserveCode(ctx, code, "room-2", time.Minute); !ok {
+		t.Fatal("expected reservation after owner release to succeed")
+	}
+	h.store.ReleaseCode(ctx, code, "room-2")
+}
+
+func testSessions(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	sessionID := "session-" + uniqueSuffix()
+
+	if roomID, index, err := h.store.GetSession(ctx, sessionID); err != nil || roomID != "" || index != -1 {
+		t.Fatalf("expected no session, got %q %d %v", roomID, index, err)
+	}
+	if err := h.store.SaveSession(ctx, sessionID, "room-1", 1); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if roomID, index, _ := h.store.GetSession(ctx, sessionID); roomID != "room-1" || index != 1 {
+		t.Fatalf("expected the saved seat, got %q %d", roomID, index)
+	}
+	if err := h.store.DeleteSession(ctx, sessionID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if roomID, _, _ := h.store.GetSession(ctx, sessionID); roomID != "" {
+		t.Fatalf("expected the session to be gone, got %q", roomID)
+	}
+}
+
+func testMatches(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	roomID := "match-" + uniqueSuffix()
+
+	if got, err := h.store.GetMatch(ctx, roomID); err != nil || got != nil {
+		t.Fatalf("expected no match, got %+v, %v", got, err)
+	}
+	match := &MatchRecord{RoomID: roomID, Winner: 1, Reason: "tokens", FinalTokens: []int{3, 5}}
+	if err := h.store.SaveMatch(ctx, match); err != nil {
+		t.Fatalf("failed to save match: %v", err)
+	}
+	if got, _ := h.store.GetMatch(ctx, roomID); got == nil || got.Winner != 1 || len(got.FinalTokens) != 2 {
+		t.Fatalf("expected the saved match, got %+v", got)
+	}
+}
+
+func testExpiry(t *testing.T, h storeHarness) {
+	ctx := context.Background()
+	suffix := uniqueSuffix()
+	code := uniqueCode("E")
+
+	room := &RoomData{ID: "room-" + suffix, Code: code}
+	h.store.SaveRoom(ctx, room)
+	h.store.SaveSession(ctx, "session-"+suffix, room.ID, 0)
+	h.store.SaveMatch(ctx, &MatchRecord{RoomID: room.ID})
+
+	h.advance(sessionTTL - time.Second)
+	if roomID, _, _ := h.store.GetSession(ctx, "session-"+suffix); roomID != room.ID {
+		t.Fatal("expected the session to live until its TTL")
+	}
+	h.advance(time.Second)
+	if roomID, _, _ := h.store.GetSession(ctx, "session-"+suffix); roomID != "" {
+		t.Fatal("expected the session to expire after its TTL")
+	}
+
+	// Saving again starts the room's TTL over
+	h.advance(roomTTL - sessionTTL - time.Second)
+	if err := h.store.SaveRoom(ctx, room); err != nil {
+		t.Fatalf("failed to save room again: %v", err)
+	}
+	h.advance(roomTTL - time.Second)
+	if got, _ := h.store.GetRoom(ctx, room.ID); got == nil {
+		t.Fatal("expected a saved room to live until its TTL")
+	}
+	h.advance(time.Second)
+	if got, _ := h.store.GetRoom(ctx, room.ID); got != nil {
+		t.Fatal("expected the room to expire after its TTL")
+	}
+	if got, _ := h.store.GetRoomByCode(ctx, code); got != nil {
+		t.Fatal("expected the code mapping to expire with the room")
+	}
+
+	// Saving a room leaves its reserved code's TTL alone
+	reserved := uniqueCode("K")
+	if ok, _ := h.store.ReserveCode(ctx, reserved, room.ID, time.Minute); !ok {
+		t.Fatal("expected the code to be reserved")
+	}
+	if err := h.store.SaveRoom(ctx, &RoomData{ID: room.ID, Code: reserved}); err != nil {
+		t.Fatalf("failed to save room with its reserved code: %v", err)
+	}
+	h.advance(time.Minute)
+	if got, _ := h.store.GetRoomByCode(ctx, reserved); got != nil {
+		t.Fatal("expected the reservation to expire after its own TTL")
+	}
+	if got, _ := h.store.GetRoom(ctx, room.ID); got == nil {
+		t.Fatal("expected the room to outlive its code reservation")
+	}
+	h.store.DeleteRoom(ctx, room.ID)
+
+	// An expired room is created anew
+	if err := h.store.SaveRoom(ctx, &RoomData{ID: room.ID}); err != nil {
+		t.Fatalf("expected an expired room to be saved as new, got %v", err)
+	}
+
+	if got, _ := h.store.GetMatch(ctx, room.ID); got == nil {
+		t.Fatal("expected the match record to outlive the room")
+	}
+	h.advance(matchTTL)
+	if got, _ := h.store.GetMatch(ctx, room.ID); got != nil {
+		t.Fatal("expected the match record to expire after its TTL")
+	}
+}
@@ -0,0 +1,508 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+// ErrClosed means the file store was closed and takes no more writes
+const ErrClosed StoreError = "store closed"
+
+const (
+	fileLogName = "store.log"
+
+	// compactMinRecords keeps a small log from being rewritten on every tick
+	compactMinRecords = 1024
+)
+
+// Kinds of entries in the file store's log
+const (
+	logRoom    = recordRoom
+	logCode    = "code"
+	logSession = recordSession
+	logMatch   = recordMatch
+)
+
+// logRecord is one line of the file store's log: the state of one entry
+// after a write, or its removal. Room, session and match values are
+// versioned records, migrated as they are replayed.
+type logRecord struct {
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	Delete    bool            `json:"delete,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	ExpiresAt time.Time       `json:"expiresAt,omitempty"`
+}
+
+// FileStore implements Store in a local data directory, for a single
+// instance that keeps its rooms, sessions and match history across
+// restarts without Redis. It serves reads from a MemoryStore, so TTLs,
+// versions and code ownership behave the same. Every write is appended to
+// an append-only log and synced before memory sees it. The log is replayed on open and compacted to the
+// live entries by RunJanitor.
+type FileStore struct {
+	mem *MemoryStore
+
+	mu       sync.Mutex // Serializes writes with their log records
+	dir      string
+	file     *os.File
+	w        *bufio.Writer
+	appended int // Records written since the last compaction
+}
+
+// NewFileStore opens or creates the store in dir. clk may be nil, in which
+// case the wall clock is used.
+func NewFileStore(dir string, clk clock.Clock) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &FileStore{mem: NewMemoryStoreWithClock(clk), dir: dir}
+	// A log that fails to replay is left as it is
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	// Start from a compact log, which also drops a torn last record
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compactLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay loads the log into memory
+func (s *FileStore) replay() error {
+	f, err := os.Open(filepath.Join(s.dir, fileLogName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open store log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	var torn error // The previous line did not parse
+	for scanner.Scan() {
+		line++
+		if torn != nil {
+			return fmt.Errorf("failed to read store log line %d: %w", line-1, torn)
+		}
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			torn = err
+			continue
+		}
+		if err := s.apply(&rec); err != nil {
+			return fmt.Errorf("failed to replay store log line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read store log: %w", err)
+	}
+	// A crash can leave the last record half written; anywhere else a bad
+	// line is corruption
+	if torn != nil {
+		log.Printf("failed to read store log line %d, dropping it: %v", line, torn)
+	}
+	return nil
+}
+
+// apply sets one entry of the memory store from a log record
+func (s *FileStore) apply(rec *logRecord) error {
+	m := s.mem
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch rec.Kind {
+	case logRoom:
+		if rec.Delete {
+			delete(m.rooms, rec.Key)
+			return nil
+		}
+		var room RoomData
+		if err := decodeRecord(logRoom, rec.Value, &room); err != nil {
+			return err
+		}
+		m.rooms[rec.Key] = memoryEntry[*RoomData]{value: &room, expiresAt: rec.ExpiresAt}
+	case logCode:
+		if rec.Delete {
+			delete(m.codes, rec.Key)
+			return nil
+		}
+		var roomID string
+		if err := json.Unmarshal(rec.Value, &roomID); err != nil {
+			return err
+		}
+		m.codes[rec.Key] = codeEntry{roomID: roomID, expiresAt: rec.ExpiresAt}
+	case logSession:
+		if rec.Delete {
+			delete(m.sessions, rec.Key)
+			return nil
+		}
+		var session SessionData
+		if err := decodeRecord(logSession, rec.Value, &session); err != nil {
+			return err
+		}
+		m.sessions[rec.Key] = memoryEntry[SessionData]{value: session, expiresAt: rec.ExpiresAt}
+	case logMatch:
+		if rec.Delete {
+			delete(m.matches, rec.Key)
+			return nil
+		}
+		var match MatchRecord
+		if err := decodeRecord(logMatch, rec.Value, &match); err != nil {
+			return err
+		}
+		m.matches[rec.Key] = memoryEntry[*MatchRecord]{value: &match, expiresAt: rec.ExpiresAt}
+	default:
+		return fmt.Errorf("unknown log record kind %q", rec.Kind)
+	}
+	return nil
+}
+
+// record returns the current state of one entry of m as a log record
+func record(m *MemoryStore, kind, key string) (*logRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec := &logRecord{Kind: kind, Key: key}
+	var value any
+	switch kind {
+	case logRoom:
+		if entry, ok := m.rooms[key]; ok {
+			value, rec.ExpiresAt = entry.value, entry.expiresAt
+		}
+	case logCode:
+		if entry, ok := m.codes[key]; ok {
+			value, rec.ExpiresAt = entry.roomID, entry.expiresAt
+		}
+	case logSession:
+		if entry, ok := m.sessions[key]; ok {
+			value, rec.ExpiresAt = entry.value, entry.expiresAt
+		}
+	case logMatch:
+		if entry, ok := m.matches[key]; ok {
+			value, rec.ExpiresAt = entry.value, entry.expiresAt
+		}
+	}
+
+	if value == nil {
+		rec.Delete = true
+		return rec, nil
+	}
+	var data []byte
+	var err error
+	if kind == logCode {
+		data, err = json.Marshal(value)
+	} else {
+		data, err = encodeRecord(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s: %w", kind, key, err)
+	}
+	rec.Value = data
+	return rec, nil
+}
+
+// write runs op on a copy of the entries it touches, appends their new
+// state to the log and syncs it, and only then applies that state to memory,
+// so a failed append leaves memory as the log has it. keys alternate kind and
+// key; empty keys are skipped.
+func (s *FileStore) write(op func(m *MemoryStore) error, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return ErrClosed
+	}
+
+	scratch := NewMemoryStoreWithClock(s.mem.clock)
+	s.mem.copyEntries(scratch, keys)
+	if err := op(scratch); err != nil {
+		return err
+	}
+
+	var records []*logRecord
+	for i := 0; i+1 < len(keys); i += 2 {
+		if keys[i+1] == "" {
+			continue
+		}
+		rec, err := record(scratch, keys[i], keys[i+1])
+		if err != nil {
+			return err
+		}
+		if err := s.writeRecord(rec); err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write store log: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store log: %w", err)
+	}
+
+	for _, rec := range records {
+		if err := s.apply(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntries copies the entries named by keys, alternating kind and key,
+// into to
+func (m *MemoryStore) copyEntries(to *MemoryStore, keys []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := 0; i+1 < len(keys); i += 2 {
+		key := keys[i+1]
+		switch keys[i] {
+		case logRoom:
+			if entry, ok := m.rooms[key]; ok {
+				to.rooms[key] = entry
+			}
+		case logCode:
+			if entry, ok := m.codes[key]; ok {
+				to.codes[key] = entry
+			}
+		case logSession:
+			if entry, ok := m.sessions[key]; ok {
+				to.sessions[key] = entry
+			}
+		case logMatch:
+			if entry, ok := m.matches[key]; ok {
+				to.matches[key] = entry
+			}
+		}
+	}
+}
+
+func (s *FileStore) writeRecord(rec *logRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log record: %w", err)
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write store log: %w", err)
+	}
+	s.appended++
+	return nil
+}
+
+// compactLocked rewrites the log with only the live entries and reopens it
+// for appending; the caller holds s.mu
+func (s *FileStore) compactLocked() error {
+	s.mem.dropExpired()
+
+	m := s.mem
+	m.mu.RLock()
+	keys := make([]string, 0, 2*(len(m.rooms)+len(m.codes)+len(m.sessions)+len(m.matches)))
+	for key := range m.rooms {
+		keys = append(keys, logRoom, key)
+	}
+	for key := range m.codes {
+		keys = append(keys, logCode, key)
+	}
+	for key := range m.sessions {
+		keys = append(keys, logSession, key)
+	}
+	for key := range m.matches {
+		keys = append(keys, logMatch, key)
+	}
+	m.mu.RUnlock()
+
+	path := filepath.Join(s.dir, fileLogName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create store log: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for i := 0; i < len(keys); i += 2 {
+		rec, err := record(s.mem, keys[i], keys[i+1])
+		if err == nil {
+			var data []byte
+			if data, err = json.Marshal(rec); err == nil {
+				_, err = w.Write(append(data, '\n'))
+			}
+		}
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact store log: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact store log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync store log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact store log: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to replace store log: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open store log: %w", err)
+	}
+	s.w = bufio.NewWriter(s.file)
+	s.appended = 0
+	return nil
+}
+
+// RunJanitor drops expired entries every interval until ctx is done, and
+// compacts the log once it holds more superseded records than live ones
+func (s *FileStore) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := s.mem.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := s.Compact(false); err != nil {
+				log.Printf("failed to compact store log in %s: %v", s.dir, err)
+			}
+		}
+	}
+}
+
+// Compact rewrites the log with the live entries. Unless force is set it
+// only does so once the log has grown past twice the live entries.
+func (s *FileStore) Compact(force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return ErrClosed
+	}
+	if !force {
+		s.mem.dropExpired()
+		if s.appended < compactMinRecords || s.appended < s.mem.size() {
+			return nil
+		}
+	}
+	return s.compactLocked()
+}
+
+// Close compacts the log and closes it
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.compactLocked()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) SaveRoom(ctx context.Context, room *RoomData) error {
+	// The caller's room only advances once the save is logged
+	next := *room
+	err := s.write(func(m *MemoryStore) error {
+		return m.SaveRoom(ctx, &next)
+	}, logRoom, room.ID, logCode, room.Code)
+	if err != nil {
+		return err
+	}
+	room.Version = next.Version
+	return nil
+}
+
+func (s *FileStore) GetRoom(ctx context.Context, roomID string) (*RoomData, error) {
+	return s.mem.GetRoom(ctx, roomID)
+}
+
+func (s *FileStore) DeleteRoom(ctx context.Context, roomID string) error {
+	// The code the room held goes with it. A room keeps its code, so it can
+	// be looked up before the write.
+	var code string
+	if room, _ := s.mem.GetRoom(ctx, roomID); room != nil {
+		code = room.Code
+	}
+	return s.write(func(m *MemoryStore) error {
+		return m.DeleteRoom(ctx, roomID)
+	}, logRoom, roomID, logCode, code)
+}
+
+func (s *FileStore) GetRoomByCode(ctx context.Context, code string) (*RoomData, error) {
+	return s.mem.GetRoomByCode(ctx, code)
+}
+
+func (s *FileStore) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := s.write(func(m *MemoryStore) error {
+		var err error
+		ok, err = m.ReserveCode(ctx, code, roomID, ttl)
+		return err
+	}, logCode, code)
+	return ok && err == nil, err
+}
+
+func (s *FileStore) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	return s.write(func(m *MemoryStore) error {
+		return m.RefreshCode(ctx, code, roomID, ttl)
+	}, logCode, code)
+}
+
+func (s *FileStore) ReleaseCode(ctx context.Context, code, roomID string) error {
+	return s.write(func(m *MemoryStore) error {
+		return m.ReleaseCode(ctx, code, roomID)
+	}, logCode, code)
+}
+
+func (s *FileStore) SaveSession(ctx context.Context, sessionID, roomID string, playerIndex int) error {
+	return s.write(func(m *MemoryStore) error {
+		return m.SaveSession(ctx, sessionID, roomID, playerIndex)
+	}, logSession, sessionID)
+}
+
+func (s *FileStore) GetSession(ctx context.Context, sessionID string) (string, int, error) {
+	return s.mem.GetSession(ctx, sessionID)
+}
+
+func (s *FileStore) DeleteSession(ctx context.Context, sessionID string) error {
+	return s.write(func(m *MemoryStore) error {
+		return m.DeleteSession(ctx, sessionID)
+	}, logSession, sessionID)
+}
+
+func (s *FileStore) SaveMatch(ctx context.Context, match *MatchRecord) error {
+	return s.write(func(m *MemoryStore) error {
+		return m.SaveMatch(ctx, match)
+	}, logMatch, match.RoomID)
+}
+
+func (s *FileStore) GetMatch(ctx context.Context, roomID string) (*MatchRecord, error) {
+	return s.mem.GetMatch(ctx, roomID)
+}
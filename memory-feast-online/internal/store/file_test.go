@@ -0,0 +1,241 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+func TestFileStoreKeepsDataAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	fake := clock.NewFake(time.Unix(0, 0))
+	ctx := context.Background()
+
+	store, err := NewFileStore(dir, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := &RoomData{ID: "room-1", Code: "ABCDEF", Owner: "a", Players: []PlayerData{{Nickname: "Host", SessionID: "s1"}}}
+	store.SaveRoom(ctx, room)
+	store.SaveRoom(ctx, room)
+	store.SaveSession(ctx, "s1", "room-1", 0)
+	store.SaveSession(ctx, "s2", "room-1", 1)
+	store.DeleteSession(ctx, "s2")
+	store.SaveMatch(ctx, &MatchRecord{RoomID: "old-room", Winner: 1})
+	store.ReserveCode(ctx, "GHJKLM", "room-2", time.Minute)
+
+	// Reopen from the log as written, without the compaction Close does
+	store.mu.Lock()
+	store.file.Close()
+	store.file = nil
+	store.mu.Unlock()
+
+	fake.Advance(30 * time.Minute)
+	reopened, err := NewFileStore(dir, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	got, _ := reopened.GetRoomByCode(ctx, "ABCDEF")
+	if got == nil || got.Version != 2 || got.Owner != "a" || len(got.Players) != 1 || got.Players[0].Nickname != "Host" {
+		t.Fatalf("expected the room as last saved, got %+v", got)
+	}
+	if roomID, index, _ := reopened.GetSession(ctx, "s1"); roomID != "room-1" || index != 0 {
+		t.Fatalf("expected session s1 to be kept, got %q %d", roomID, index)
+	}
+	if roomID, _, _ := reopened.GetSession(ctx, "s2"); roomID != "" {
+		t.Fatal("expected the deleted session to stay deleted")
+	}
+	if match, _ := reopened.GetMatch(ctx, "old-room"); match == nil || match.Winner != 1 {
+		t.Fatalf("expected the match history to be kept, got %+v", match)
+	}
+	if ok, _ := reopened.ReserveCode(ctx, "GHJKLM", "room-3", time.Minute); !ok {
+		t.Fatal("expected the code reservation to expire on its original schedule")
+	}
+
+	// Expiry times are absolute, so the remaining TTL carries over
+	fake.Advance(sessionTTL - 30*time.Minute)
+	if roomID, _, _ := reopened.GetSession(ctx, "s1"); roomID != "" {
+		t.Fatal("expected the session to expire an hour after it was saved")
+	}
+}
+
+func TestFileStoreCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		store.SaveSession(ctx, "s1", "room-1", i%2)
+	}
+	path := filepath.Join(dir, fileLogName)
+	before, _ := os.Stat(path)
+
+	if err := store.Compact(false); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged, _ := os.Stat(path); unchanged.Size() != before.Size() {
+		t.Fatal("expected a small log not to be compacted before it is worth it")
+	}
+	if err := store.Compact(true); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size()*50 > before.Size() {
+		t.Fatalf("expected compaction to keep one record, log went from %d to %d bytes", before.Size(), after.Size())
+	}
+
+	store.SaveSession(ctx, "s2", "room-1", 0)
+	store.Close()
+	reopened, err := NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if roomID, index, _ := reopened.GetSession(ctx, "s1"); roomID != "room-1" || index != 1 {
+		t.Fatalf("expected the last write to survive compaction, got %q %d", roomID, index)
+	}
+	if roomID, _, _ := reopened.GetSession(ctx, "s2"); roomID != "room-1" {
+		t.Fatal("expected writes after compaction to be appended to the new log")
+	}
+}
+
+func TestFileStoreDropsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SaveSession(ctx, "s1", "room-1", 0)
+	store.Close()
+
+	f, err := os.OpenFile(filepath.Join(dir, fileLogName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"session","key":"s2","val`)
+	f.Close()
+
+	reopened, err := NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatalf("expected a torn last record to be dropped, got %v", err)
+	}
+	defer reopened.Close()
+	if roomID, _, _ := reopened.GetSession(ctx, "s1"); roomID != "room-1" {
+		t.Fatal("expected records before the torn one to be kept")
+	}
+}
+
+func TestFileStoreRefusesCorruptRecordBeforeTheEnd(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	store, err := NewFileStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SaveSession(ctx, "s1", "room-1", 0)
+	store.Close()
+
+	path := filepath.Join(dir, fileLogName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"kind":"session","key":"s2","val` + "\n")
+	f.WriteString(`{"kind":"session","key":"s3","value":{"roomId":"room-1","playerIndex":1},"expiresAt":"2100-01-01T00:00:00Z"}` + "\n")
+	f.Close()
+	before, _ := os.ReadFile(path)
+
+	if _, err := NewFileStore(dir, nil); err == nil {
+		t.Fatal("expected a bad record followed by more to fail the open")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Fatal("expected the log to be left untouched")
+	}
+}
+
+func TestFileStoreRefusesNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	line := fmt.Sprintf(`{"kind":"room","key":"room-1","value":{"schema":%d,"id":"room-1"},"expiresAt":"2100-01-01T00:00:00Z"}`+"\n", SchemaVersion+1)
+	if err := os.WriteFile(filepath.Join(dir, fileLogName), []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileStore(dir, nil); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema opening a newer log, got %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, fileLogName))
+	if string(data) != line {
+		t.Fatal("expected the newer log to be left untouched")
+	}
+}
+
+func TestFileStoreAppliesWritesOnlyOnceLogged(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	room := &RoomData{ID: "room-1", Code: "ABCDEF"}
+	if err := store.SaveRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log can no longer be written
+	store.mu.Lock()
+	store.file.Close()
+	store.mu.Unlock()
+
+	room.PlateCount = 8
+	if err := store.SaveRoom(ctx, room); err == nil {
+		t.Fatal("expected a save that cannot be logged to fail")
+	}
+	if room.Version != 1 {
+		t.Fatalf("expected the room to stay at version 1, got %d", room.Version)
+	}
+	if got, _ := store.GetRoom(ctx, "room-1"); got == nil || got.PlateCount != 0 || got.Version != 1 {
+		t.Fatalf("expected memory to keep the logged room, got %+v", got)
+	}
+	if ok, err := store.ReserveCode(ctx, "GHJKLM", "room-2", time.Minute); ok || err == nil {
+		t.Fatalf("expected a reservation that cannot be logged to fail, got %v %v", ok, err)
+	}
+	if ok, _ := store.mem.ReserveCode(ctx, "GHJKLM", "room-3", time.Minute); !ok {
+		t.Fatal("expected the unlogged reservation to leave the code free")
+	}
+}
+
+func TestFileStoreRefusesWritesAfterClose(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SaveRoom(ctx, &RoomData{ID: "room-1"}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed saving a room, got %v", err)
+	}
+	if err := store.SaveSession(ctx, "s1", "room-1", 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed saving a session, got %v", err)
+	}
+	if err := store.Compact(true); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed compacting, got %v", err)
+	}
+	if got, _ := store.GetRoom(ctx, "room-1"); got != nil {
+		t.Fatalf("expected nothing to be stored, got %+v", got)
+	}
+}
@@ -0,0 +1,400 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+const (
+	healthCheckTimeout  = 2 * time.Second // Per ping and per replayed write
+	reconnectBackoffMin = time.Second
+	reconnectBackoffMax = 30 * time.Second
+
+	// maxBufferedWrites caps the writes kept while the primary is down; the
+	// oldest are dropped past it
+	maxBufferedWrites = 10000
+)
+
+// CheckedStore is a Store whose backend can be probed, such as RedisStore
+type CheckedStore interface {
+	Store
+	Ping(ctx context.Context) error
+}
+
+// HealthStatus is a HealthStore's state as shown on /readyz and /debug/vars
+type HealthStatus struct {
+	Healthy   bool      `json:"healthy"`
+	Since     time.Time `json:"since"` // When the current state began
+	LastError string    `json:"lastError,omitempty"`
+	Outages   uint64    `json:"outages"` // Times the primary went down
+	Buffered  int       `json:"bufferedWrites"`
+	Replayed  uint64    `json:"replayedWrites"`
+	Dropped   uint64    `json:"droppedWrites"` // Over maxBufferedWrites or failed on replay
+}
+
+// bufferedWrite is a write made while the primary was down
+type bufferedWrite struct {
+	name   string // For logs
+	replay func(ctx context.Context, primary Store) error
+}
+
+// HealthStore wraps a CheckedStore and keeps the server going while it is
+// unreachable. The first failed call marks the primary down: from then on
+// writes go to an in-memory overlay and are buffered in order, and reads
+// are served from the overlay, so entries written before the outage read as
+// missing. Run probes the primary with backoff and replays the buffered
+// writes once it answers. StoreErrors are answers, not outages.
+type HealthStore struct {
+	primary CheckedStore
+	clock   clock.Clock
+	wake    chan struct{} // Tells Run the primary went down
+
+	mu      sync.Mutex
+	overlay *MemoryStore // nil while the primary is healthy
+	pending []bufferedWrite
+	status  HealthStatus
+
+	// Rooms whose replayed save conflicted. Only Run uses it, and clears it
+	// once the primary is healthy again.
+	stale map[string]bool
+}
+
+// NewHealthStore wraps primary, assuming it is healthy until a call fails
+// or Check says otherwise. clk may be nil, in which case the wall clock is
+// used.
+func NewHealthStore(primary CheckedStore, clk clock.Clock) *HealthStore {
+	clk = clock.OrReal(clk)
+	return &HealthStore{
+		primary: primary,
+		clock:   clk,
+		wake:    make(chan struct{}, 1),
+		status:  HealthStatus{Healthy: true, Since: clk.Now()},
+		stale:   make(map[string]bool),
+	}
+}
+
+// Status returns the current health of the primary
+func (s *HealthStore) Status() HealthStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.Buffered = len(s.pending)
+	return status
+}
+
+// String implements expvar.Var
+func (s *HealthStore) String() string {
+	data, err := json.Marshal(s.Status())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// Check pings the primary and marks it down if it does not answer
+func (s *HealthStore) Check(ctx context.Context) error {
+	err := s.ping(ctx)
+	if err != nil {
+		s.mu.Lock()
+		s.downLocked(err)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *HealthStore) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return s.primary.Ping(ctx)
+}
+
+// unavailable reports whether err means the primary could not be reached
+// rather than that it refused the call
+func unavailable(err error) bool {
+	var storeErr StoreError
+	return err != nil && !errors.As(err, &storeErr)
+}
+
+// downLocked marks the primary down; the caller holds s.mu
+func (s *HealthStore) downLocked(err error) {
+	s.status.LastError = err.Error()
+	if s.overlay != nil {
+		return
+	}
+
+	log.Printf("store unavailable, buffering writes: %v", err)
+	s.overlay = NewMemoryStoreWithClock(s.clock)
+	s.status.Healthy = false
+	s.status.Since = s.clock.Now()
+	s.status.Outages++
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// degraded returns the overlay while the primary is down, or nil
+func (s *HealthStore) degraded() *MemoryStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overlay
+}
+
+// failover marks the primary down after err and returns the overlay
+func (s *HealthStore) failover(err error) *MemoryStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downLocked(err)
+	return s.overlay
+}
+
+// write runs do on the primary, or on the overlay while the primary is
+// down, buffering replay to run on the primary once it is back
+func (s *HealthStore) write(name string, do func(st Store) error, replay func(ctx context.Context, primary Store) error) error {
+	s.mu.Lock()
+	if s.overlay == nil {
+		s.mu.Unlock()
+		err := do(s.primary)
+		if !unavailable(err) {
+			return err
+		}
+		s.mu.Lock()
+		s.downLocked(err)
+	}
+	defer s.mu.Unlock()
+
+	if err := do(s.overlay); err != nil {
+		return err
+	}
+	if len(s.pending) >= maxBufferedWrites {
+		log.Printf("store write buffer full, dropping buffered %s", s.pending[0].name)
+		s.pending = s.pending[1:]
+		s.status.Dropped++
+	}
+	s.pending = append(s.pending, bufferedWrite{name: name, replay: replay})
+	return nil
+}
+
+// Run probes the primary while it is down, backing off from
+// reconnectBackoffMin to reconnectBackoffMax, and replays the buffered
+// writes once it answers. It returns when ctx is done.
+func (s *HealthStore) Run(ctx context.Context) {
+	backoff := reconnectBackoffMin
+	for {
+		if s.degraded() == nil {
+			backoff = reconnectBackoffMin
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+
+		timer := s.clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if err := s.recover(ctx); err != nil {
+			backoff = min(2*backoff, reconnectBackoffMax)
+			log.Printf("store still unavailable, retrying in %s: %v", backoff, err)
+		}
+	}
+}
+
+// recover pings the primary and replays the buffered writes in order,
+// marking it healthy once none are left
+func (s *HealthStore) recover(ctx context.Context) error {
+	if err := s.ping(ctx); err != nil {
+		s.mu.Lock()
+		s.status.LastError = err.Error()
+		s.mu.Unlock()
+		return err
+	}
+
+	for {
+		// Writes made during the replay are buffered behind it
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = nil
+		if len(batch) == 0 {
+			log.Printf("store available again after %s", s.clock.Since(s.status.Since))
+			s.overlay = nil
+			clear(s.stale)
+			s.status.Healthy = true
+			s.status.Since = s.clock.Now()
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+
+		for i, w := range batch {
+			replayCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			err := w.replay(replayCtx, s.primary)
+			cancel()
+
+			s.mu.Lock()
+			if unavailable(err) {
+				s.pending = append(append([]bufferedWrite(nil), batch[i:]...), s.pending...)
+				s.status.LastError = err.Error()
+				s.mu.Unlock()
+				return err
+			}
+			if err != nil {
+				log.Printf("failed to replay buffered %s: %v", w.name, err)
+				s.status.Dropped++
+			} else {
+				s.status.Replayed++
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// SaveRoom saves to the primary. While it is down the overlay takes the
+// caller's version as the stored one, and the buffered save is replayed at
+// that version, so a room written meanwhile by someone else fails with
+// ErrVersionConflict and is dropped with its later buffered saves.
+func (s *HealthStore) SaveRoom(ctx context.Context, room *RoomData) error {
+	snapshot := room.clone()
+	return s.write("room "+room.ID, func(st Store) error {
+		if overlay, ok := st.(*MemoryStore); ok {
+			overlay.adoptRoom(room)
+		}
+		return st.SaveRoom(ctx, room)
+	}, func(ctx context.Context, primary Store) error {
+		if s.stale[snapshot.ID] {
+			return ErrVersionConflict
+		}
+		err := primary.SaveRoom(ctx, snapshot)
+		if errors.Is(err, ErrVersionConflict) {
+			s.stale[snapshot.ID] = true
+		}
+		return err
+	})
+}
+
+func (s *HealthStore) GetRoom(ctx context.Context, roomID string) (*RoomData, error) {
+	if overlay := s.degraded(); overlay != nil {
+		return overlay.GetRoom(ctx, roomID)
+	}
+	room, err := s.primary.GetRoom(ctx, roomID)
+	if unavailable(err) {
+		return s.failover(err).GetRoom(ctx, roomID)
+	}
+	return room, err
+}
+
+func (s *HealthStore) DeleteRoom(ctx context.Context, roomID string) error {
+	return s.write("room deletion "+roomID, func(st Store) error {
+		return st.DeleteRoom(ctx, roomID)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.DeleteRoom(ctx, roomID)
+	})
+}
+
+func (s *HealthStore) GetRoomByCode(ctx context.Context, code string) (*RoomData, error) {
+	if overlay := s.degraded(); overlay != nil {
+		return overlay.GetRoomByCode(ctx, code)
+	}
+	room, err := s.primary.GetRoomByCode(ctx, code)
+	if unavailable(err) {
+		return s.failover(err).GetRoomByCode(ctx, code)
+	}
+	return room, err
+}
+
+// ReserveCode reserves on the primary. A code reserved while it is down can
+// turn out to be taken by another instance, which the replay logs.
+func (s *HealthStore) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := s.write("code reservation "+code, func(st Store) error {
+		var err error
+		ok, err = st.ReserveCode(ctx, code, roomID, ttl)
+		return err
+	}, func(ctx context.Context, primary Store) error {
+		// Only buffered when the overlay granted it
+		if !ok {
+			return nil
+		}
+		reserved, err := primary.ReserveCode(ctx, code, roomID, ttl)
+		if err == nil && !reserved {
+			return ErrCodeTaken
+		}
+		return err
+	})
+	return ok, err
+}
+
+func (s *HealthStore) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	return s.write("code refresh "+code, func(st Store) error {
+		return st.RefreshCode(ctx, code, roomID, ttl)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.RefreshCode(ctx, code, roomID, ttl)
+	})
+}
+
+func (s *HealthStore) ReleaseCode(ctx context.Context, code, roomID string) error {
+	return s.write("code release "+code, func(st Store) error {
+		return st.ReleaseCode(ctx, code, roomID)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.ReleaseCode(ctx, code, roomID)
+	})
+}
+
+func (s *HealthStore) SaveSession(ctx context.Context, sessionID, roomID string, playerIndex int) error {
+	return s.write("session "+sessionID, func(st Store) error {
+		return st.SaveSession(ctx, sessionID, roomID, playerIndex)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.SaveSession(ctx, sessionID, roomID, playerIndex)
+	})
+}
+
+func (s *HealthStore) GetSession(ctx context.Context, sessionID string) (string, int, error) {
+	if overlay := s.degraded(); overlay != nil {
+		return overlay.GetSession(ctx, sessionID)
+	}
+	roomID, playerIndex, err := s.primary.GetSession(ctx, sessionID)
+	if unavailable(err) {
+		return s.failover(err).GetSession(ctx, sessionID)
+	}
+	return roomID, playerIndex, err
+}
+
+func (s *HealthStore) DeleteSession(ctx context.Context, sessionID string) error {
+	return s.write("session deletion "+sessionID, func(st Store) error {
+		return st.DeleteSession(ctx, sessionID)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.DeleteSession(ctx, sessionID)
+	})
+}
+
+func (s *HealthStore) SaveMatch(ctx context.Context, match *MatchRecord) error {
+	return s.write("match "+match.RoomID, func(st Store) error {
+		return st.SaveMatch(ctx, match)
+	}, func(ctx context.Context, primary Store) error {
+		return primary.SaveMatch(ctx, match)
+	})
+}
+
+func (s *HealthStore) GetMatch(ctx context.Context, roomID string) (*MatchRecord, error) {
+	if overlay := s.degraded(); overlay != nil {
+		return overlay.GetMatch(ctx, roomID)
+	}
+	match, err := s.primary.GetMatch(ctx, roomID)
+	if unavailable(err) {
+		return s.failover(err).GetMatch(ctx, roomID)
+	}
+	return match, err
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+var errFlakyDown = errors.New("connection refused")
+
+// flakyStore is a MemoryStore that fails every call while it is down
+type flakyStore struct {
+	*MemoryStore
+
+	mu    sync.Mutex
+	down  bool
+	pings int
+}
+
+func (f *flakyStore) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyStore) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errFlakyDown
+	}
+	return nil
+}
+
+func (f *flakyStore) pingCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings
+}
+
+func (f *flakyStore) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	f.pings++
+	f.mu.Unlock()
+	return f.err()
+}
+
+func (f *flakyStore) SaveRoom(ctx context.Context, room *RoomData) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.SaveRoom(ctx, room)
+}
+
+func (f *flakyStore) GetRoom(ctx context.Context, roomID string) (*RoomData, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.MemoryStore.GetRoom(ctx, roomID)
+}
+
+func (f *flakyStore) DeleteRoom(ctx context.Context, roomID string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.DeleteRoom(ctx, roomID)
+}
+
+func (f *flakyStore) GetRoomByCode(ctx context.Context, code string) (*RoomData, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.MemoryStore.GetRoomByCode(ctx, code)
+}
+
+func (f *flakyStore) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	if err := f.err(); err != nil {
+		return false, err
+	}
+	return f.MemoryStore.ReserveCode(ctx, code, roomID, ttl)
+}
+
+func (f *flakyStore) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.RefreshCode(ctx, code, roomID, ttl)
+}
+
+func (f *flakyStore) ReleaseCode(ctx context.Context, code, roomID string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.ReleaseCode(ctx, code, roomID)
+}
+
+func (f *flakyStore) SaveSession(ctx context.Context, sessionID, roomID string, playerIndex int) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.SaveSession(ctx, sessionID, roomID, playerIndex)
+}
+
+func (f *flakyStore) GetSession(ctx context.Context, sessionID string) (string, int, error) {
+	if err := f.err(); err != nil {
+		return "", -1, err
+	}
+	return f.MemoryStore.GetSession(ctx, sessionID)
+}
+
+func (f *flakyStore) DeleteSession(ctx context.Context, sessionID string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.DeleteSession(ctx, sessionID)
+}
+
+func (f *flakyStore) SaveMatch(ctx context.Context, match *MatchRecord) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.SaveMatch(ctx, match)
+}
+
+func (f *flakyStore) GetMatch(ctx context.Context, roomID string) (*MatchRecord, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.MemoryStore.GetMatch(ctx, roomID)
+}
+
+// newFlakyHealthStore wraps a flakyStore in a HealthStore whose Run loop is
+// stopped at cleanup
+func newFlakyHealthStore(t *testing.T) (*HealthStore, *flakyStore, *clock.Fake) {
+	t.Helper()
+	fake := clock.NewFake(time.Unix(0, 0))
+	primary := &flakyStore{MemoryStore: NewMemoryStoreWithClock(fake)}
+	health := NewHealthStore(primary, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		health.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return health, primary, fake
+}
+
+// advanceWhenWaiting advances fake once the Run loop waits on its backoff
+func advanceWhenWaiting(t *testing.T, fake *clock.Fake, d time.Duration) {
+	t.Helper()
+	waitFor(t, func() bool { return fake.Pending() == 1 })
+	fake.Advance(d)
+}
+
+func TestHealthStoreBuffersWritesUntilPrimaryIsBack(t *testing.T) {
+	health, primary, fake := newFlakyHealthStore(t)
+	ctx := context.Background()
+
+	if err := health.SaveRoom(ctx, &RoomData{ID: "room-1", Code: "ABC123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := health.SaveSession(ctx, "s0", "room-1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	primary.setDown(true)
+	if err := health.SaveSession(ctx, "s1", "room-1", 1); err != nil {
+		t.Fatalf("expected a write during the outage to be buffered, got %v", err)
+	}
+	if err := health.DeleteSession(ctx, "s0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := health.SaveMatch(ctx, &MatchRecord{RoomID: "room-1", Winner: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	status := health.Status()
+	if status.Healthy || status.Outages != 1 || status.Buffered != 3 || status.LastError == "" {
+		t.Fatalf("expected one outage with 3 buffered writes, got %+v", status)
+	}
+	if roomID, index, _ := health.GetSession(ctx, "s1"); roomID != "room-1" || index != 1 {
+		t.Fatalf("expected reads to see buffered writes, got %q %d", roomID, index)
+	}
+
+	// Still down: the probe fails and nothing is replayed
+	advanceWhenWaiting(t, fake, reconnectBackoffMin)
+	waitFor(t, func() bool { return primary.pingCount() == 1 })
+	if health.Status().Healthy {
+		t.Fatal("expected the store to stay down while the primary does not answer")
+	}
+
+	primary.setDown(false)
+	advanceWhenWaiting(t, fake, 2*reconnectBackoffMin)
+	waitFor(t, func() bool { return health.Status().Healthy })
+
+	status = health.Status()
+	if status.Buffered != 0 || status.Replayed != 3 || status.Dropped != 0 {
+		t.Fatalf("expected every buffered write replayed, got %+v", status)
+	}
+	if roomID, _, _ := primary.MemoryStore.GetSession(ctx, "s1"); roomID != "room-1" {
+		t.Fatal("expected the buffered session to reach the primary")
+	}
+	if roomID, _, _ := primary.MemoryStore.GetSession(ctx, "s0"); roomID != "" {
+		t.Fatal("expected the buffered deletion to reach the primary")
+	}
+	if match, _ := primary.MemoryStore.GetMatch(ctx, "room-1"); match == nil || match.Winner != 1 {
+		t.Fatal("expected the buffered match to reach the primary")
+	}
+}
+
+func TestHealthStoreBacksOffWhileDown(t *testing.T) {
+	health, primary, fake := newFlakyHealthStore(t)
+
+	primary.setDown(true)
+	if err := health.Check(context.Background()); err == nil {
+		t.Fatal("expected the check to fail")
+	}
+
+	// Probes at 1s, then 2s and 4s after the previous one
+	for i, backoff := range []time.Duration{reconnectBackoffMin, 2 * reconnectBackoffMin, 4 * reconnectBackoffMin} {
+		advanceWhenWaiting(t, fake, backoff-time.Millisecond)
+		if got := primary.pingCount(); got != i+1 {
+			t.Fatalf("expected %d pings before the backoff ran out, got %d", i+1, got)
+		}
+		fake.Advance(time.Millisecond)
+		waitFor(t, func() bool { return primary.pingCount() == i+2 })
+	}
+}
+
+func TestHealthStoreReplaysRoomAtItsVersion(t *testing.T) {
+	health, primary, fake := newFlakyHealthStore(t)
+	ctx := context.Background()
+
+	room := &RoomData{ID: "room-1", PlateCount: 20}
+	if err := health.SaveRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller keeps the version it saved and goes on from it
+	primary.setDown(true)
+	for _, plates := range []int{24, 30} {
+		room.PlateCount = plates
+		if err := health.SaveRoom(ctx, room); err != nil {
+			t.Fatalf("expected a save at the primary's version to be buffered, got %v", err)
+		}
+	}
+	if room.Version != 3 || health.Status().Healthy {
+		t.Fatalf("expected version 3 during the outage, got %d", room.Version)
+	}
+
+	primary.setDown(false)
+	advanceWhenWaiting(t, fake, reconnectBackoffMin)
+	waitFor(t, func() bool { return health.Status().Healthy })
+
+	stored, _ := health.GetRoom(ctx, "room-1")
+	if stored == nil || stored.PlateCount != 30 || stored.Version != 3 {
+		t.Fatalf("expected both buffered saves replayed in order, got %+v", stored)
+	}
+	if status := health.Status(); status.Replayed != 2 || status.Dropped != 0 {
+		t.Fatalf("expected 2 replayed saves, got %+v", status)
+	}
+}
+
+func TestHealthStoreDropsConflictingBufferedRoom(t *testing.T) {
+	health, primary, fake := newFlakyHealthStore(t)
+	ctx := context.Background()
+
+	room := &RoomData{ID: "room-1", PlateCount: 20}
+	health.SaveRoom(ctx, room)
+
+	primary.setDown(true)
+	for _, plates := range []int{24, 30} {
+		room.PlateCount = plates
+		if err := health.SaveRoom(ctx, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another writer saves the room while this one is cut off
+	other, _ := primary.MemoryStore.GetRoom(ctx, "room-1")
+	other.PlateCount = 8
+	if err := primary.MemoryStore.SaveRoom(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	primary.setDown(false)
+	advanceWhenWaiting(t, fake, reconnectBackoffMin)
+	waitFor(t, func() bool { return health.Status().Healthy })
+
+	// The second save is at the other writer's version number, but follows
+	// the conflicting first one, so it is dropped too
+	stored, _ := health.GetRoom(ctx, "room-1")
+	if stored == nil || stored.PlateCount != 8 || stored.Version != 2 {
+		t.Fatalf("expected the other writer's room kept, got %+v", stored)
+	}
+	if status := health.Status(); status.Dropped != 2 {
+		t.Fatalf("expected both buffered saves dropped, got %+v", status)
+	}
+}
+
+func TestHealthStorePassesStoreErrorsThrough(t *testing.T) {
+	health, _, _ := newFlakyHealthStore(t)
+	ctx := context.Background()
+
+	if err := health.SaveRoom(ctx, &RoomData{ID: "room-1", Code: "ABC123"}); err != nil {
+		t.Fatal(err)
+	}
+	err := health.SaveRoom(ctx, &RoomData{ID: "room-2", Code: "ABC123"})
+	if !errors.Is(err, ErrCodeTaken) {
+		t.Fatalf("expected ErrCodeTaken, got %v", err)
+	}
+	if !health.Status().Healthy {
+		t.Fatal("expected a refused write not to count as an outage")
+	}
+}
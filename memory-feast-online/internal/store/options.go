@@ -0,0 +1,148 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisOptions configures the connection to Redis: a single node, a
+// Sentinel-managed master or a Cluster
+type RedisOptions struct {
+	// Addrs is the node, the sentinels when MasterName is set, or the seed
+	// nodes in Cluster mode
+	Addrs    []string
+	Username string // ACL user; empty for the default user
+	Password string
+	DB       int // Not available in Cluster mode
+
+	// MasterName selects Sentinel failover to the master of that name
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+
+	Cluster bool
+
+	TLS        bool
+	CAFile     string // PEM certificates to trust instead of the system roots; implies TLS
+	ServerName string // Name to verify the certificate against, if not the address
+
+	// KeyPrefix is put before every key and pub/sub channel, so several
+	// environments can share one Redis
+	KeyPrefix string
+}
+
+// validate reports the first option combination Redis cannot serve
+func (o RedisOptions) validate() error {
+	switch {
+	case len(o.Addrs) == 0:
+		return errors.New("no Redis address")
+	case o.Cluster && o.MasterName != "":
+		return errors.New("redis Cluster and Sentinel cannot be combined")
+	case o.Cluster && o.DB != 0:
+		return errors.New("redis Cluster only has DB 0")
+	case strings.ContainsAny(o.KeyPrefix, "{}"):
+		// A brace would change the hash tag that keeps keys in one slot
+		return fmt.Errorf("redis key prefix %q cannot contain braces", o.KeyPrefix)
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS settings, or nil for a plain connection
+func (o RedisOptions) tlsConfig() (*tls.Config, error) {
+	if !o.TLS && o.CAFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: o.ServerName}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in Redis CA file %s", o.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// client creates the client for the configured deployment without
+// connecting
+func (o RedisOptions) client() (redis.UniversalClient, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	universal := &redis.UniversalOptions{
+		Addrs:            o.Addrs,
+		Username:         o.Username,
+		Password:         o.Password,
+		DB:               o.DB,
+		MasterName:       o.MasterName,
+		SentinelUsername: o.SentinelUsername,
+		SentinelPassword: o.SentinelPassword,
+		TLSConfig:        tlsConfig,
+	}
+	// Chosen explicitly: NewUniversalClient would take any two addresses
+	// for a Cluster
+	switch {
+	case o.MasterName != "":
+		return redis.NewFailoverClient(universal.Failover()), nil
+	case o.Cluster:
+		return redis.NewClusterClient(universal.Cluster()), nil
+	default:
+		return redis.NewClient(universal.Simple()), nil
+	}
+}
+
+// keyspace names one environment's keys. In Cluster mode, keys that one
+// transaction or script uses together carry the same hash tag so they land
+// in one slot.
+type keyspace struct {
+	prefix  string
+	cluster bool
+}
+
+func (k keyspace) tagged(tag, key string) string {
+	if k.cluster {
+		return k.prefix + "{" + tag + "}" + key
+	}
+	return k.prefix + key
+}
+
+// An invite room shares its code's tag, so SaveRoom and DeleteRoom change
+// both in one script. A matchmade room has no code and is tagged by its ID,
+// and rooms and codes alike spread over the Cluster's slots.
+func (k keyspace) room(roomID, code string) string {
+	if code != "" {
+		return k.tagged(codeKeyPrefix+code, roomKeyPrefix+roomID)
+	}
+	return k.tagged(roomKeyPrefix+roomID, roomKeyPrefix+roomID)
+}
+func (k keyspace) code(code string) string { return k.tagged(codeKeyPrefix+code, codeKeyPrefix+code) }
+
+// roomCode indexes a room's code by its ID, to find the room's key
+func (k keyspace) roomCode(roomID string) string { return k.prefix + roomCodeKeyPrefix + roomID }
+
+func (k keyspace) session(sessionID string) string { return k.prefix + sessionKeyPrefix + sessionID }
+func (k keyspace) match(roomID string) string      { return k.prefix + matchKeyPrefix + roomID }
+
+// queue returns the keys of the matchmaking queue scripts, in KEYS order
+func (k keyspace) queue() []string {
+	return []string{
+		k.tagged("queue", queueTicketsKey),
+		k.tagged("queue", queueOrderKey),
+		k.tagged("queue", queueAliveKey),
+	}
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisOptionsChooseClient(t *testing.T) {
+	client, err := RedisOptions{Addrs: []string{"a:6379", "b:6379"}, Cluster: true}.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Fatalf("expected a Cluster client, got %T", client)
+	}
+
+	// Two addresses without Cluster mode are sentinels, not Cluster nodes
+	client, err = RedisOptions{Addrs: []string{"a:26379", "b:26379"}, MasterName: "feast", DB: 2}.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, ok := client.(*redis.Client); !ok {
+		t.Fatalf("expected a failover client, got %T", client)
+	}
+
+	invalid := map[string]RedisOptions{
+		"no address":         {},
+		"cluster DB":         {Addrs: []string{"a:6379"}, Cluster: true, DB: 1},
+		"cluster sentinel":   {Addrs: []string{"a:6379"}, Cluster: true, MasterName: "feast"},
+		"brace in prefix":    {Addrs: []string{"a:6379"}, KeyPrefix: "{prod}:"},
+		"missing CA file":    {Addrs: []string{"a:6379"}, CAFile: filepath.Join(t.TempDir(), "ca.pem")},
+		"CA file without CA": {Addrs: []string{"a:6379"}, CAFile: writeFile(t, "ca.pem", "not a certificate")},
+	}
+	for name, opts := range invalid {
+		if client, err := opts.client(); err == nil {
+			client.Close()
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRedisOptionsTrustCAFile(t *testing.T) {
+	opts := RedisOptions{Addrs: []string{"a:6379"}}
+	if config, err := opts.tlsConfig(); err != nil || config != nil {
+		t.Fatalf("expected a plain connection by default, got %v %v", config, err)
+	}
+
+	opts.CAFile = writeFile(t, "ca.pem", selfSignedPEM(t))
+	opts.ServerName = "redis.internal"
+	config, err := opts.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil || config.RootCAs == nil || config.ServerName != "redis.internal" {
+		t.Fatalf("expected TLS trusting the CA file, got %+v", config)
+	}
+}
+
+func TestKeyspaceTagsKeysInClusterMode(t *testing.T) {
+	single := keyspace{prefix: "prod:"}
+	if got := single.room("r1", "ABC123"); got != "prod:room:r1" {
+		t.Fatalf("expected prod:room:r1, got %s", got)
+	}
+	if got := single.queue()[0]; got != "prod:queue:tickets" {
+		t.Fatalf("expected prod:queue:tickets, got %s", got)
+	}
+
+	clustered := keyspace{prefix: "prod:", cluster: true}
+	if room, code := clustered.room("r1", "ABC123"), clustered.code("ABC123"); room != "prod:{code:ABC123}room:r1" || code != "prod:{code:ABC123}code:ABC123" {
+		t.Fatalf("expected an invite room to share its code's tag, got %s %s", room, code)
+	}
+	if got := clustered.room("r2", ""); got != "prod:{room:r2}room:r2" {
+		t.Fatalf("expected a matchmade room tagged by its ID, got %s", got)
+	}
+	for _, key := range clustered.queue() {
+		if !strings.HasPrefix(key, "prod:{queue}queue:") {
+			t.Fatalf("expected queue keys to share a hash tag, got %s", key)
+		}
+	}
+	if got := clustered.session("s1"); got != "prod:session:s1" {
+		t.Fatalf("expected single-key records untagged, got %s", got)
+	}
+}
+
+// TestRedisStoreKeyPrefixSeparatesEnvironments runs against the server at
+// REDIS_TEST_ADDR
+func TestRedisStoreKeyPrefixSeparatesEnvironments(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	ctx := context.Background()
+	stores := make([]*RedisStore, 2)
+	for i, prefix := range []string{"staging:", "prod:"} {
+		st, err := NewRedisStore(RedisOptions{Addrs: []string{addr}, KeyPrefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		stores[i] = st
+	}
+
+	roomID, code := "room-"+uniqueSuffix(), uniqueCode("P")
+	for i, st := range stores {
+		if err := st.SaveRoom(ctx, &RoomData{ID: roomID, Code: code, PlateCount: 20 + i}); err != nil {
+			t.Fatalf("expected each environment to take the same code, got %v", err)
+		}
+		t.Cleanup(func() { st.DeleteRoom(ctx, roomID) })
+	}
+	for i, st := range stores {
+		room, err := st.GetRoomByCode(ctx, code)
+		if err != nil || room == nil || room.PlateCount != 20+i {
+			t.Fatalf("expected environment %d to read its own room, got %+v %v", i, room, err)
+		}
+	}
+	if n, _ := stores[0].Client().Exists(ctx, "staging:room:"+roomID).Result(); n != 1 {
+		t.Fatal("expected the room under the staging prefix")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func selfSignedPEM(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/game"
+)
+
+const (
+	queueTicketsKey = "queue:tickets" // Hash: session ID -> ticket JSON
+	queueOrderKey   = "queue:order"   // Sorted set: session ID by join time
+	queueAliveKey   = "queue:alive"   // Sorted set: session ID by last heartbeat
+)
+
+// queuePrune drops the tickets whose last heartbeat is older than ARGV[1],
+// left by instances that went away. Every queue script starts with it.
+const queuePrune = `
+for _, id in ipairs(redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", "(" .. ARGV[1])) do
+	redis.call("HDEL", KEYS[1], id)
+	redis.call("ZREM", KEYS[2], id)
+	redis.call("ZREM", KEYS[3], id)
+end
+`
+
+// queuePushScript pairs a ticket with the longest waiting one or queues it,
+// atomically. ARGV: stale cutoff, now (ms), session ID, ticket. Returns
+// {position, ""} or {0, opponent ticket}.
+var queuePushScript = redis.NewScript(queuePrune + `
+if redis.call("HEXISTS", KEYS[1], ARGV[3]) == 1 then
+	redis.call("HSET", KEYS[1], ARGV[3], ARGV[4])
+	redis.call("ZADD", KEYS[3], ARGV[2], ARGV[3])
+	return {redis.call("ZRANK", KEYS[2], ARGV[3]) + 1, ""}
+end
+local first = redis.call("ZRANGE", KEYS[2], 0, 0)
+if #first > 0 then
+	local opponent = redis.call("HGET", KEYS[1], first[1])
+	redis.call("HDEL", KEYS[1], first[1])
+	redis.call("ZREM", KEYS[2], first[1])
+	redis.call("ZREM", KEYS[3], first[1])
+	return {0, opponent}
+end
+redis.call("HSET", KEYS[1], ARGV[3], ARGV[4])
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[3])
+redis.call("ZADD", KEYS[3], ARGV[2], ARGV[3])
+return {redis.call("ZCARD", KEYS[2]), ""}
+`)
+
+// queueRemoveScript takes a session out of the queue. ARGV: stale cutoff,
+// session ID.
+var queueRemoveScript = redis.NewScript(queuePrune + `
+redis.call("ZREM", KEYS[2], ARGV[2])
+redis.call("ZREM", KEYS[3], ARGV[2])
+return redis.call("HDEL", KEYS[1], ARGV[2])
+`)
+
+// queuePositionScript returns a session's 1-indexed position or 0. ARGV:
+// stale cutoff, session ID.
+var queuePositionScript = redis.NewScript(queuePrune + `
+local rank = redis.call("ZRANK", KEYS[2], ARGV[2])
+if rank then
+	return rank + 1
+end
+return 0
+`)
+
+// queueSizeScript returns the number of live tickets. ARGV: stale cutoff.
+var queueSizeScript = redis.NewScript(queuePrune + `
+return redis.call("ZCARD", KEYS[2])
+`)
+
+// queueHeartbeatScript refreshes the heartbeat of the queued sessions among
+// ARGV[3:]. ARGV: stale cutoff, now (ms), session IDs.
+var queueHeartbeatScript = redis.NewScript(queuePrune + `
+for i = 3, #ARGV do
+	redis.call("ZADD", KEYS[3], "XX", ARGV[2], ARGV[i])
+end
+return 0
+`)
+
+// RedisQueue is a matchmaking queue shared by every instance on the Redis
+// server. Each operation is one Lua script, so pairing is atomic, and
+// tickets without a heartbeat for game.QueueHeartbeatTTL are dropped.
+// RedisStore.Queue creates one.
+type RedisQueue struct {
+	client redis.UniversalClient
+	keys   []string // Tickets, order and alive keys, see keyspace.queue
+	clock  clock.Clock
+}
+
+// staleBefore is the heartbeat cutoff for live tickets at now
+func staleBefore(now time.Time) int64 {
+	return now.Add(-game.QueueHeartbeatTTL).UnixMilli()
+}
+
+func (q *RedisQueue) Push(ctx context.Context, ticket *game.QueueTicket) (int, *game.QueueTicket, error) {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to marshal queue ticket: %w", err)
+	}
+
+	now := q.clock.Now()
+	reply, err := queuePushScript.Run(ctx, q.client, q.keys, staleBefore(now), now.UnixMilli(), ticket.SessionID, data).Slice()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to push queue ticket: %w", err)
+	}
+	if len(reply) != 2 {
+		return 0, nil, fmt.Errorf("unexpected queue push reply %v", reply)
+	}
+
+	position, _ := reply[0].(int64)
+	encoded, _ := reply[1].(string)
+	if encoded == "" {
+		return int(position), nil, nil
+	}
+
+	var opponent game.QueueTicket
+	if err := json.Unmarshal([]byte(encoded), &opponent); err != nil {
+		return 0, nil, fmt.Errorf("failed to unmarshal queue ticket: %w", err)
+	}
+	return 0, &opponent, nil
+}
+
+func (q *RedisQueue) Remove(ctx context.Context, sessionID string) (bool, error) {
+	removed, err := queueRemoveScript.Run(ctx, q.client, q.keys, staleBefore(q.clock.Now()), sessionID).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove queue ticket: %w", err)
+	}
+	return removed > 0, nil
+}
+
+func (q *RedisQueue) Position(ctx context.Context, sessionID string) (int, error) {
+	position, err := queuePositionScript.Run(ctx, q.client, q.keys, staleBefore(q.clock.Now()), sessionID).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get queue position: %w", err)
+	}
+	return position, nil
+}
+
+func (q *RedisQueue) Size(ctx context.Context) (int, error) {
+	size, err := queueSizeScript.Run(ctx, q.client, q.keys, staleBefore(q.clock.Now())).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get queue size: %w", err)
+	}
+	return size, nil
+}
+
+func (q *RedisQueue) Heartbeat(ctx context.Context, sessionIDs []string) error {
+	now := q.clock.Now()
+	args := make([]any, 0, len(sessionIDs)+2)
+	args = append(args, staleBefore(now), now.UnixMilli())
+	for _, sessionID := range sessionIDs {
+		args = append(args, sessionID)
+	}
+	if err := queueHeartbeatScript.Run(ctx, q.client, q.keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed to refresh queue tickets: %w", err)
+	}
+	return nil
+}
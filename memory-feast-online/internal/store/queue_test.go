@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+	"memory-feast-online/internal/game"
+)
+
+// TestRedisQueue runs against the server at REDIS_TEST_ADDR and clears its
+// queue keys
+func TestRedisQueue(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	st, err := NewRedisStore(RedisOptions{Addrs: []string{addr}, KeyPrefix: "test:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	client := st.Client()
+	ctx := context.Background()
+	fake := clock.NewFake(time.Now())
+	queue := st.Queue(fake)
+	if err := client.Del(ctx, queue.keys...).Err(); err != nil {
+		t.Fatalf("failed to clear queue: %v", err)
+	}
+	ticket := func(sessionID, instance string) *game.QueueTicket {
+		return &game.QueueTicket{SessionID: sessionID, Nickname: sessionID, PlateCount: 20, Instance: instance, JoinedAt: time.Now()}
+	}
+
+	if position, opponent, err := queue.Push(ctx, ticket("s1", "a")); err != nil || position != 1 || opponent != nil {
+		t.Fatalf("expected s1 queued first, got %d %v %v", position, opponent, err)
+	}
+	if position, opponent, err := queue.Push(ctx, ticket("s1", "a")); err != nil || position != 1 || opponent != nil {
+		t.Fatalf("expected a queued session to keep its place, got %d %v %v", position, opponent, err)
+	}
+
+	position, opponent, err := queue.Push(ctx, ticket("s2", "b"))
+	if err != nil || position != 0 || opponent == nil {
+		t.Fatalf("expected s2 to be paired, got %d %v %v", position, opponent, err)
+	}
+	if opponent.SessionID != "s1" || opponent.Instance != "a" {
+		t.Fatalf("expected s1 from instance a as the opponent, got %+v", opponent)
+	}
+	if size, _ := queue.Size(ctx); size != 0 {
+		t.Fatalf("expected an empty queue after pairing, got %d", size)
+	}
+
+	queue.Push(ctx, ticket("s3", "a"))
+	if removed, err := queue.Remove(ctx, "s3"); err != nil || !removed {
+		t.Fatalf("expected s3 to be removed, got %v %v", removed, err)
+	}
+	if removed, _ := queue.Remove(ctx, "s3"); removed {
+		t.Fatal("expected a second remove to report nothing queued")
+	}
+
+	// A ticket whose instance stopped sending heartbeats is dropped, by the
+	// queue's clock
+	queue.Push(ctx, ticket("s4", "gone"))
+	fake.Advance(game.QueueHeartbeatTTL + time.Second)
+	if position, opponent, _ := queue.Push(ctx, ticket("s5", "a")); position != 1 || opponent != nil {
+		t.Fatalf("expected s5 queued instead of paired with expired s4, got %d %v", position, opponent)
+	}
+	if err := queue.Heartbeat(ctx, []string{"s5", "unknown"}); err != nil {
+		t.Fatalf("failed to heartbeat: %v", err)
+	}
+	if size, _ := queue.Size(ctx); size != 1 {
+		t.Fatalf("expected only s5 queued, got %d", size)
+	}
+	if n, _ := client.ZCard(ctx, queue.keys[2]).Result(); n != 1 {
+		t.Fatalf("expected heartbeats only for queued sessions, got %d", n)
+	}
+}
@@ -2,42 +2,91 @@ package store
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"memory-feast-online/internal/clock"
+)
+
+const (
+	roomKeyPrefix     = "room:"
+	codeKeyPrefix     = "code:"
+	roomCodeKeyPrefix = "room-code:"
+	sessionKeyPrefix  = "session:"
+	matchKeyPrefix    = "match:"
+	roomTTL           = 24 * time.Hour
+	sessionTTL        = 1 * time.Hour
+	matchTTL          = 30 * 24 * time.Hour
+
+	// JanitorInterval is how often a MemoryStore janitor drops expired entries
+	JanitorInterval = time.Minute
 )
 
+// StoreError is a failed store operation the caller can act on
+type StoreError string
+
+func (e StoreError) Error() string { return string(e) }
+
 const (
-	roomKeyPrefix    = "room:"
-	sessionKeyPrefix = "session:"
-	roomTTL          = 24 * time.Hour
-	sessionTTL       = 1 * time.Hour
+	// ErrVersionConflict means the room changed since the saved version was
+	// read; read it again and retry
+	ErrVersionConflict StoreError = "room version conflict"
+	// ErrCodeTaken means the room's invite code is held by another room
+	ErrCodeTaken StoreError = "room code held by another room"
 )
 
 // Store defines the interface for game state persistence
 type Store interface {
+	// SaveRoom writes the room if the stored room is still at room.Version
+	// (0 for a new room), then advances room.Version. It returns
+	// ErrVersionConflict otherwise. The room's code must be free or held by
+	// the room; a free code is claimed, while a held one keeps the TTL that
+	// ReserveCode or RefreshCode gave it.
 	SaveRoom(ctx context.Context, room *RoomData) error
 	GetRoom(ctx context.Context, roomID string) (*RoomData, error)
 	DeleteRoom(ctx context.Context, roomID string) error
 	GetRoomByCode(ctx context.Context, code string) (*RoomData, error)
+	ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error)
+	RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error
+	ReleaseCode(ctx context.Context, code, roomID string) error
 	SaveSession(ctx context.Context, sessionID, roomID string, playerIndex int) error
 	GetSession(ctx context.Context, sessionID string) (roomID string, playerIndex int, err error)
 	DeleteSession(ctx context.Context, sessionID string) error
+	SaveMatch(ctx context.Context, match *MatchRecord) error
+	GetMatch(ctx context.Context, roomID string) (*MatchRecord, error)
 }
 
-// RoomData is the serializable room state for Redis
+// RoomData is the serializable room state for Redis. Stored rooms, sessions
+// and matches carry a schema version, see SchemaVersion.
 type RoomData struct {
 	ID         string       `json:"id"`
 	Code       string       `json:"code"`
+	Owner      string       `json:"owner,omitempty"` // Server instance running the room
+	Version    int64        `json:"version"`         // Advanced by every save, see SaveRoom
 	PlateCount int          `json:"plateCount"`
 	Players    []PlayerData `json:"players"`
 	State      StateData    `json:"state"`
 	CreatedAt  time.Time    `json:"createdAt"`
 }
 
+// MatchRecord is the permanent record of a finished game
+type MatchRecord struct {
+	RoomID            string       `json:"roomId"`
+	Players           []PlayerData `json:"players"`
+	PlateCount        int          `json:"plateCount"`
+	Ruleset           string       `json:"ruleset"`
+	FirstPlayerPolicy string       `json:"firstPlayerPolicy"`
+	FirstPlayer       int          `json:"firstPlayer"`
+	Winner            int          `json:"winner"` // Player index, -1 for a draw
+	Reason            string       `json:"reason"`
+	FinalTokens       []int        `json:"finalTokens"`
+	StartedAt         time.Time    `json:"startedAt"`
+	EndedAt           time.Time    `json:"endedAt"`
+}
+
 // PlayerData is the serializable player state
 type PlayerData struct {
 	ID        string `json:"id"`
@@ -71,28 +120,125 @@ type SessionData struct {
 	PlayerIndex int    `json:"playerIndex"`
 }
 
+// releaseCodeScript deletes a code mapping only while it still points at
+// the releasing room, so a stale release never frees someone else's code.
+var releaseCodeScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// saveRoomScript writes a room (ARGV[2]) over the version it was read at. A
+// room with a code (KEYS[2]) needs the code free or held by the room, and
+// claims a free one; a held one keeps its TTL. It returns 1 when saved, 0 on
+// a version conflict, -1 when another room holds the code and -2 when the
+// stored room has a newer schema than ARGV[5].
+var saveRoomScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+local version = 0
+if current then
+	current = cjson.decode(current)
+	if (current.schema or 0) > tonumber(ARGV[5]) then
+		return -2
+	end
+	version = current.version or 0
+end
+if version ~= tonumber(ARGV[1]) then
+	return 0
+end
+if KEYS[2] then
+	local holder = redis.call("GET", KEYS[2])
+	if holder and holder ~= ARGV[3] then
+		return -1
+	end
+	if not holder then
+		redis.call("SET", KEYS[2], ARGV[3], "PX", ARGV[4])
+	end
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[4])
+return 1
+`)
+
+// deleteRoomScript deletes a room and frees its code (KEYS[2]) if the room
+// still holds it
+var deleteRoomScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+if KEYS[2] and redis.call("GET", KEYS[2]) == ARGV[1] then
+	redis.call("DEL", KEYS[2])
+end
+return 1
+`)
+
+// refreshCodeScript extends a code mapping's TTL while it is still owned by
+// the room.
+var refreshCodeScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
 // RedisStore implements Store using Redis
 type RedisStore struct {
-	client *redis.Client
+	client redis.UniversalClient
+	keys   keyspace
 }
 
-// NewRedisStore creates a new Redis store
-func NewRedisStore(addr, password string, db int) (*RedisStore, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+// NewRedisStore creates a new Redis store and checks that the server
+// answers
+func NewRedisStore(opts RedisOptions) (*RedisStore, error) {
+	s, err := OpenRedisStore(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	if err := s.Ping(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenRedisStore creates a Redis store without connecting; the client
+// connects on first use and reconnects after failures
+func OpenRedisStore(opts RedisOptions) (*RedisStore, error) {
+	client, err := opts.client()
+	if err != nil {
+		return nil, err
 	}
+	return &RedisStore{client: client, keys: keyspace{prefix: opts.KeyPrefix, cluster: opts.Cluster}}, nil
+}
+
+// Ping checks that the Redis server answers
+func (s *RedisStore) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return nil
+}
 
-	return &RedisStore{client: client}, nil
+// Client returns the store's Redis client, e.g. to share it with the
+// cluster bus
+func (s *RedisStore) Client() redis.UniversalClient {
+	return s.client
+}
+
+// KeyPrefix returns the prefix of the store's keys, for the channels of a
+// bus sharing its client
+func (s *RedisStore) KeyPrefix() string {
+	return s.keys.prefix
+}
+
+// Queue returns a matchmaking queue in the store's keyspace that stamps
+// heartbeats from clk. clk may be nil, in which case the wall clock is used.
+func (s *RedisStore) Queue(clk clock.Clock) *RedisQueue {
+	return &RedisQueue{client: s.client, keys: s.keys.queue(), clock: clock.OrReal(clk)}
 }
 
 // Close closes the Redis connection
@@ -100,31 +246,85 @@ func (s *RedisStore) Close() error {
 	return s.client.Close()
 }
 
-// SaveRoom saves room data to Redis
+// SaveRoom saves room data to Redis. One script checks the version, claims
+// or checks the code and writes the room, so nothing is written unless all of
+// it is; in Cluster mode the room shares its code's slot for this.
 func (s *RedisStore) SaveRoom(ctx context.Context, room *RoomData) error {
-	data, err := json.Marshal(room)
+	next := *room
+	next.Version = room.Version + 1
+	data, err := encodeRecord(&next)
 	if err != nil {
 		return fmt.Errorf("failed to marshal room: %w", err)
 	}
 
-	// Save by ID
-	key := roomKeyPrefix + room.ID
-	if err := s.client.Set(ctx, key, data, roomTTL).Err(); err != nil {
-		return fmt.Errorf("failed to save room: %w", err)
+	// Matchmade rooms have no code
+	keys := []string{s.keys.room(room.ID, room.Code)}
+	if room.Code != "" {
+		keys = append(keys, s.keys.code(room.Code))
+		if err := s.indexRoomCode(ctx, room); err != nil {
+			return err
+		}
 	}
 
-	// Also save code -> ID mapping
-	codeKey := "code:" + room.Code
-	if err := s.client.Set(ctx, codeKey, room.ID, roomTTL).Err(); err != nil {
-		return fmt.Errorf("failed to save room code mapping: %w", err)
+	saved, err := saveRoomScript.Run(ctx, s.client, keys, room.Version, data, room.ID, roomTTL.Milliseconds(), SchemaVersion).Int()
+	if err != nil {
+		return fmt.Errorf("failed to save room: %w", err)
+	}
+	switch saved {
+	case 0:
+		return ErrVersionConflict
+	case -1:
+		return ErrCodeTaken
+	case -2:
+		return fmt.Errorf("failed to save room: %w", ErrNewerSchema)
 	}
 
+	room.Version = next.Version
+	return nil
+}
+
+// indexRoomCode records the room's code under its ID, which lookups by ID
+// need to find the room's key. The index is only set by the room's first
+// save and removed by DeleteRoom, so a room saved again with another code is
+// refused as a conflict rather than written beside the stored one.
+func (s *RedisStore) indexRoomCode(ctx context.Context, room *RoomData) error {
+	key := s.keys.roomCode(room.ID)
+	var indexed *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, key, room.Code, roomTTL)
+		indexed = pipe.Get(ctx, key)
+		pipe.Expire(ctx, key, roomTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index room code: %w", err)
+	}
+	if indexed.Val() != room.Code {
+		return ErrVersionConflict
+	}
 	return nil
 }
 
 // GetRoom retrieves room data from Redis
 func (s *RedisStore) GetRoom(ctx context.Context, roomID string) (*RoomData, error) {
-	key := roomKeyPrefix + roomID
+	code, err := s.roomCode(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return s.getRoom(ctx, s.keys.room(roomID, code))
+}
+
+// roomCode returns the code a room was saved with, empty for a matchmade
+// room
+func (s *RedisStore) roomCode(ctx context.Context, roomID string) (string, error) {
+	code, err := s.client.Get(ctx, s.keys.roomCode(roomID)).Result()
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to get room code: %w", err)
+	}
+	return code, nil
+}
+
+func (s *RedisStore) getRoom(ctx context.Context, key string) (*RoomData, error) {
 	data, err := s.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
@@ -134,42 +334,41 @@ func (s *RedisStore) GetRoom(ctx context.Context, roomID string) (*RoomData, err
 	}
 
 	var room RoomData
-	if err := json.Unmarshal(data, &room); err != nil {
+	if err := decodeRecord(recordRoom, data, &room); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
 	}
 
 	return &room, nil
 }
 
-// DeleteRoom removes room data from Redis
+// DeleteRoom removes room data from Redis and, in the same script, frees
+// the code mapping if the room still holds it
 func (s *RedisStore) DeleteRoom(ctx context.Context, roomID string) error {
-	// Get room first to delete code mapping
-	room, err := s.GetRoom(ctx, roomID)
+	code, err := s.roomCode(ctx, roomID)
 	if err != nil {
 		return err
 	}
-	if room == nil {
-		return nil
-	}
 
-	// Delete room
-	key := roomKeyPrefix + roomID
-	if err := s.client.Del(ctx, key).Err(); err != nil {
+	keys := []string{s.keys.room(roomID, code)}
+	if code != "" {
+		keys = append(keys, s.keys.code(code))
+	}
+	if err := deleteRoomScript.Run(ctx, s.client, keys, roomID).Err(); err != nil {
 		return fmt.Errorf("failed to delete room: %w", err)
 	}
 
-	// Delete code mapping
-	codeKey := "code:" + room.Code
-	if err := s.client.Del(ctx, codeKey).Err(); err != nil {
-		return fmt.Errorf("failed to delete room code mapping: %w", err)
+	// Only read to find the room, so it can go last
+	if code != "" {
+		if err := s.client.Del(ctx, s.keys.roomCode(roomID)).Err(); err != nil {
+			return fmt.Errorf("failed to delete room code index: %w", err)
+		}
 	}
-
 	return nil
 }
 
 // GetRoomByCode retrieves room data by invite code
 func (s *RedisStore) GetRoomByCode(ctx context.Context, code string) (*RoomData, error) {
-	codeKey := "code:" + code
+	codeKey := s.keys.code(code)
 	roomID, err := s.client.Get(ctx, codeKey).Result()
 	if err != nil {
 		if err == redis.Nil {
@@ -178,7 +377,35 @@ func (s *RedisStore) GetRoomByCode(ctx context.Context, code string) (*RoomData,
 		return nil, fmt.Errorf("failed to get room by code: %w", err)
 	}
 
-	return s.GetRoom(ctx, roomID)
+	return s.getRoom(ctx, s.keys.room(roomID, code))
+}
+
+// ReserveCode atomically claims an invite code for roomID (SET NX).
+// Returns false if the code is already held by another room.
+func (s *RedisStore) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	ok, err := s.client.SetNX(ctx, s.keys.code(code), roomID, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve room code: %w", err)
+	}
+	return ok, nil
+}
+
+// RefreshCode extends the reservation of code if roomID still holds it
+func (s *RedisStore) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	err := refreshCodeScript.Run(ctx, s.client, []string{s.keys.code(code)}, roomID, ttl.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to refresh room code: %w", err)
+	}
+	return nil
+}
+
+// ReleaseCode frees code if roomID still holds it
+func (s *RedisStore) ReleaseCode(ctx context.Context, code, roomID string) error {
+	err := releaseCodeScript.Run(ctx, s.client, []string{s.keys.code(code)}, roomID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete room code mapping: %w", err)
+	}
+	return nil
 }
 
 // SaveSession saves session-to-room mapping
@@ -188,12 +415,12 @@ func (s *RedisStore) SaveSession(ctx context.Context, sessionID, roomID string,
 		PlayerIndex: playerIndex,
 	}
 
-	jsonData, err := json.Marshal(data)
+	jsonData, err := encodeRecord(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal session: %w", err)
 	}
 
-	key := sessionKeyPrefix + sessionID
+	key := s.keys.session(sessionID)
 	if err := s.client.Set(ctx, key, jsonData, sessionTTL).Err(); err != nil {
 		return fmt.Errorf("failed to save session: %w", err)
 	}
@@ -203,7 +430,7 @@ func (s *RedisStore) SaveSession(ctx context.Context, sessionID, roomID string,
 
 // GetSession retrieves session data
 func (s *RedisStore) GetSession(ctx context.Context, sessionID string) (string, int, error) {
-	key := sessionKeyPrefix + sessionID
+	key := s.keys.session(sessionID)
 	data, err := s.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
@@ -213,7 +440,7 @@ func (s *RedisStore) GetSession(ctx context.Context, sessionID string) (string,
 	}
 
 	var session SessionData
-	if err := json.Unmarshal(data, &session); err != nil {
+	if err := decodeRecord(recordSession, data, &session); err != nil {
 		return "", -1, fmt.Errorf("failed to unmarshal session: %w", err)
 	}
 
@@ -222,54 +449,226 @@ func (s *RedisStore) GetSession(ctx context.Context, sessionID string) (string,
 
 // DeleteSession removes session data
 func (s *RedisStore) DeleteSession(ctx context.Context, sessionID string) error {
-	key := sessionKeyPrefix + sessionID
+	key := s.keys.session(sessionID)
 	if err := s.client.Del(ctx, key).Err(); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
 	return nil
 }
 
-// MemoryStore implements Store using in-memory maps (for testing/simple deployments)
+// SaveMatch stores a finished game's record
+func (s *RedisStore) SaveMatch(ctx context.Context, match *MatchRecord) error {
+	data, err := encodeRecord(match)
+	if err != nil {
+		return fmt.Errorf("failed to marshal match: %w", err)
+	}
+
+	key := s.keys.match(match.RoomID)
+	if err := s.client.Set(ctx, key, data, matchTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save match: %w", err)
+	}
+	return nil
+}
+
+// GetMatch retrieves a finished game's record
+func (s *RedisStore) GetMatch(ctx context.Context, roomID string) (*MatchRecord, error) {
+	key := s.keys.match(roomID)
+	data, err := s.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get match: %w", err)
+	}
+
+	var match MatchRecord
+	if err := decodeRecord(recordMatch, data, &match); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal match: %w", err)
+	}
+	return &match, nil
+}
+
+// clone copies room data down to its slices; nil stays nil
+func (r *RoomData) clone() *RoomData {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Players = append([]PlayerData(nil), r.Players...)
+	c.State.Plates = append([]PlateData(nil), r.State.Plates...)
+	c.State.SelectedPlates = append([]int(nil), r.State.SelectedPlates...)
+	c.State.MatchedPlates = append([]int(nil), r.State.MatchedPlates...)
+	return &c
+}
+
+// codeEntry is an invite code reservation held by a room
+type codeEntry struct {
+	roomID    string
+	expiresAt time.Time
+}
+
+func (e codeEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// memoryEntry is a stored value with the TTL RedisStore gives its key
+type memoryEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func (e memoryEntry[T]) expired(now time.Time) bool {
+	return !now.Before(e.expiresAt)
+}
+
+// MemoryStore implements Store using in-memory maps (for testing/simple
+// deployments). Rooms, sessions and matches expire after the same TTLs as
+// in Redis: reads skip expired entries and RunJanitor drops them.
 type MemoryStore struct {
 	mu       sync.RWMutex
-	rooms    map[string]*RoomData
-	codes    map[string]string // code -> roomID
-	sessions map[string]*SessionData
+	rooms    map[string]memoryEntry[*RoomData]
+	codes    map[string]codeEntry // code -> reservation
+	sessions map[string]memoryEntry[SessionData]
+	matches  map[string]memoryEntry[*MatchRecord]
+	clock    clock.Clock
 }
 
-// NewMemoryStore creates a new in-memory store
+// NewMemoryStore creates a new in-memory store on the wall clock
 func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(nil)
+}
+
+// NewMemoryStoreWithClock creates a new in-memory store whose entries expire
+// on clk. clk may be nil, in which case the wall clock is used.
+func NewMemoryStoreWithClock(clk clock.Clock) *MemoryStore {
 	return &MemoryStore{
-		rooms:    make(map[string]*RoomData),
-		codes:    make(map[string]string),
-		sessions: make(map[string]*SessionData),
+		rooms:    make(map[string]memoryEntry[*RoomData]),
+		codes:    make(map[string]codeEntry),
+		sessions: make(map[string]memoryEntry[SessionData]),
+		matches:  make(map[string]memoryEntry[*MatchRecord]),
+		clock:    clock.OrReal(clk),
+	}
+}
+
+// RunJanitor drops expired entries every interval until ctx is done
+func (s *MemoryStore) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s.dropExpired()
+		}
 	}
 }
 
+// dropExpired removes every expired entry
+func (s *MemoryStore) dropExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for id, entry := range s.rooms {
+		if entry.expired(now) {
+			delete(s.rooms, id)
+		}
+	}
+	for code, entry := range s.codes {
+		if entry.expired(now) {
+			delete(s.codes, code)
+		}
+	}
+	for id, entry := range s.sessions {
+		if entry.expired(now) {
+			delete(s.sessions, id)
+		}
+	}
+	for id, entry := range s.matches {
+		if entry.expired(now) {
+			delete(s.matches, id)
+		}
+	}
+}
+
+// size counts the stored entries, expired or not
+func (s *MemoryStore) size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rooms) + len(s.codes) + len(s.sessions) + len(s.matches)
+}
+
+// room returns a live stored room; the caller holds s.mu
+func (s *MemoryStore) room(roomID string, now time.Time) *RoomData {
+	if entry, ok := s.rooms[roomID]; ok && !entry.expired(now) {
+		return entry.value
+	}
+	return nil
+}
+
 func (s *MemoryStore) SaveRoom(ctx context.Context, room *RoomData) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.rooms[room.ID] = room
-	s.codes[room.Code] = room.ID
+	now := s.clock.Now()
+	var version int64
+	if current := s.room(room.ID, now); current != nil {
+		version = current.Version
+	}
+	if version != room.Version {
+		return ErrVersionConflict
+	}
+	claim := false
+	if room.Code != "" {
+		entry, ok := s.codes[room.Code]
+		held := ok && !entry.expired(now)
+		if held && entry.roomID != room.ID {
+			return ErrCodeTaken
+		}
+		claim = !held
+	}
+
+	// Stored rooms are copies, so callers never change them in place
+	room.Version++
+	s.rooms[room.ID] = memoryEntry[*RoomData]{value: room.clone(), expiresAt: now.Add(roomTTL)}
+	if claim {
+		s.codes[room.Code] = codeEntry{roomID: room.ID, expiresAt: now.Add(roomTTL)}
+	}
 	return nil
 }
 
+// adoptRoom stores a copy of room as is unless a live room with its ID is
+// stored, so a save at room.Version goes through. A HealthStore's overlay
+// takes rooms saved before the outage this way.
+func (s *MemoryStore) adoptRoom(room *RoomData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if s.room(room.ID, now) == nil {
+		s.rooms[room.ID] = memoryEntry[*RoomData]{value: room.clone(), expiresAt: now.Add(roomTTL)}
+	}
+}
+
 func (s *MemoryStore) GetRoom(ctx context.Context, roomID string) (*RoomData, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.rooms[roomID], nil
+	return s.room(roomID, s.clock.Now()).clone(), nil
 }
 
 func (s *MemoryStore) DeleteRoom(ctx context.Context, roomID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if room, ok := s.rooms[roomID]; ok {
-		delete(s.codes, room.Code)
-		delete(s.rooms, roomID)
+	if room := s.room(roomID, s.clock.Now()); room != nil {
+		if entry, ok := s.codes[room.Code]; ok && entry.roomID == roomID {
+			delete(s.codes, room.Code)
+		}
 	}
+	delete(s.rooms, roomID)
 	return nil
 }
 
@@ -277,19 +676,56 @@ func (s *MemoryStore) GetRoomByCode(ctx context.Context, code string) (*RoomData
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if roomID, ok := s.codes[code]; ok {
-		return s.rooms[roomID], nil
+	now := s.clock.Now()
+	if entry, ok := s.codes[code]; ok && !entry.expired(now) {
+		return s.room(entry.roomID, now).clone(), nil
 	}
 	return nil, nil
 }
 
+func (s *MemoryStore) ReserveCode(ctx context.Context, code, roomID string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if entry, ok := s.codes[code]; ok && !entry.expired(now) {
+		return false, nil
+	}
+	s.codes[code] = codeEntry{roomID: roomID, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) RefreshCode(ctx context.Context, code, roomID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if entry, ok := s.codes[code]; ok && entry.roomID == roomID && !entry.expired(now) {
+		s.codes[code] = codeEntry{roomID: roomID, expiresAt: now.Add(ttl)}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReleaseCode(ctx context.Context, code, roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.codes[code]; ok && entry.roomID == roomID {
+		delete(s.codes, code)
+	}
+	return nil
+}
+
 func (s *MemoryStore) SaveSession(ctx context.Context, sessionID, roomID string, playerIndex int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.sessions[sessionID] = &SessionData{
-		RoomID:      roomID,
-		PlayerIndex: playerIndex,
+	s.sessions[sessionID] = memoryEntry[SessionData]{
+		value: SessionData{
+			RoomID:      roomID,
+			PlayerIndex: playerIndex,
+		},
+		expiresAt: s.clock.Now().Add(sessionTTL),
 	}
 	return nil
 }
@@ -298,8 +734,8 @@ func (s *MemoryStore) GetSession(ctx context.Context, sessionID string) (string,
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if session, ok := s.sessions[sessionID]; ok {
-		return session.RoomID, session.PlayerIndex, nil
+	if entry, ok := s.sessions[sessionID]; ok && !entry.expired(s.clock.Now()) {
+		return entry.value.RoomID, entry.value.PlayerIndex, nil
 	}
 	return "", -1, nil
 }
@@ -311,3 +747,21 @@ func (s *MemoryStore) DeleteSession(ctx context.Context, sessionID string) error
 	delete(s.sessions, sessionID)
 	return nil
 }
+
+func (s *MemoryStore) SaveMatch(ctx context.Context, match *MatchRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.matches[match.RoomID] = memoryEntry[*MatchRecord]{value: match, expiresAt: s.clock.Now().Add(matchTTL)}
+	return nil
+}
+
+func (s *MemoryStore) GetMatch(ctx context.Context, roomID string) (*MatchRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry, ok := s.matches[roomID]; ok && !entry.expired(s.clock.Now()) {
+		return entry.value, nil
+	}
+	return nil, nil
+}
@@ -3,9 +3,12 @@ package store
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
 	"testing"
 	"time"
+
+	"memory-feast-online/internal/clock"
 )
 
 func TestMemoryStoreConcurrentAccess(t *testing.T) {
@@ -78,3 +81,130 @@ func TestMemoryStoreConcurrentAccess(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestMemoryStoreReserveCode(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	ok, err := store.ReserveCode(ctx, "ABCDEF", "room-1", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected first reservation to succeed, got ok=%v err=%v", ok, err)
+	}
+
+	ok, err = store.ReserveCode(ctx, "ABCDEF", "room-2", time.Minute)
+	if err != nil || ok {
+		t.Fatalf("expected competing reservation to fail, got ok=%v err=%v", ok, err)
+	}
+
+	if err := store.ReleaseCode(ctx, "ABCDEF", "room-2"); err != nil {
+		t.Fatalf("ReleaseCode failed: %v", err)
+	}
+	ok, _ = store.ReserveCode(ctx, "ABCDEF", "room-2", time.Minute)
+	if ok {
+		t.Fatalf("expected release by non-owner to keep reservation")
+	}
+
+	if err := store.ReleaseCode(ctx, "ABCDEF", "room-1"); err != nil {
+		t.Fatalf("ReleaseCode failed: %v", err)
+	}
+	ok, _ = store.ReserveCode(ctx, "ABCDEF", "room-2", time.Minute)
+	if !ok {
+		t.Fatalf("expected reservation after owner release to succeed")
+	}
+}
+
+func TestMemoryStoreReservedCodeExpires(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if ok, _ := store.ReserveCode(ctx, "ABCDEF", "room-1", time.Millisecond); !ok {
+		t.Fatalf("expected reservation to succeed")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	ok, err := store.ReserveCode(ctx, "ABCDEF", "room-2", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected expired code to be reservable, got ok=%v err=%v", ok, err)
+	}
+}
+
+// TestRedisStoreSaveRoomKeepsCodeTTL runs against the server at
+// REDIS_TEST_ADDR
+func TestRedisStoreSaveRoomKeepsCodeTTL(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	store, err := NewRedisStore(RedisOptions{Addrs: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	roomID, code := "room-"+uniqueSuffix(), uniqueCode("T")
+	if ok, err := store.ReserveCode(ctx, code, roomID, time.Minute); err != nil || !ok {
+		t.Fatalf("expected the code to be reserved, got ok=%v err=%v", ok, err)
+	}
+	if err := store.SaveRoom(ctx, &RoomData{ID: roomID, Code: code}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.DeleteRoom(ctx, roomID) })
+
+	ttl, err := store.Client().PTTL(ctx, store.keys.code(code)).Result()
+	if err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected the reservation's TTL of at most a minute, got %v %v", ttl, err)
+	}
+}
+
+func TestMemoryStoreJanitorDropsExpiredEntries(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	store := NewMemoryStoreWithClock(fake)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		store.RunJanitor(ctx, JanitorInterval)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return fake.Pending() == 1 })
+
+	store.SaveRoom(ctx, &RoomData{ID: "room-1", Code: "ABCDEF"})
+	store.SaveSession(ctx, "session-1", "room-1", 0)
+	store.SaveMatch(ctx, &MatchRecord{RoomID: "room-1"})
+	store.SaveSession(ctx, "session-2", "room-1", 1)
+
+	fake.Advance(sessionTTL)
+	waitFor(t, func() bool {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		return len(store.sessions) == 0 && len(store.rooms) == 1
+	})
+
+	fake.Advance(roomTTL)
+	waitFor(t, func() bool {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		return len(store.rooms) == 0 && len(store.codes) == 0 && len(store.matches) == 1
+	})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the janitor to stop once its context is done")
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the layout of the stored records this build writes.
+// Bump it with a migration for every record kind whenever a stored field is
+// added, renamed or reinterpreted.
+const SchemaVersion = 1
+
+// ErrNewerSchema means a record was written by a newer release than this
+// one; it is left untouched rather than misread
+const ErrNewerSchema StoreError = "record written by a newer schema"
+
+// Kinds of stored records, each with its own migrations
+const (
+	recordRoom    = "room"    // RoomData with its PlayerData and StateData
+	recordSession = "session" // SessionData
+	recordMatch   = "match"   // MatchRecord
+)
+
+// schemaField is the field stamped on every stored record
+const schemaField = "schema"
+
+// migration upgrades the top-level fields of a record by one schema version
+// in place
+type migration func(fields map[string]json.RawMessage) error
+
+// migrations holds, per record kind, the migration from each schema version
+// to the next. Schema 0 is a record written before records were versioned.
+var migrations = map[string]map[int]migration{
+	recordRoom:    {0: unversioned},
+	recordSession: {0: unversioned},
+	recordMatch:   {0: unversioned},
+}
+
+// unversioned upgrades a record from before schema versions, which already
+// has the schema 1 layout
+func unversioned(map[string]json.RawMessage) error {
+	return nil
+}
+
+// encodeRecord marshals v, a struct, as a JSON object stamped with
+// SchemaVersion
+func encodeRecord(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("stored record %T is not a JSON object", v)
+	}
+
+	stamp := fmt.Sprintf(`{"%s":%d`, schemaField, SchemaVersion)
+	if data[1] != '}' {
+		stamp += ","
+	}
+	return append([]byte(stamp), data[1:]...), nil
+}
+
+// decodeRecord unmarshals a stored record of kind into v, migrating it up to
+// SchemaVersion first. A record from a newer schema returns ErrNewerSchema.
+func decodeRecord(kind string, data []byte, v any) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	schema := 0
+	if raw, ok := fields[schemaField]; ok {
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			return fmt.Errorf("invalid %s schema %s: %w", kind, raw, err)
+		}
+	}
+	if schema > SchemaVersion {
+		return fmt.Errorf("%w: %s at schema %d, this build reads up to %d", ErrNewerSchema, kind, schema, SchemaVersion)
+	}
+	if schema == SchemaVersion {
+		return json.Unmarshal(data, v)
+	}
+
+	for ; schema < SchemaVersion; schema++ {
+		migrate, ok := migrations[kind][schema]
+		if !ok {
+			return fmt.Errorf("no migration for %s from schema %d", kind, schema)
+		}
+		if err := migrate(fields); err != nil {
+			return fmt.Errorf("failed to migrate %s from schema %d: %w", kind, schema, err)
+		}
+	}
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(migrated, v)
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestEncodeRecordStampsSchema(t *testing.T) {
+	data, err := encodeRecord(&SessionData{RoomID: "room-1", PlayerIndex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`{"schema":%d,"roomId":"room-1","playerIndex":1}`, SchemaVersion)
+	if string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+
+	var session SessionData
+	if err := decodeRecord(recordSession, data, &session); err != nil || session.RoomID != "room-1" || session.PlayerIndex != 1 {
+		t.Fatalf("expected the record to round-trip, got %+v %v", session, err)
+	}
+}
+
+func TestDecodeRecordMigratesOldSchemas(t *testing.T) {
+	// Unversioned rooms are read as they are
+	var room RoomData
+	legacy := `{"id":"room-1","code":"ABC123","plateCount":20,"players":[{"id":"p1","nickname":"a","tokens":3}]}`
+	if err := decodeRecord(recordRoom, []byte(legacy), &room); err != nil {
+		t.Fatal(err)
+	}
+	if room.ID != "room-1" || room.PlateCount != 20 || len(room.Players) != 1 || room.Players[0].Tokens != 3 {
+		t.Fatalf("expected the unversioned room to be read, got %+v", room)
+	}
+
+	// A registered migration rewrites the fields before they are read
+	original := migrations[recordRoom][0]
+	t.Cleanup(func() { migrations[recordRoom][0] = original })
+	migrations[recordRoom][0] = func(fields map[string]json.RawMessage) error {
+		if _, ok := fields["plateCount"]; !ok {
+			fields["plateCount"] = json.RawMessage("30")
+		}
+		return nil
+	}
+
+	room = RoomData{}
+	if err := decodeRecord(recordRoom, []byte(`{"id":"room-2"}`), &room); err != nil {
+		t.Fatal(err)
+	}
+	if room.PlateCount != 30 {
+		t.Fatalf("expected the migration to fill in the plate count, got %d", room.PlateCount)
+	}
+
+	// Current records skip migrations
+	room = RoomData{}
+	current := fmt.Sprintf(`{"schema":%d,"id":"room-3"}`, SchemaVersion)
+	if err := decodeRecord(recordRoom, []byte(current), &room); err != nil || room.PlateCount != 0 {
+		t.Fatalf("expected a current room to be read unmigrated, got %+v %v", room, err)
+	}
+}
+
+func TestDecodeRecordRefusesNewerSchema(t *testing.T) {
+	var match MatchRecord
+	newer := fmt.Sprintf(`{"schema":%d,"roomId":"room-1"}`, SchemaVersion+1)
+	err := decodeRecord(recordMatch, []byte(newer), &match)
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+	if match.RoomID != "" {
+		t.Fatal("expected the newer record to be left unread")
+	}
+}
+
+// TestRedisStoreReadsVersionedRecords runs against the server at
+// REDIS_TEST_ADDR
+func TestRedisStoreReadsVersionedRecords(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+
+	store, err := NewRedisStore(RedisOptions{Addrs: []string{addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	client := store.Client()
+
+	legacyID := "legacy-" + uniqueSuffix()
+	client.Set(ctx, roomKeyPrefix+legacyID, `{"id":"`+legacyID+`","version":2,"plateCount":20}`, roomTTL)
+	t.Cleanup(func() { client.Del(ctx, roomKeyPrefix+legacyID) })
+	room, err := store.GetRoom(ctx, legacyID)
+	if err != nil || room == nil || room.Version != 2 {
+		t.Fatalf("expected the unversioned room to be read, got %+v %v", room, err)
+	}
+	if err := store.SaveRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := client.Get(ctx, roomKeyPrefix+legacyID).Result()
+	var stamped struct {
+		Schema int `json:"schema"`
+	}
+	if json.Unmarshal([]byte(raw), &stamped); stamped.Schema != SchemaVersion {
+		t.Fatalf("expected a saved room to be stamped with schema %d, got %s", SchemaVersion, raw)
+	}
+
+	newerID := "newer-" + uniqueSuffix()
+	client.Set(ctx, roomKeyPrefix+newerID, fmt.Sprintf(`{"schema":%d,"id":"%s","version":1}`, SchemaVersion+1, newerID), roomTTL)
+	t.Cleanup(func() { client.Del(ctx, roomKeyPrefix+newerID) })
+	if _, err := store.GetRoom(ctx, newerID); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema reading a newer room, got %v", err)
+	}
+	err = store.SaveRoom(ctx, &RoomData{ID: newerID, Version: 1})
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected a newer room not to be overwritten, got %v", err)
+	}
+	if raw, _ := client.Get(ctx, roomKeyPrefix+newerID).Result(); raw == "" {
+		t.Fatal("expected the newer room to be kept")
+	}
+}
@@ -1,7 +1,9 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
+	"hash/fnv"
 	"log"
 	"sync"
 	"time"
@@ -9,132 +11,242 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// Hub maintains the set of active clients and manages rooms
+// hubShards is the number of independently locked client and topic shards
+const hubShards = 32
+
+// Hub maintains the set of active clients and their topic memberships.
+// Clients and topics are sharded by a hash of the session ID or topic name,
+// so registrations and fan-out on different shards never contend.
 type Hub struct {
-	// Registered clients by session ID
-	clients map[string]*Client
+	shards [hubShards]hubShard
 
-	// Mutex for clients map
+	// Guards the settings below and the stopped flag
 	mu sync.RWMutex
 
-	// Register requests from clients
-	register chan *Client
+	// Send queue settings applied to new clients
+	sendQueueSize int
+	slowConsumer  SlowConsumerPolicy
+
+	// What to do when a session connects twice, see takeover.go
+	takeover TakeoverPolicy
+
+	// Client state transitions and their hooks, see state.go
+	states *StateMachine
 
-	// Unregister requests from clients
-	unregister chan *Client
+	// Set once Run's context is done; later registrations are refused
+	stopped bool
+}
+
+type hubShard struct {
+	mu      sync.RWMutex
+	clients map[string]*Client             // session ID -> client
+	topics  map[string]map[string]struct{} // topic -> member session IDs
 }
 
 // NewHub creates a new Hub instance
 func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[string]*Client),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+	h := &Hub{
+		sendQueueSize: DefaultSendQueueSize,
+		slowConsumer:  SlowConsumerCoalesce,
+		takeover:      TakeoverReplace,
+		states:        NewStateMachine(),
+	}
+	for i := range h.shards {
+		h.shards[i].clients = make(map[string]*Client)
+		h.shards[i].topics = make(map[string]map[string]struct{})
 	}
+	return h
 }
 
-// Run starts the hub's event loop
-func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			// Check for existing client with same session
-			if existing, ok := h.clients[client.SessionID]; ok {
-				// Close old connection
-				existing.Close()
-			}
-			h.clients[client.SessionID] = client
-			h.mu.Unlock()
-			log.Printf("Client registered: %s", client.SessionID)
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if existing, ok := h.clients[client.SessionID]; ok {
-				// Only unregister if it's the same client instance
-				if existing == client {
-					delete(h.clients, client.SessionID)
-					log.Printf("Client unregistered: %s", client.SessionID)
-				}
-			}
-			h.mu.Unlock()
-		}
-	}
+// States returns the state machine validating the hub's client state changes
+func (h *Hub) States() *StateMachine {
+	return h.states
+}
+
+// shardFor returns the shard owning a session ID or topic name
+func (h *Hub) shardFor(key string) *hubShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &h.shards[hash.Sum32()%hubShards]
+}
+
+// SetSendQueue configures the send queue size and slow-consumer policy for
+// clients created afterwards
+func (h *Hub) SetSendQueue(size int, policy SlowConsumerPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendQueueSize = size
+	h.slowConsumer = policy
 }
 
-// Register adds a client to the hub
-func (h *Hub) Register(client *Client) {
-	h.register <- client
+// Run blocks until ctx is done, then stops the hub: every client is closed
+// and later registrations are refused. Registration itself never waits on
+// Run, so a stalled or stopped hub cannot block ReadPump's cleanup.
+func (h *Hub) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	h.mu.Lock()
+	h.stopped = true
+	h.mu.Unlock()
+
+	closed := 0
+	for i := range h.shards {
+		shard := &h.shards[i]
+		shard.mu.Lock()
+		clients := shard.clients
+		shard.clients = make(map[string]*Client)
+		shard.mu.Unlock()
+
+		for _, client := range clients {
+			client.Close()
+			closed++
+		}
+	}
+	log.Printf("Hub stopped, closed %d clients", closed)
 }
 
-// Unregister removes a client from the hub
+// Unregister removes a client from the hub. A newer client registered under
+// the same session is left alone.
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	shard := h.shardFor(client.SessionID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// Only unregister if it's the same client instance
+	if shard.clients[client.SessionID] == client {
+		delete(shard.clients, client.SessionID)
+		log.Printf("Client unregistered: %s", client.SessionID)
+	}
 }
 
 // GetClient returns a client by session ID
 func (h *Hub) GetClient(sessionID string) *Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.clients[sessionID]
+	shard := h.shardFor(sessionID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.clients[sessionID]
 }
 
 // ClientCount returns the number of connected clients
 func (h *Hub) ClientCount() int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return len(h.clients)
+	count := 0
+	for i := range h.shards {
+		shard := &h.shards[i]
+		shard.mu.RLock()
+		count += len(shard.clients)
+		shard.mu.RUnlock()
+	}
+	return count
 }
 
-// ClientState represents the state of a WebSocket client
-type ClientState string
+// Join adds a session to a topic. Membership follows the session, so a
+// reconnecting client keeps receiving the topic without joining again.
+func (h *Hub) Join(topic, sessionID string) {
+	shard := h.shardFor(topic)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	members, ok := shard.topics[topic]
+	if !ok {
+		members = make(map[string]struct{})
+		shard.topics[topic] = members
+	}
+	members[sessionID] = struct{}{}
+}
 
-const (
-	ClientLobby   ClientState = "lobby"
-	ClientWaiting ClientState = "waiting" // In queue or waiting room
-	ClientInGame  ClientState = "in_game"
-)
+// Leave removes a session from a topic
+func (h *Hub) Leave(topic, sessionID string) {
+	shard := h.shardFor(topic)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if members, ok := shard.topics[topic]; ok {
+		delete(members, sessionID)
+		if len(members) == 0 {
+			delete(shard.topics, topic)
+		}
+	}
+}
+
+// DropTopic removes a topic and all its memberships
+func (h *Hub) DropTopic(topic string) {
+	shard := h.shardFor(topic)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.topics, topic)
+}
+
+// Members returns the session IDs subscribed to a topic
+func (h *Hub) Members(topic string) []string {
+	shard := h.shardFor(topic)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	members := make([]string, 0, len(shard.topics[topic]))
+	for sessionID := range shard.topics[topic] {
+		members = append(members, sessionID)
+	}
+	return members
+}
+
+// Publish queues a frame to every connected member of a topic, returning
+// how many clients it was queued for
+func (h *Hub) Publish(topic string, kind FrameKind, data []byte) int {
+	sent := 0
+	for _, sessionID := range h.Members(topic) {
+		client := h.GetClient(sessionID)
+		if client == nil {
+			continue
+		}
+		if err := client.Enqueue(kind, data); err != nil {
+			log.Printf("Error publishing %s to client %s: %v", topic, sessionID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
 
 // Client represents a connected WebSocket client
 type Client struct {
 	Hub          *Hub
 	Conn         *websocket.Conn
 	SessionID    string
-	Send         chan []byte
 	State        ClientState
+	Remote       string // Instance holding the socket of a relayed session, see relay.go
 	onDisconnect func(*Client)
 
-	closeMu sync.Mutex
-	writeMu sync.Mutex // Mutex for serializing writes
-	stateMu sync.RWMutex
-	closed  bool
+	outbox       *outbox       // Bounded send queue, drained by WritePump
+	limiter      tokenBucket   // Inbound message allowance, see RateLimit
+	done         chan struct{} // Closed by Close
+	finish       chan struct{} // Closed by CloseWith
+	closeMu      sync.Mutex
+	stateMu      sync.RWMutex
+	transitionMu sync.Mutex // Serializes Transition, see state.go
+	closed       bool
+	finishing    bool
 }
 
-// NewClient creates a new client
+// NewClient creates a new client. Its send queue follows the hub's settings.
 func NewClient(hub *Hub, conn *websocket.Conn, sessionID string) *Client {
+	size, policy := DefaultSendQueueSize, SlowConsumerCoalesce
+	if hub != nil {
+		hub.mu.RLock()
+		size, policy = hub.sendQueueSize, hub.slowConsumer
+		hub.mu.RUnlock()
+	}
+
 	return &Client{
 		Hub:       hub,
 		Conn:      conn,
 		SessionID: sessionID,
-		Send:      make(chan []byte, 256),
 		State:     ClientLobby, // Start in lobby state
+		outbox:    newOutbox(size, policy),
+		done:      make(chan struct{}),
+		finish:    make(chan struct{}),
 	}
 }
 
-// SetState sets the client's state (thread-safe)
-func (c *Client) SetState(state ClientState) {
-	c.stateMu.Lock()
-	defer c.stateMu.Unlock()
-	if c.State != state {
-		sessionPrefix := c.SessionID
-		if len(sessionPrefix) > 8 {
-			sessionPrefix = sessionPrefix[:8]
-		}
-		log.Printf("Client %s: state %s → %s", sessionPrefix, c.State, state)
-	}
-	c.State = state
-}
-
 // GetState returns the client's current state (thread-safe)
 func (c *Client) GetState() ClientState {
 	c.stateMu.RLock()
@@ -142,7 +254,8 @@ func (c *Client) GetState() ClientState {
 	return c.State
 }
 
-// Close closes the client connection
+// Close closes the client connection. It does not wait for a write in
+// progress, so it is safe to call from a room's event loop.
 func (c *Client) Close() {
 	c.closeMu.Lock()
 	defer c.closeMu.Unlock()
@@ -151,14 +264,36 @@ func (c *Client) Close() {
 		return
 	}
 	c.closed = true
-	close(c.Send)
-	if c.Conn != nil {
-		c.writeMu.Lock()
+	close(c.done)
+	if c.Conn != nil && c.Remote == "" {
 		c.Conn.Close()
-		c.writeMu.Unlock()
 	}
 }
 
+// CloseWith queues a final message and closes the connection once the queue
+// has been written, or after writeWait at the latest
+func (c *Client) CloseWith(msg *Message) {
+	if msg != nil {
+		c.SendMessage(msg)
+	}
+
+	c.closeMu.Lock()
+	if c.closed || c.finishing {
+		c.closeMu.Unlock()
+		return
+	}
+	c.finishing = true
+	close(c.finish)
+	hasPump := c.Conn != nil
+	c.closeMu.Unlock()
+
+	if !hasPump {
+		c.Close()
+		return
+	}
+	time.AfterFunc(writeWait, c.Close)
+}
+
 // SetOnDisconnect sets callback invoked when ReadPump exits.
 func (c *Client) SetOnDisconnect(callback func(*Client)) {
 	c.closeMu.Lock()
@@ -180,8 +315,8 @@ func (c *Client) IsClosed() bool {
 	return c.closed
 }
 
-// WritePump pumps messages from the Send channel to the websocket connection
-// All writes are serialized via writeMu
+// WritePump drains the send queue to the websocket connection. It is the
+// only goroutine writing to the connection.
 func (c *Client) WritePump() {
 	if c.Conn == nil {
 		return
@@ -195,69 +330,70 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.writeMu.Lock()
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				c.writeMu.Unlock()
-				return
-			}
-			err := c.Conn.WriteMessage(websocket.TextMessage, message)
-			c.writeMu.Unlock()
-			if err != nil {
-				log.Printf("Error writing to client %s: %v", c.SessionID, err)
-				return
+		case <-c.outbox.ready:
+			for message, ok := c.outbox.pop(); ok; message, ok = c.outbox.pop() {
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					log.Printf("Error writing to client %s: %v", c.SessionID, err)
+					return
+				}
 			}
 		case <-ticker.C:
-			c.writeMu.Lock()
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			err := c.Conn.WriteMessage(websocket.PingMessage, nil)
-			c.writeMu.Unlock()
-			if err != nil {
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-c.finish:
+			for message, ok := c.outbox.pop(); ok; message, ok = c.outbox.pop() {
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					return
+				}
+			}
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case <-c.done:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 }
 
-// WriteMessageDirect writes a message directly with mutex protection
-// Used by game room broadcasts to avoid channel overhead
-func (c *Client) WriteMessageDirect(messageType int, data []byte) error {
-	c.closeMu.Lock()
-	if c.closed || c.Conn == nil {
-		c.closeMu.Unlock()
+// Enqueue queues an already serialized frame without blocking. When the
+// queue is full the slow-consumer policy applies; a client that cannot keep
+// up is disconnected and ErrSlowConsumer returned.
+func (c *Client) Enqueue(kind FrameKind, data []byte) error {
+	if c.IsClosed() {
 		return nil
 	}
 
-	c.writeMu.Lock()
-	conn := c.Conn
-	c.closeMu.Unlock()
-	defer c.writeMu.Unlock()
-	conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return conn.WriteMessage(messageType, data)
+	if err := c.outbox.push(frame{kind: kind, data: data}); err != nil {
+		log.Printf("Disconnecting slow client %s: %v", c.SessionID, err)
+		c.Close()
+		return err
+	}
+	return nil
 }
 
-// SendMessage sends a message to this client
+// SendMessage queues a control message to this client
 func (c *Client) SendMessage(msg *Message) error {
 	bytes, err := marshalMessage(msg)
 	if err != nil {
 		return err
 	}
+	return c.Enqueue(FrameControl, bytes)
+}
 
-	c.closeMu.Lock()
-	defer c.closeMu.Unlock()
-
-	if c.closed {
-		return nil
-	}
+// Ready is signalled whenever frames are queued
+func (c *Client) Ready() <-chan struct{} {
+	return c.outbox.ready
+}
 
-	select {
-	case c.Send <- bytes:
-		return nil
-	default:
-		return ErrChannelFull
-	}
+// Next removes and returns the oldest queued frame, if any
+func (c *Client) Next() ([]byte, bool) {
+	return c.outbox.pop()
 }
 
 // Error types
@@ -266,7 +402,12 @@ type HubError string
 func (e HubError) Error() string { return string(e) }
 
 const (
-	ErrChannelFull HubError = "send channel full"
+	ErrSlowConsumer      HubError = "send queue full"
+	ErrHubStopped        HubError = "hub stopped"
+	ErrSessionInUse      HubError = "session in use by another connection"
+	ErrTakeoverPending   HubError = "session in use, takeover offered"
+	ErrIllegalTransition HubError = "illegal client state transition"
+	ErrStateChanged      HubError = "client state changed"
 )
 
 // marshalMessage marshals a message to JSON bytes
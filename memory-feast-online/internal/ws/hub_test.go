@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStopsHubAndClosesClients(t *testing.T) {
+	hub := NewHub()
+	client := NewClient(hub, nil, "s1")
+	hub.Register(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hub.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once the context is done")
+	}
+	if !client.IsClosed() {
+		t.Fatal("expected registered client to be closed on shutdown")
+	}
+
+	late := NewClient(hub, nil, "s2")
+	hub.Register(late)
+	if !late.IsClosed() || hub.ClientCount() != 0 {
+		t.Fatal("expected a stopped hub to refuse registrations")
+	}
+}
+
+func TestUnregisterKeepsNewerClient(t *testing.T) {
+	hub := NewHub()
+	old := NewClient(hub, nil, "s1")
+	hub.Register(old)
+	newer := NewClient(hub, nil, "s1")
+	hub.Register(newer)
+
+	hub.Unregister(old)
+	if hub.GetClient("s1") != newer {
+		t.Fatal("expected stale unregister to leave the newer client registered")
+	}
+	if !old.IsClosed() {
+		t.Fatal("expected replaced client to be closed")
+	}
+}
+
+func TestPublishFollowsSessionAcrossReconnect(t *testing.T) {
+	hub := NewHub()
+	hub.Join("room:1", "s1")
+	hub.Join("room:1", "s2")
+	hub.Register(NewClient(hub, nil, "s1"))
+
+	if sent := hub.Publish("room:1", FrameControl, []byte("a")); sent != 1 {
+		t.Fatalf("expected only the connected member to receive, got %d", sent)
+	}
+
+	reconnected := NewClient(hub, nil, "s2")
+	hub.Register(reconnected)
+	hub.Leave("room:1", "s1")
+	if sent := hub.Publish("room:1", FrameControl, []byte("b")); sent != 1 {
+		t.Fatalf("expected one member after leave, got %d", sent)
+	}
+	if data, ok := reconnected.Next(); !ok || string(data) != "b" {
+		t.Fatalf("expected reconnected member to receive the frame, got %q", data)
+	}
+
+	hub.DropTopic("room:1")
+	if members := hub.Members("room:1"); len(members) != 0 {
+		t.Fatalf("expected dropped topic to have no members, got %v", members)
+	}
+}
+
+func TestRegisterRejectKeepsLiveClient(t *testing.T) {
+	hub := NewHub()
+	hub.SetTakeoverPolicy(TakeoverReject)
+	live := NewClient(hub, nil, "s1")
+	hub.Register(live)
+
+	second := NewClient(hub, nil, "s1")
+	if replaced, err := hub.Register(second); replaced || err != ErrSessionInUse {
+		t.Fatalf("expected ErrSessionInUse, got replaced=%v err=%v", replaced, err)
+	}
+	if hub.GetClient("s1") != live || live.IsClosed() {
+		t.Fatal("expected live client to keep the session")
+	}
+	if !second.IsClosed() {
+		t.Fatal("expected rejected client to be closed")
+	}
+	if data, ok := second.Next(); !ok || !strings.Contains(string(data), `"session_conflict"`) {
+		t.Fatalf("expected session_conflict before close, got %q", data)
+	}
+}
+
+func TestRelayPumpForwardsQueueUntilFinished(t *testing.T) {
+	hub := NewHub()
+	client := NewRemoteClient(hub, "s1", "other")
+	hub.Register(client)
+
+	var frames []string
+	done := make(chan error, 1)
+	go func() {
+		done <- client.RelayPump(func(kind FrameKind, data []byte) error {
+			frames = append(frames, string(data))
+			return nil
+		})
+	}()
+
+	client.Enqueue(FrameControl, []byte("first"))
+	client.Enqueue(FrameState, []byte("second"))
+	client.CloseWith(&Message{Type: MsgError})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected pump to finish cleanly, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected RelayPump to return once the client finishes")
+	}
+	if len(frames) != 3 || frames[0] != "first" || frames[1] != "second" || !strings.Contains(frames[2], `"error"`) {
+		t.Fatalf("expected queued frames in order before the close, got %q", frames)
+	}
+	if !client.IsClosed() {
+		t.Fatal("expected remote client to be closed after its pump returns")
+	}
+}
@@ -7,27 +7,41 @@ type MessageType string
 
 const (
 	// Client -> Server messages
-	MsgJoinQueue    MessageType = "join_queue"
-	MsgCreateRoom   MessageType = "create_room"
-	MsgJoinRoom     MessageType = "join_room"
-	MsgPlaceToken   MessageType = "place_token"
-	MsgSelectPlate  MessageType = "select_plate"
-	MsgConfirmMatch MessageType = "confirm_match"
-	MsgAddToken     MessageType = "add_token"
-	MsgReconnect    MessageType = "reconnect"
-	MsgLeaveRoom    MessageType = "leave_room"
+	MsgJoinQueue       MessageType = "join_queue"
+	MsgCreateRoom      MessageType = "create_room"
+	MsgJoinRoom        MessageType = "join_room"
+	MsgPlaceToken      MessageType = "place_token"
+	MsgSelectPlate     MessageType = "select_plate"
+	MsgConfirmMatch    MessageType = "confirm_match"
+	MsgAddToken        MessageType = "add_token"
+	MsgReconnect       MessageType = "reconnect"
+	MsgLeaveRoom       MessageType = "leave_room"
+	MsgReady           MessageType = "ready"
+	MsgUpdateSettings  MessageType = "update_settings"
+	MsgKickPlayer      MessageType = "kick_player"
+	MsgRematchRequest  MessageType = "rematch_request"
+	MsgRematchResponse MessageType = "rematch_response"
+	MsgTakeover        MessageType = "takeover"
+	MsgSpectate        MessageType = "spectate"
 
 	// Server -> Client messages
-	MsgError        MessageType = "error"
-	MsgQueueJoined  MessageType = "queue_joined"
-	MsgQueueTimeout MessageType = "queue_timeout"
-	MsgMatched      MessageType = "matched"
-	MsgRoomCreated  MessageType = "room_created"
-	MsgRoomJoined   MessageType = "room_joined"
-	MsgGameState    MessageType = "game_state"
-	MsgGameEnd      MessageType = "game_end"
-	MsgPlayerLeft   MessageType = "player_left"
-	MsgReconnected  MessageType = "reconnected"
+	MsgError           MessageType = "error"
+	MsgQueueJoined     MessageType = "queue_joined"
+	MsgQueueTimeout    MessageType = "queue_timeout"
+	MsgMatched         MessageType = "matched"
+	MsgRoomCreated     MessageType = "room_created"
+	MsgRoomJoined      MessageType = "room_joined"
+	MsgGameState       MessageType = "game_state"
+	MsgGameEnd         MessageType = "game_end"
+	MsgPlayerLeft      MessageType = "player_left"
+	MsgReconnected     MessageType = "reconnected"
+	MsgLobbyState      MessageType = "lobby_state"
+	MsgRoomClosed      MessageType = "room_closed"
+	MsgRematchOffered  MessageType = "rematch_offered"
+	MsgRematchDeclined MessageType = "rematch_declined"
+	MsgSessionReplaced MessageType = "session_replaced"
+	MsgSessionConflict MessageType = "session_conflict"
+	MsgSpectating      MessageType = "spectating"
 )
 
 // Message is the base WebSocket message structure
@@ -38,32 +52,34 @@ type Message struct {
 
 // JoinQueuePayload for joining random matchmaking
 type JoinQueuePayload struct {
-	Nickname  string `json:"nickname"`
-	SessionID string `json:"sessionId"`
+	Nickname  string `json:"nickname" schema:"trim,minLength=1,maxLength=12"`
+	SessionID string `json:"sessionId,omitempty"`
 }
 
 // CreateRoomPayload for creating a room with invite code
 type CreateRoomPayload struct {
-	Nickname   string `json:"nickname"`
-	SessionID  string `json:"sessionId"`
-	PlateCount int    `json:"plateCount"`
+	Nickname    string `json:"nickname" schema:"trim,minLength=1,maxLength=12"`
+	SessionID   string `json:"sessionId,omitempty"`
+	PlateCount  int    `json:"plateCount,omitempty" schema:"minimum=0,maximum=20"` // 0 for the default
+	Ruleset     string `json:"ruleset,omitempty" schema:"enumOf=ruleset"`
+	FirstPlayer string `json:"firstPlayer,omitempty" schema:"enumOf=firstPlayerPolicy"`
 }
 
 // JoinRoomPayload for joining a room by code
 type JoinRoomPayload struct {
-	Nickname  string `json:"nickname"`
-	SessionID string `json:"sessionId"`
-	RoomCode  string `json:"roomCode"`
+	Nickname  string `json:"nickname" schema:"trim,minLength=1,maxLength=12"`
+	SessionID string `json:"sessionId,omitempty"`
+	RoomCode  string `json:"roomCode" schema:"trim,upper,pattern=^[A-HJ-NP-Z2-9]{6}$"`
 }
 
 // PlaceTokenPayload for placement phase action
 type PlaceTokenPayload struct {
-	Index int `json:"index"`
+	Index int `json:"index" schema:"minimum=0,maximum=19"`
 }
 
 // SelectPlatePayload for matching phase selection
 type SelectPlatePayload struct {
-	Index int `json:"index"`
+	Index int `json:"index" schema:"minimum=0,maximum=19"`
 }
 
 // ConfirmMatchPayload for confirming selected plates
@@ -71,18 +87,46 @@ type ConfirmMatchPayload struct{}
 
 // AddTokenPayload for adding token to matched plate
 type AddTokenPayload struct {
-	Index int `json:"index"`
+	Index int `json:"index" schema:"minimum=0,maximum=19"`
+}
+
+// ReadyPayload toggles the sender's ready flag in the pre-game lobby
+type ReadyPayload struct {
+	Ready bool `json:"ready"`
+}
+
+// UpdateSettingsPayload lets the room host change settings in the lobby.
+// Zero values keep the current setting.
+type UpdateSettingsPayload struct {
+	PlateCount  int    `json:"plateCount,omitempty" schema:"minimum=0,maximum=20"`
+	Ruleset     string `json:"ruleset,omitempty" schema:"enumOf=ruleset"`
+	FirstPlayer string `json:"firstPlayer,omitempty" schema:"enumOf=firstPlayerPolicy"`
+}
+
+// KickPlayerPayload lets the room host remove the joiner from the lobby
+type KickPlayerPayload struct{}
+
+// LeaveRoomPayload for leaving the queue, waiting room or game
+type LeaveRoomPayload struct{}
+
+// RematchRequestPayload asks the previous opponent for another game
+type RematchRequestPayload struct{}
+
+// RematchResponsePayload accepts or declines the opponent's rematch request
+type RematchResponsePayload struct {
+	Accept bool `json:"accept"`
 }
 
 // ReconnectPayload for reconnecting with session ID
 type ReconnectPayload struct {
-	SessionID string `json:"sessionId"`
+	SessionID string `json:"sessionId" schema:"minLength=1"`
 }
 
 // ErrorPayload for error messages
 type ErrorPayload struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"` // Payload fields that failed validation
 }
 
 // QueueJoinedPayload confirmation of queue join
@@ -109,13 +153,42 @@ type RoomCreatedPayload struct {
 	RoomCode string `json:"roomCode"`
 }
 
+// LobbyStatePayload describes an invite room before the game starts
+type LobbyStatePayload struct {
+	RoomID            string            `json:"roomId"`
+	RoomCode          string            `json:"roomCode"`
+	HostIndex         int               `json:"hostIndex"`
+	PlateCount        int               `json:"plateCount"`
+	Ruleset           string            `json:"ruleset"`
+	FirstPlayerPolicy string            `json:"firstPlayerPolicy"`
+	Players           []LobbyPlayerInfo `json:"players"`
+}
+
+// LobbyPlayerInfo for lobby state
+type LobbyPlayerInfo struct {
+	Nickname    string `json:"nickname"`
+	Seated      bool   `json:"seated"`
+	Ready       bool   `json:"ready"`
+	IsConnected bool   `json:"isConnected"`
+}
+
+// RoomClosedPayload when the lobby is closed or the player is removed from it
+type RoomClosedPayload struct {
+	Reason string `json:"reason"` // host_left, kicked
+}
+
 // GameStatePayload contains the full game state
 type GameStatePayload struct {
 	Phase                  string       `json:"phase"` // waiting, placement, matching, add_token, finished
 	CurrentTurn            int          `json:"currentTurn"`
 	PlacementRound         int          `json:"placementRound"`
 	MaxRound               int          `json:"maxRound"`
-	TimeLeft               int          `json:"timeLeft"`
+	TimeLeft               int          `json:"timeLeft"`               // Seconds left when the state was sent
+	TurnDeadline           int64        `json:"turnDeadline,omitempty"` // Unix ms when the matching turn times out, 0 while stopped
+	ServerTime             int64        `json:"serverTime"`             // Unix ms when the state was sent, to offset the client clock
+	Ruleset                string       `json:"ruleset,omitempty"`
+	FirstPlayer            int          `json:"firstPlayer"`                 // Player index who started the game
+	FirstPlayerPolicy      string       `json:"firstPlayerPolicy,omitempty"` // random, alternate
 	Players                []PlayerInfo `json:"players"`
 	Plates                 []PlateInfo  `json:"plates"`
 	SelectedPlates         []int        `json:"selectedPlates"`
@@ -142,10 +215,11 @@ type PlateInfo struct {
 
 // GameEndPayload when game ends
 type GameEndPayload struct {
-	Winner      int    `json:"winner"` // 0 for draw, 1 or 2 for winner
-	WinnerName  string `json:"winnerName"`
-	Reason      string `json:"reason"` // tokens, no_matches, forfeit
-	FinalTokens []int  `json:"finalTokens"`
+	Winner         int    `json:"winner"` // 0 for draw, 1 or 2 for winner
+	WinnerName     string `json:"winnerName"`
+	Reason         string `json:"reason"` // tokens, no_matches, forfeit
+	FinalTokens    []int  `json:"finalTokens"`
+	RematchTimeout int    `json:"rematchTimeoutSeconds,omitempty"` // Seconds to request a rematch, 0 if unavailable
 }
 
 // PlayerLeftPayload when opponent disconnects
@@ -159,6 +233,82 @@ type ReconnectedPayload struct {
 	PlayerIndex int `json:"playerIndex"`
 }
 
+// RematchOfferedPayload when the opponent requests a rematch
+type RematchOfferedPayload struct {
+	FromNickname   string `json:"fromNickname"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// RematchDeclinedPayload when the rematch window closes without a new game
+type RematchDeclinedPayload struct {
+	Reason string `json:"reason"` // declined, expired, left
+}
+
+// SessionReplacedPayload tells a socket its session was taken over by a
+// newer connection, right before it is closed
+type SessionReplacedPayload struct{}
+
+// SessionConflictPayload tells a new socket its session is live elsewhere
+type SessionConflictPayload struct {
+	CanTakeover bool `json:"canTakeover"` // Send takeover to move the session here
+}
+
+// TakeoverPayload moves the session to the sending socket
+type TakeoverPayload struct{}
+
+// SpectatePayload for watching a running invite room
+type SpectatePayload struct {
+	RoomCode string `json:"roomCode" schema:"trim,upper,pattern=^[A-HJ-NP-Z2-9]{6}$"`
+}
+
+// SpectatingPayload confirms a spectator joined; game_state follows
+type SpectatingPayload struct {
+	RoomID   string `json:"roomId"`
+	RoomCode string `json:"roomCode"`
+}
+
+// ClientPayloads maps every client message to its payload type. The router
+// checks payloads against schemas generated from these types.
+var ClientPayloads = map[MessageType]any{
+	MsgJoinQueue:       JoinQueuePayload{},
+	MsgCreateRoom:      CreateRoomPayload{},
+	MsgJoinRoom:        JoinRoomPayload{},
+	MsgPlaceToken:      PlaceTokenPayload{},
+	MsgSelectPlate:     SelectPlatePayload{},
+	MsgConfirmMatch:    ConfirmMatchPayload{},
+	MsgAddToken:        AddTokenPayload{},
+	MsgReconnect:       ReconnectPayload{},
+	MsgLeaveRoom:       LeaveRoomPayload{},
+	MsgReady:           ReadyPayload{},
+	MsgUpdateSettings:  UpdateSettingsPayload{},
+	MsgKickPlayer:      KickPlayerPayload{},
+	MsgRematchRequest:  RematchRequestPayload{},
+	MsgRematchResponse: RematchResponsePayload{},
+	MsgTakeover:        TakeoverPayload{},
+	MsgSpectate:        SpectatePayload{},
+}
+
+// ServerPayloads maps every server message to its payload type
+var ServerPayloads = map[MessageType]any{
+	MsgError:           ErrorPayload{},
+	MsgQueueJoined:     QueueJoinedPayload{},
+	MsgQueueTimeout:    QueueTimeoutPayload{},
+	MsgMatched:         MatchedPayload{},
+	MsgRoomCreated:     RoomCreatedPayload{},
+	MsgRoomJoined:      MatchedPayload{},
+	MsgGameState:       GameStatePayload{},
+	MsgGameEnd:         GameEndPayload{},
+	MsgPlayerLeft:      PlayerLeftPayload{},
+	MsgReconnected:     ReconnectedPayload{},
+	MsgLobbyState:      LobbyStatePayload{},
+	MsgRoomClosed:      RoomClosedPayload{},
+	MsgRematchOffered:  RematchOfferedPayload{},
+	MsgRematchDeclined: RematchDeclinedPayload{},
+	MsgSessionReplaced: SessionReplacedPayload{},
+	MsgSessionConflict: SessionConflictPayload{},
+	MsgSpectating:      SpectatingPayload{},
+}
+
 // Helper functions for creating messages
 
 func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
@@ -176,30 +326,10 @@ func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
 	}, nil
 }
 
-func NewErrorMessage(code, message string) (*Message, error) {
+func NewErrorMessage(code, message string, fields ...FieldError) (*Message, error) {
 	return NewMessage(MsgError, ErrorPayload{
 		Code:    code,
 		Message: message,
+		Fields:  fields,
 	})
 }
-
-// ValidMessagesForState maps ClientState to allowed message types
-// ClientState is defined in hub.go
-var ValidMessagesForLobby = []MessageType{
-	MsgJoinQueue,
-	MsgCreateRoom,
-	MsgJoinRoom,
-	MsgReconnect,
-}
-
-var ValidMessagesForWaiting = []MessageType{
-	MsgLeaveRoom,
-}
-
-var ValidMessagesForInGame = []MessageType{
-	MsgPlaceToken,
-	MsgSelectPlate,
-	MsgConfirmMatch,
-	MsgAddToken,
-	MsgLeaveRoom,
-}
@@ -0,0 +1,213 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+// slowHandlerThreshold is how long a message may take before Logging reports it
+const slowHandlerThreshold = 100 * time.Millisecond
+
+// DecodePayload checks the payload against the route's schema and decodes it
+// into the route's payload type before the handler runs
+func DecodePayload() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			payload, err := req.Route.decode(req.Message.Payload)
+			if err != nil {
+				var routeErr *RouteError
+				if errors.As(err, &routeErr) {
+					return routeErr
+				}
+				return &RouteError{Code: "invalid_payload", Message: "Invalid " + string(req.Message.Type) + " payload"}
+			}
+			req.Payload = payload
+			return next(req)
+		}
+	}
+}
+
+// CheckState rejects messages the route does not allow in the client's
+// current state
+func CheckState() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			state := req.Client.GetState()
+			if !req.Route.AllowedIn(state) {
+				return &RouteError{
+					Code:    "invalid_state",
+					Message: "Message " + string(req.Message.Type) + " not allowed in state " + string(state),
+				}
+			}
+			return next(req)
+		}
+	}
+}
+
+// Recover turns a panicking handler into an internal_error for that message,
+// keeping the client's ReadPump alive
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("panic handling %s from client %s: %v\n%s", req.Message.Type, req.Client.SessionID, p, debug.Stack())
+					err = &RouteError{Code: "internal_error", Message: "Internal server error"}
+				}
+			}()
+			return next(req)
+		}
+	}
+}
+
+// Logging reports rejected and slow messages
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			start := time.Now()
+			err := next(req)
+			if elapsed := time.Since(start); elapsed > slowHandlerThreshold {
+				log.Printf("Slow handler for %s from client %s: %v", req.Message.Type, req.Client.SessionID, elapsed)
+			}
+			if err != nil {
+				log.Printf("Message %s from client %s rejected: %v", req.Message.Type, req.Client.SessionID, err)
+			}
+			return err
+		}
+	}
+}
+
+// RateLimit allows each client perSecond messages on average, with bursts of
+// up to burst messages. Messages over the limit are rejected with
+// rate_limited. A nil clock means the wall clock.
+func RateLimit(perSecond float64, burst int, clk clock.Clock) Middleware {
+	clk = clock.OrReal(clk)
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			if !req.Client.limiter.allow(clk.Now(), perSecond, burst) {
+				return &RouteError{Code: "rate_limited", Message: "Too many messages"}
+			}
+			return next(req)
+		}
+	}
+}
+
+// tokenBucket is a client's message allowance for RateLimit
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time // Zero until the first message
+}
+
+func (b *tokenBucket) allow(now time.Time, perSecond float64, burst int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * perSecond
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// MessageStats counts the outcomes of one message type
+type MessageStats struct {
+	Handled   uint64            `json:"handled"`
+	Rejected  map[string]uint64 `json:"rejected,omitempty"` // Error code -> count
+	TotalTime time.Duration     `json:"totalTimeNs"`
+}
+
+// Metrics counts handled and rejected messages per type. It implements
+// expvar.Var so it can be published as is.
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[MessageType]*MessageStats
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{stats: make(map[MessageType]*MessageStats)}
+}
+
+// Middleware records every message that passes through it, by route so
+// unregistered types share one counter
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			start := time.Now()
+			err := next(req)
+			m.record(req.Route.Type, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+func (m *Metrics) record(msgType MessageType, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats[msgType]
+	if stats == nil {
+		stats = &MessageStats{}
+		m.stats[msgType] = stats
+	}
+	stats.TotalTime += elapsed
+	if err == nil {
+		stats.Handled++
+		return
+	}
+
+	code := "internal_error"
+	var routeErr *RouteError
+	if errors.As(err, &routeErr) {
+		code = routeErr.Code
+	}
+	if stats.Rejected == nil {
+		stats.Rejected = make(map[string]uint64)
+	}
+	stats.Rejected[code]++
+}
+
+// Snapshot returns a copy of the counters
+func (m *Metrics) Snapshot() map[MessageType]MessageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[MessageType]MessageStats, len(m.stats))
+	for msgType, stats := range m.stats {
+		copied := *stats
+		if stats.Rejected != nil {
+			copied.Rejected = make(map[string]uint64, len(stats.Rejected))
+			for code, n := range stats.Rejected {
+				copied.Rejected[code] = n
+			}
+		}
+		out[msgType] = copied
+	}
+	return out
+}
+
+// String encodes the counters as JSON
+func (m *Metrics) String() string {
+	data, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,133 @@
+package ws
+
+import "sync"
+
+// DefaultSendQueueSize is the number of frames a client may have waiting
+// before the slow-consumer policy applies
+const DefaultSendQueueSize = 256
+
+// FrameKind classifies an outbound frame for the slow-consumer policy
+type FrameKind int
+
+const (
+	// FrameControl must be delivered in order: errors, lobby updates, game end
+	FrameControl FrameKind = iota
+	// FrameState is a full game state snapshot; a newer one supersedes it
+	FrameState
+)
+
+// SlowConsumerPolicy decides what happens when a client's send queue is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerCoalesce drops queued state snapshots in favour of the
+	// newest one, and disconnects only when a control frame does not fit
+	SlowConsumerCoalesce SlowConsumerPolicy = "coalesce"
+	// SlowConsumerDisconnect disconnects as soon as the queue is full. The
+	// client resyncs when it reconnects.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// ParseSlowConsumerPolicy validates a policy name. An empty name yields
+// SlowConsumerCoalesce.
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, bool) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case "":
+		return SlowConsumerCoalesce, true
+	case SlowConsumerCoalesce, SlowConsumerDisconnect:
+		return policy, true
+	default:
+		return "", false
+	}
+}
+
+type frame struct {
+	kind FrameKind
+	data []byte
+}
+
+// outbox is a client's bounded send queue, drained by WritePump
+type outbox struct {
+	mu     sync.Mutex
+	frames []frame
+	limit  int
+	policy SlowConsumerPolicy
+	ready  chan struct{} // Signalled when frames are queued
+}
+
+func newOutbox(limit int, policy SlowConsumerPolicy) *outbox {
+	if limit <= 0 {
+		limit = DefaultSendQueueSize
+	}
+	if policy == "" {
+		policy = SlowConsumerCoalesce
+	}
+	return &outbox{
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame. It never blocks; ErrSlowConsumer means the policy
+// could not make room and the client should be disconnected.
+func (o *outbox) push(f frame) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.frames) >= o.limit && !o.makeRoomLocked(f) {
+		return ErrSlowConsumer
+	}
+
+	o.frames = append(o.frames, f)
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// makeRoomLocked applies the policy to a full queue, reporting whether f
+// now fits
+func (o *outbox) makeRoomLocked(f frame) bool {
+	if o.policy != SlowConsumerCoalesce || f.kind != FrameState {
+		return false
+	}
+	// The new snapshot supersedes every queued one
+	return o.removeLocked(FrameState)
+}
+
+// removeLocked drops every queued frame of the given kind
+func (o *outbox) removeLocked(kind FrameKind) bool {
+	kept := o.frames[:0]
+	for _, f := range o.frames {
+		if f.kind != kind {
+			kept = append(kept, f)
+		}
+	}
+	removed := len(kept) < len(o.frames)
+	clear(o.frames[len(kept):])
+	o.frames = kept
+	return removed
+}
+
+// pop removes and returns the oldest queued frame. Frames are taken one at
+// a time so those still waiting behind a slow write can be coalesced.
+func (o *outbox) pop() ([]byte, bool) {
+	f, ok := o.popFrame()
+	return f.data, ok
+}
+
+// popFrame is pop keeping the frame's kind, for relaying it on
+func (o *outbox) popFrame() (frame, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.frames) == 0 {
+		return frame{}, false
+	}
+	f := o.frames[0]
+	o.frames[0] = frame{}
+	o.frames = o.frames[1:]
+	return f, true
+}
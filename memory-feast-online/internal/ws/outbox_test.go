@@ -0,0 +1,73 @@
+package ws
+
+import "testing"
+
+func queued(o *outbox) []string {
+	var out []string
+	for data, ok := o.pop(); ok; data, ok = o.pop() {
+		out = append(out, string(data))
+	}
+	return out
+}
+
+func TestOutboxFullQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SlowConsumerPolicy
+		next    frame
+		wantErr error
+		want    []string
+	}{
+		{"coalesce replaces snapshots", SlowConsumerCoalesce, frame{FrameState, []byte("s2")}, nil, []string{"c1", "c2", "s2"}},
+		{"coalesce keeps snapshots for control", SlowConsumerCoalesce, frame{FrameControl, []byte("c3")}, ErrSlowConsumer, []string{"c1", "s1", "c2"}},
+		{"disconnect", SlowConsumerDisconnect, frame{FrameState, []byte("s2")}, ErrSlowConsumer, []string{"c1", "s1", "c2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOutbox(3, tt.policy)
+			o.push(frame{FrameControl, []byte("c1")})
+			o.push(frame{FrameState, []byte("s1")})
+			o.push(frame{FrameControl, []byte("c2")})
+
+			if err := o.push(tt.next); err != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			got := queued(o)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected queue %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected queue %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxDisconnectsWhenControlFrameCannotFit(t *testing.T) {
+	o := newOutbox(2, SlowConsumerCoalesce)
+	o.push(frame{FrameControl, []byte("c1")})
+	o.push(frame{FrameControl, []byte("c2")})
+
+	if err := o.push(frame{FrameControl, []byte("c3")}); err != ErrSlowConsumer {
+		t.Fatalf("expected ErrSlowConsumer, got %v", err)
+	}
+}
+
+func TestEnqueueClosesSlowClient(t *testing.T) {
+	hub := NewHub()
+	hub.SetSendQueue(1, SlowConsumerDisconnect)
+	client := NewClient(hub, nil, "slow")
+
+	if err := client.Enqueue(FrameState, []byte("s1")); err != nil {
+		t.Fatalf("first frame should fit, got %v", err)
+	}
+	if err := client.Enqueue(FrameState, []byte("s2")); err != ErrSlowConsumer {
+		t.Fatalf("expected ErrSlowConsumer, got %v", err)
+	}
+	if !client.IsClosed() {
+		t.Fatal("expected slow client to be closed")
+	}
+}
@@ -0,0 +1,43 @@
+package ws
+
+import "github.com/gorilla/websocket"
+
+// NewRemoteClient creates a client for a session whose socket is held by
+// another server instance. It is registered and messaged like any other
+// client; RelayPump hands its queued frames to that instance. Conn is a
+// placeholder that is never read or written: it only tells this connection
+// apart from the session's others, as seats compare connections.
+func NewRemoteClient(hub *Hub, sessionID, instance string) *Client {
+	c := NewClient(hub, new(websocket.Conn), sessionID)
+	c.Remote = instance
+	return c
+}
+
+// RelayPump drains the send queue of a remote client to forward, in order,
+// until the client is closed or forward fails. It plays WritePump's part and
+// closes the client when it returns.
+func (c *Client) RelayPump(forward func(kind FrameKind, data []byte) error) error {
+	defer c.Close()
+
+	for {
+		select {
+		case <-c.outbox.ready:
+			if err := c.relayQueued(forward); err != nil {
+				return err
+			}
+		case <-c.finish:
+			return c.relayQueued(forward)
+		case <-c.done:
+			return nil
+		}
+	}
+}
+
+func (c *Client) relayQueued(forward func(kind FrameKind, data []byte) error) error {
+	for f, ok := c.outbox.popFrame(); ok; f, ok = c.outbox.popFrame() {
+		if err := forward(f.kind, f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// unknownType is the route type unregistered message types are handled, and
+// counted by Metrics, under
+const unknownType MessageType = "unknown"
+
+// RouteError rejects a message. Its code, message and field errors are sent
+// back to the client as an error message.
+type RouteError struct {
+	Code    string
+	Message string
+	Fields  []FieldError // Set when the payload failed its schema
+}
+
+func (e *RouteError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Request is one client message on its way through the middleware chain
+type Request struct {
+	Client  *Client
+	Message *Message
+	Route   *Route
+	Payload any // Set by DecodePayload
+}
+
+// Handler processes a request. A returned error rejects the message.
+type Handler func(req *Request) error
+
+// Middleware wraps a handler with behaviour shared by every route
+type Middleware func(next Handler) Handler
+
+// Route describes a client message type: its payload, the client states it
+// is allowed in and its handler
+type Route struct {
+	Type   MessageType
+	States []ClientState // Allowed client states, nil for any state
+	Schema *Schema       // Generated from the payload type
+
+	decode  func(raw json.RawMessage) (any, error)
+	handle  Handler
+	chained Handler // handle wrapped in the router's middleware
+}
+
+// AllowedIn reports whether the message may be sent in the given state
+func (rt *Route) AllowedIn(state ClientState) bool {
+	if rt.States == nil {
+		return true
+	}
+	for _, allowed := range rt.States {
+		if allowed == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Router dispatches client messages to their registered routes through a
+// middleware chain
+type Router struct {
+	routes  map[MessageType]*Route
+	unknown *Route // Rejects unregistered message types through the chain
+	chain   []Middleware
+	onError func(client *Client, err *RouteError)
+}
+
+// NewRouter creates an empty router. onError is called with every rejected
+// message; errors that are not a *RouteError are reported as internal_error.
+// Unregistered message types go through the middleware like any other and
+// are rejected with unknown_message.
+func NewRouter(onError func(client *Client, err *RouteError)) *Router {
+	r := &Router{
+		routes:  make(map[MessageType]*Route),
+		onError: onError,
+	}
+	r.unknown = &Route{
+		Type:   unknownType,
+		decode: func(json.RawMessage) (any, error) { return nil, nil },
+		handle: func(req *Request) error {
+			return &RouteError{Code: "unknown_message", Message: "Unknown message type " + string(req.Message.Type)}
+		},
+	}
+	r.wrap(r.unknown)
+	return r
+}
+
+// Use appends middleware to the chain. The first one added runs outermost.
+func (r *Router) Use(middleware ...Middleware) {
+	r.chain = append(r.chain, middleware...)
+	r.wrap(r.unknown)
+	for _, route := range r.routes {
+		r.wrap(route)
+	}
+}
+
+// wrap builds a route's handler chain from the middleware added so far
+func (r *Router) wrap(route *Route) {
+	handler := route.handle
+	for i := len(r.chain) - 1; i >= 0; i-- {
+		handler = r.chain[i](handler)
+	}
+	route.chained = handler
+}
+
+// Handle registers fn for a message type. Payloads are checked against the
+// schema generated from P, normalized and decoded into P.
+func Handle[P any](r *Router, msgType MessageType, states []ClientState, fn func(client *Client, payload P)) {
+	var zero P
+	schema := PayloadSchema(zero)
+	route := &Route{
+		Type:   msgType,
+		States: states,
+		Schema: schema,
+		decode: func(raw json.RawMessage) (any, error) {
+			normalized, fields := schema.Check(raw)
+			if fields != nil {
+				return nil, &RouteError{Code: "invalid_payload", Message: "Invalid " + string(msgType) + " payload", Fields: fields}
+			}
+			var payload P
+			if err := json.Unmarshal(normalized, &payload); err != nil {
+				return nil, err
+			}
+			return payload, nil
+		},
+		handle: func(req *Request) error {
+			payload, ok := req.Payload.(P)
+			if !ok {
+				return &RouteError{Code: "invalid_payload", Message: "Invalid " + string(msgType) + " payload"}
+			}
+			fn(req.Client, payload)
+			return nil
+		},
+	}
+	r.wrap(route)
+	r.routes[msgType] = route
+}
+
+// Route returns the route registered for a message type, or nil
+func (r *Router) Route(msgType MessageType) *Route {
+	return r.routes[msgType]
+}
+
+// Routes returns every registered route, sorted by message type
+func (r *Router) Routes() []*Route {
+	routes := make([]*Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Type < routes[j].Type })
+	return routes
+}
+
+// Dispatch runs a client message through the middleware chain to its route
+func (r *Router) Dispatch(client *Client, msg *Message) {
+	route := r.routes[msg.Type]
+	if route == nil {
+		route = r.unknown
+	}
+	if err := route.chained(&Request{Client: client, Message: msg, Route: route}); err != nil {
+		r.reject(client, err)
+	}
+}
+
+func (r *Router) reject(client *Client, err error) {
+	if r.onError == nil {
+		return
+	}
+	routeErr, ok := err.(*RouteError)
+	if !ok {
+		routeErr = &RouteError{Code: "internal_error", Message: "Internal server error"}
+	}
+	r.onError(client, routeErr)
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"memory-feast-online/internal/clock"
+)
+
+// newTestRouter records rejected codes and routes ready to a handler that
+// panics on ready=false
+func newTestRouter(t *testing.T, middleware ...Middleware) (*Router, *[]string, *int) {
+	t.Helper()
+
+	var rejected []string
+	handled := 0
+	r := NewRouter(func(_ *Client, err *RouteError) {
+		rejected = append(rejected, err.Code)
+	})
+	r.Use(middleware...)
+	Handle(r, MsgReady, []ClientState{ClientWaiting}, func(_ *Client, p ReadyPayload) {
+		if !p.Ready {
+			panic("not ready")
+		}
+		handled++
+	})
+	Handle(r, MsgJoinQueue, nil, func(*Client, JoinQueuePayload) { handled++ })
+	return r, &rejected, &handled
+}
+
+func rawMessage(msgType MessageType, payload string) *Message {
+	return &Message{Type: msgType, Payload: json.RawMessage(payload)}
+}
+
+func TestRouterMiddlewareRejectsWithCodes(t *testing.T) {
+	metrics := NewMetrics()
+	r, rejected, handled := newTestRouter(t, metrics.Middleware(), Recover(), CheckState(), DecodePayload())
+
+	client := NewClient(nil, nil, "s1")
+	client.Transition(ClientWaiting)
+
+	tests := []struct {
+		msg  *Message
+		want string
+	}{
+		{rawMessage(MsgReady, `{"ready":true}`), ""},
+		{rawMessage(MsgReady, `{"ready":"yes"}`), "invalid_payload"},
+		{rawMessage(MsgReady, `{"ready":false}`), "internal_error"},
+		{rawMessage(MsgJoinQueue, `{"nickname":" "}`), "invalid_payload"},
+		{rawMessage(MsgReady, `{"ready":true,"extra":1}`), "invalid_payload"},
+		{rawMessage(MsgPlaceToken, `{"index":0}`), "unknown_message"},
+	}
+	for _, tt := range tests {
+		*rejected = nil
+		r.Dispatch(client, tt.msg)
+		got := ""
+		if len(*rejected) > 0 {
+			got = (*rejected)[0]
+		}
+		if got != tt.want {
+			t.Fatalf("%s %s: expected rejection %q, got %q", tt.msg.Type, tt.msg.Payload, tt.want, got)
+		}
+	}
+
+	client.Transition(ClientLobby)
+	*rejected = nil
+	r.Dispatch(client, rawMessage(MsgReady, `{"ready":true}`))
+	if len(*rejected) != 1 || (*rejected)[0] != "invalid_state" {
+		t.Fatalf("expected invalid_state in lobby, got %v", *rejected)
+	}
+
+	if *handled != 1 {
+		t.Fatalf("expected one handled message, got %d", *handled)
+	}
+	stats := metrics.Snapshot()[MsgReady]
+	if stats.Handled != 1 || stats.Rejected["internal_error"] != 1 || stats.Rejected["invalid_state"] != 1 {
+		t.Fatalf("unexpected ready stats %+v", stats)
+	}
+}
+
+func TestRateLimitRefillsOverTime(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	r, rejected, handled := newTestRouter(t, RateLimit(1, 2, fake), DecodePayload())
+	client := NewClient(nil, nil, "s1")
+
+	msg := rawMessage(MsgJoinQueue, `{"nickname":"A"}`)
+	for i := 0; i < 3; i++ {
+		r.Dispatch(client, msg)
+	}
+	if *handled != 2 || len(*rejected) != 1 || (*rejected)[0] != "rate_limited" {
+		t.Fatalf("expected burst of 2 then rate_limited, got handled=%d rejected=%v", *handled, *rejected)
+	}
+
+	fake.Advance(time.Second)
+	r.Dispatch(client, msg)
+	if *handled != 3 {
+		t.Fatalf("expected a token after one second, got handled=%d", *handled)
+	}
+}
+
+func TestUnknownMessagesGoThroughMiddleware(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	metrics := NewMetrics()
+	built := 0
+	counting := func(next Handler) Handler {
+		built++
+		return next
+	}
+	r, rejected, _ := newTestRouter(t, counting, metrics.Middleware(), RateLimit(1, 2, fake), DecodePayload())
+	client := NewClient(nil, nil, "s1")
+
+	for i := 0; i < 3; i++ {
+		r.Dispatch(client, rawMessage(MessageType("bogus"), `{}`))
+	}
+	if want := []string{"unknown_message", "unknown_message", "rate_limited"}; !slices.Equal(*rejected, want) {
+		t.Fatalf("expected %v, got %v", want, *rejected)
+	}
+	stats := metrics.Snapshot()
+	if stats[unknownType].Rejected["unknown_message"] != 2 || stats[unknownType].Rejected["rate_limited"] != 1 {
+		t.Fatalf("expected unknown types counted together, got %+v", stats)
+	}
+	if _, ok := stats["bogus"]; ok {
+		t.Fatal("expected no counter per unknown type")
+	}
+
+	// Chains are built when routes and middleware are added, not per message
+	before := built
+	r.Dispatch(client, rawMessage(MsgJoinQueue, `{"nickname":"A"}`))
+	if built != before {
+		t.Fatalf("expected no chain built on dispatch, built %d", built-before)
+	}
+}
@@ -0,0 +1,380 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// SchemaDialect is the JSON Schema draft the protocol schema is written in
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema the protocol uses. Payload schemas are
+// generated from the payload structs: every field without omitempty is
+// required, unknown fields are refused and the `schema` struct tag adds
+// constraints, e.g. `schema:"trim,minLength=1,maxLength=12"`. An enum the
+// protocol does not own is named instead of listed, `schema:"enumOf=ruleset"`,
+// and takes the values registered under that name with RegisterEnum.
+type Schema struct {
+	Dialect              string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Const                string             `json:"const,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Normalize            []string           `json:"x-normalize,omitempty"` // trim, upper: applied by the server before the other keywords
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// FieldError is one payload field that failed validation. Field is a JSON
+// pointer into the payload, empty for the payload itself.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var (
+	enums   = make(map[string]func() []string)
+	enumsMu sync.RWMutex
+)
+
+// RegisterEnum names the values of an enumOf schema tag. values is called
+// whenever a schema using the name is built, so it can list a registry that
+// grows, like the game's rulesets.
+func RegisterEnum(name string, values func() []string) {
+	enumsMu.Lock()
+	defer enumsMu.Unlock()
+	enums[name] = values
+}
+
+// PayloadSchema generates the schema of a payload struct. It panics on field
+// types or tags the protocol cannot describe, like regexp.MustCompile, and on
+// an enumOf name nothing registered.
+func PayloadSchema(payload any) *Schema {
+	return typeSchema(reflect.TypeOf(payload))
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	panic("ws: no schema for " + t.String())
+}
+
+func structSchema(t reflect.Type) *Schema {
+	closed := false
+	sc := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: &closed,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := typeSchema(field.Type)
+		if tag := field.Tag.Get("schema"); tag != "" {
+			if err := prop.applyTag(tag); err != nil {
+				panic(fmt.Sprintf("ws: %s.%s: %v", t.Name(), field.Name, err))
+			}
+		}
+		sc.Properties[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			sc.Required = append(sc.Required, name)
+		}
+	}
+	return sc
+}
+
+// applyTag adds the constraints of a `schema` struct tag
+func (sc *Schema) applyTag(tag string) error {
+	for _, item := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(item, "=")
+		switch key {
+		case "trim", "upper":
+			sc.Normalize = append(sc.Normalize, key)
+		case "enum":
+			sc.Enum = strings.Split(value, "|")
+		case "enumOf":
+			enumsMu.RLock()
+			values, ok := enums[value]
+			enumsMu.RUnlock()
+			if !ok {
+				return fmt.Errorf("no enum registered as %q", value)
+			}
+			sc.Enum = values()
+		case "pattern":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return err
+			}
+			sc.Pattern, sc.pattern = value, re
+		case "minLength", "maxLength":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if key == "minLength" {
+				sc.MinLength = &n
+			} else {
+				sc.MaxLength = &n
+			}
+		case "minimum", "maximum":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if key == "minimum" {
+				sc.Minimum = &n
+			} else {
+				sc.Maximum = &n
+			}
+		default:
+			return fmt.Errorf("unknown schema keyword %q", key)
+		}
+	}
+	return nil
+}
+
+// Check validates a raw payload against the schema. On success it returns
+// the payload with string fields normalized, ready to be decoded. An empty
+// payload is checked as an empty object.
+func (sc *Schema) Check(raw json.RawMessage) (json.RawMessage, []FieldError) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = json.RawMessage("{}")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return nil, []FieldError{{Field: "", Message: "is not valid JSON"}}
+	}
+
+	var errs []FieldError
+	value = sc.check("", value, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return nil, []FieldError{{Field: "", Message: err.Error()}}
+	}
+	return normalized, nil
+}
+
+// check validates one value, recording failures under path, and returns
+// the value after normalization
+func (sc *Schema) check(path string, value any, errs *[]FieldError) any {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch sc.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return value
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				*errs = append(*errs, FieldError{Field: path + "/" + name, Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop := sc.Properties[name]
+			if prop == nil {
+				if sc.AdditionalProperties != nil && !*sc.AdditionalProperties {
+					*errs = append(*errs, FieldError{Field: path + "/" + name, Message: "is not a known field"})
+				}
+				continue
+			}
+			obj[name] = prop.check(path+"/"+name, obj[name], errs)
+		}
+		return obj
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return value
+		}
+		for i := range items {
+			items[i] = sc.Items.check(path+"/"+strconv.Itoa(i), items[i], errs)
+		}
+		return items
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return value
+		}
+		for _, n := range sc.Normalize {
+			switch n {
+			case "trim":
+				s = strings.TrimSpace(s)
+			case "upper":
+				s = strings.ToUpper(s)
+			}
+		}
+		length := utf8.RuneCountInString(s)
+		if sc.MinLength != nil && length < *sc.MinLength {
+			if *sc.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", *sc.MinLength)
+			}
+		}
+		if sc.MaxLength != nil && length > *sc.MaxLength {
+			fail("must be at most %d characters", *sc.MaxLength)
+		}
+		if sc.pattern != nil && !sc.pattern.MatchString(s) {
+			fail("must match %s", sc.Pattern)
+		}
+		if len(sc.Enum) > 0 && !containsString(sc.Enum, s) {
+			fail("must be one of %s", strings.Join(sc.Enum, ", "))
+		}
+		return s
+
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a %s", sc.Type)
+			return value
+		}
+		if sc.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				fail("must be an integer")
+				return value
+			}
+		}
+		f, _ := n.Float64()
+		if sc.Minimum != nil && f < *sc.Minimum {
+			fail("must be at least %v", *sc.Minimum)
+		}
+		if sc.Maximum != nil && f > *sc.Maximum {
+			fail("must be at most %v", *sc.Maximum)
+		}
+		return n
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+		return value
+	}
+	return value
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtocolSchema describes every WebSocket message in both directions. Each
+// payload is a definition named after its Go type; ClientMessage and
+// ServerMessage list the message envelopes that carry them.
+func ProtocolSchema() *Schema {
+	doc := &Schema{
+		Dialect: SchemaDialect,
+		ID:      "/protocol/schema.json",
+		Title:   "Memory Feast WebSocket protocol",
+		Defs:    make(map[string]*Schema),
+	}
+	doc.Defs["ClientMessage"] = doc.envelopes(ClientPayloads)
+	doc.Defs["ServerMessage"] = doc.envelopes(ServerPayloads)
+	doc.OneOf = []*Schema{
+		{Ref: "#/$defs/ClientMessage"},
+		{Ref: "#/$defs/ServerMessage"},
+	}
+	return doc
+}
+
+// envelopes adds the payload definitions of a message set and returns the
+// schema matching any one of its envelopes
+func (doc *Schema) envelopes(payloads map[MessageType]any) *Schema {
+	types := make([]string, 0, len(payloads))
+	for msgType := range payloads {
+		types = append(types, string(msgType))
+	}
+	sort.Strings(types)
+
+	closed := false
+	set := &Schema{}
+	for _, msgType := range types {
+		payload := payloads[MessageType(msgType)]
+		name := reflect.TypeOf(payload).Name()
+		def := PayloadSchema(payload)
+		doc.Defs[name] = def
+
+		envelope := &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"type":    {Type: "string", Const: msgType},
+				"payload": {Ref: "#/$defs/" + name},
+			},
+			Required:             []string{"type"},
+			AdditionalProperties: &closed,
+		}
+		if len(def.Required) > 0 {
+			envelope.Required = append(envelope.Required, "payload")
+		}
+		set.OneOf = append(set.OneOf, envelope)
+	}
+	return set
+}
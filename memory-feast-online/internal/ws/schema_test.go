@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// The game registers these outside this package
+func init() {
+	RegisterEnum("ruleset", func() []string { return []string{"blitz", "classic"} })
+	RegisterEnum("firstPlayerPolicy", func() []string { return []string{"random"} })
+}
+
+func TestPayloadSchemaFromStructTags(t *testing.T) {
+	sc := PayloadSchema(CreateRoomPayload{})
+	if sc.Type != "object" || sc.AdditionalProperties == nil || *sc.AdditionalProperties {
+		t.Fatalf("expected a closed object, got %+v", sc)
+	}
+	if !reflect.DeepEqual(sc.Required, []string{"nickname"}) {
+		t.Fatalf("expected only nickname to be required, got %v", sc.Required)
+	}
+	nickname := sc.Properties["nickname"]
+	if *nickname.MinLength != 1 || *nickname.MaxLength != 12 || !reflect.DeepEqual(nickname.Normalize, []string{"trim"}) {
+		t.Fatalf("unexpected nickname schema %+v", nickname)
+	}
+	if plates := sc.Properties["plateCount"]; plates.Type != "integer" || *plates.Maximum != 20 {
+		t.Fatalf("unexpected plateCount schema %+v", plates)
+	}
+
+	state := PayloadSchema(GameStatePayload{})
+	if items := state.Properties["players"].Items; items == nil || items.Properties["tokens"].Type != "integer" {
+		t.Fatalf("expected players to describe PlayerInfo items, got %+v", state.Properties["players"])
+	}
+	if state.Properties["lastActionPlate"].Type != "integer" {
+		t.Fatalf("expected pointer fields to use the element schema")
+	}
+}
+
+func TestSchemaEnumOfTakesRegisteredValues(t *testing.T) {
+	type payload struct {
+		Mode string `json:"mode" schema:"enumOf=testMode"`
+	}
+	modes := []string{"slow"}
+	RegisterEnum("testMode", func() []string { return modes })
+
+	modes = append(modes, "fast")
+	sc := PayloadSchema(payload{})
+	if got := sc.Properties["mode"].Enum; !reflect.DeepEqual(got, []string{"slow", "fast"}) {
+		t.Fatalf("expected the values registered when the schema is built, got %v", got)
+	}
+	if _, fields := sc.Check(json.RawMessage(`{"mode":"fast"}`)); fields != nil {
+		t.Fatalf("expected a registered value to pass, got %v", fields)
+	}
+
+	type unregistered struct {
+		Mode string `json:"mode" schema:"enumOf=missingMode"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected an unregistered enum to panic")
+		}
+	}()
+	PayloadSchema(unregistered{})
+}
+
+func TestSchemaCheckReportsFields(t *testing.T) {
+	sc := PayloadSchema(JoinRoomPayload{})
+
+	tests := []struct {
+		raw    string
+		fields []FieldError
+	}{
+		{`{"nickname":"Kim","roomCode":"ABCD23"}`, nil},
+		{`{"nickname":"Kim","roomCode":"ABCD23","admin":true}`, []FieldError{{"/admin", "is not a known field"}}},
+		{`{"nickname":"   ","roomCode":"ABCD23"}`, []FieldError{{"/nickname", "must not be empty"}}},
+		{`{"nickname":7,"roomCode":"ABCD23"}`, []FieldError{{"/nickname", "must be a string"}}},
+		{`{"nickname":"Kim"}`, []FieldError{{"/roomCode", "is required"}}},
+		{`{"nickname":"Kim","roomCode":"ABCD10"}`, []FieldError{{"/roomCode", "must match ^[A-HJ-NP-Z2-9]{6}$"}}},
+		{`["Kim"]`, []FieldError{{"", "must be an object"}}},
+		{`{"nickname":`, []FieldError{{"", "is not valid JSON"}}},
+	}
+	for _, tt := range tests {
+		_, fields := sc.Check(json.RawMessage(tt.raw))
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Fatalf("%s: expected %v, got %v", tt.raw, tt.fields, fields)
+		}
+	}
+
+	normalized, fields := sc.Check(json.RawMessage(`{"nickname":"  Kim ","roomCode":" abcd23"}`))
+	if fields != nil {
+		t.Fatalf("expected a valid payload, got %v", fields)
+	}
+	var payload JoinRoomPayload
+	if err := json.Unmarshal(normalized, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Nickname != "Kim" || payload.RoomCode != "ABCD23" {
+		t.Fatalf("expected normalized fields, got %+v", payload)
+	}
+
+	if _, fields := PayloadSchema(PlaceTokenPayload{}).Check(json.RawMessage(`{"index":1.5}`)); len(fields) != 1 || fields[0].Message != "must be an integer" {
+		t.Fatalf("expected a fractional index to be refused, got %v", fields)
+	}
+}
+
+func TestProtocolSchemaCoversEveryMessage(t *testing.T) {
+	doc := ProtocolSchema()
+	for set, payloads := range map[string]map[MessageType]any{"ClientMessage": ClientPayloads, "ServerMessage": ServerPayloads} {
+		envelopes := doc.Defs[set].OneOf
+		if len(envelopes) != len(payloads) {
+			t.Fatalf("expected %d %s envelopes, got %d", len(payloads), set, len(envelopes))
+		}
+		for _, envelope := range envelopes {
+			msgType := MessageType(envelope.Properties["type"].Const)
+			name := reflect.TypeOf(payloads[msgType]).Name()
+			if envelope.Properties["payload"].Ref != "#/$defs/"+name || doc.Defs[name] == nil {
+				t.Fatalf("%s: expected payload to reference %s", msgType, name)
+			}
+		}
+	}
+
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("failed to encode protocol schema: %v", err)
+	}
+}
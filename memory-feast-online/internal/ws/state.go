@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ClientState represents the state of a WebSocket client
+type ClientState string
+
+const (
+	ClientLobby      ClientState = "lobby"
+	ClientWaiting    ClientState = "waiting" // In queue or waiting room
+	ClientInGame     ClientState = "in_game"
+	ClientSpectating ClientState = "spectating" // Watching a game without a seat
+	ClientPostGame   ClientState = "post_game"  // Game over, rematch window open
+)
+
+// StateTransitions declares every legal client state change. Staying in the
+// same state is always allowed and is not a transition.
+var StateTransitions = map[ClientState][]ClientState{
+	// PostGame from the lobby is a replacing socket resuming a rematch window
+	ClientLobby:      {ClientWaiting, ClientInGame, ClientSpectating, ClientPostGame},
+	ClientWaiting:    {ClientLobby, ClientInGame},
+	ClientInGame:     {ClientLobby, ClientPostGame},
+	ClientSpectating: {ClientLobby},
+	ClientPostGame:   {ClientLobby, ClientWaiting, ClientInGame, ClientSpectating},
+}
+
+// CanTransition reports whether the table allows moving from one state to
+// another
+func CanTransition(from, to ClientState) bool {
+	for _, next := range StateTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange is one client moving between states
+type StateChange struct {
+	Client *Client
+	From   ClientState
+	To     ClientState
+}
+
+// StateMachine validates client state changes against StateTransitions and
+// runs the hooks registered for them. Guards run before the change and can
+// veto it; exit hooks, enter hooks and subscribers run afterwards, in that
+// order, without any client lock held.
+type StateMachine struct {
+	mu          sync.RWMutex
+	guards      map[ClientState][]func(StateChange) error // By target state
+	exitHooks   map[ClientState][]func(StateChange)
+	enterHooks  map[ClientState][]func(StateChange)
+	subscribers []func(StateChange)
+}
+
+// NewStateMachine creates a state machine with no hooks
+func NewStateMachine() *StateMachine {
+	return &StateMachine{
+		guards:     make(map[ClientState][]func(StateChange) error),
+		exitHooks:  make(map[ClientState][]func(StateChange)),
+		enterHooks: make(map[ClientState][]func(StateChange)),
+	}
+}
+
+// Guard adds a check that must pass before a client enters the state
+func (m *StateMachine) Guard(to ClientState, guard func(StateChange) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.guards[to] = append(m.guards[to], guard)
+}
+
+// OnExit adds a hook run after a client leaves the state
+func (m *StateMachine) OnExit(from ClientState, hook func(StateChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exitHooks[from] = append(m.exitHooks[from], hook)
+}
+
+// OnEnter adds a hook run after a client enters the state
+func (m *StateMachine) OnEnter(to ClientState, hook func(StateChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enterHooks[to] = append(m.enterHooks[to], hook)
+}
+
+// Subscribe adds a listener for every state change
+func (m *StateMachine) Subscribe(listener func(StateChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, listener)
+}
+
+// transition moves c to the target state. With a non-nil from, the client
+// must still be in that state.
+func (m *StateMachine) transition(c *Client, from *ClientState, to ClientState) error {
+	c.transitionMu.Lock()
+
+	current := c.GetState()
+	if from != nil && current != *from {
+		c.transitionMu.Unlock()
+		return ErrStateChanged
+	}
+	if current == to {
+		c.transitionMu.Unlock()
+		return nil
+	}
+	if !CanTransition(current, to) {
+		c.transitionMu.Unlock()
+		return fmt.Errorf("%w: %s → %s", ErrIllegalTransition, current, to)
+	}
+
+	change := StateChange{Client: c, From: current, To: to}
+	m.mu.RLock()
+	guards := m.guards[to]
+	exitHooks, enterHooks := m.exitHooks[current], m.enterHooks[to]
+	subscribers := m.subscribers
+	m.mu.RUnlock()
+
+	for _, guard := range guards {
+		if err := guard(change); err != nil {
+			c.transitionMu.Unlock()
+			return fmt.Errorf("%s → %s: %w", current, to, err)
+		}
+	}
+
+	c.stateMu.Lock()
+	c.State = to
+	c.stateMu.Unlock()
+	c.transitionMu.Unlock()
+
+	sessionPrefix := c.SessionID
+	if len(sessionPrefix) > 8 {
+		sessionPrefix = sessionPrefix[:8]
+	}
+	log.Printf("Client %s: state %s → %s", sessionPrefix, current, to)
+
+	for _, hook := range exitHooks {
+		hook(change)
+	}
+	for _, hook := range enterHooks {
+		hook(change)
+	}
+	for _, listener := range subscribers {
+		listener(change)
+	}
+	return nil
+}
+
+// tableOnly checks clients created without a hub
+var tableOnly = NewStateMachine()
+
+func (c *Client) stateMachine() *StateMachine {
+	if c.Hub == nil {
+		return tableOnly
+	}
+	return c.Hub.states
+}
+
+// Transition moves the client to a new state, running the hub's hooks.
+// Illegal changes return an error wrapping ErrIllegalTransition and leave
+// the state as it was.
+func (c *Client) Transition(to ClientState) error {
+	return c.stateMachine().transition(c, nil, to)
+}
+
+// TransitionFrom is Transition for a client expected to be in from. It
+// returns ErrStateChanged if the client has moved on already.
+func (c *Client) TransitionFrom(from, to ClientState) error {
+	return c.stateMachine().transition(c, &from, to)
+}
@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStateTransitionTable(t *testing.T) {
+	states := []ClientState{ClientLobby, ClientWaiting, ClientInGame, ClientSpectating, ClientPostGame}
+	for _, from := range states {
+		if _, ok := StateTransitions[from]; !ok {
+			t.Fatalf("expected %s to declare its transitions", from)
+		}
+		for _, to := range StateTransitions[from] {
+			if _, ok := StateTransitions[to]; !ok {
+				t.Fatalf("%s → %s leads to an undeclared state", from, to)
+			}
+		}
+	}
+
+	tests := []struct {
+		from, to ClientState
+		want     bool
+	}{
+		{ClientLobby, ClientWaiting, true},
+		{ClientWaiting, ClientInGame, true},
+		{ClientInGame, ClientPostGame, true},
+		{ClientPostGame, ClientInGame, true},
+		{ClientWaiting, ClientPostGame, false},
+		{ClientInGame, ClientWaiting, false},
+		{ClientSpectating, ClientInGame, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Fatalf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionRunsGuardsAndHooks(t *testing.T) {
+	hub := NewHub()
+	states := hub.States()
+
+	var events []string
+	vetoed := errors.New("no room")
+	states.Guard(ClientInGame, func(StateChange) error { return vetoed })
+	states.OnExit(ClientLobby, func(c StateChange) { events = append(events, "exit "+string(c.From)) })
+	states.OnEnter(ClientWaiting, func(c StateChange) { events = append(events, "enter "+string(c.To)) })
+	states.Subscribe(func(c StateChange) { events = append(events, "event") })
+
+	client := NewClient(hub, nil, "s1")
+	if err := client.Transition(ClientInGame); !errors.Is(err, vetoed) || client.GetState() != ClientLobby {
+		t.Fatalf("expected guard to keep the client in the lobby, got %v in %s", err, client.GetState())
+	}
+	if err := client.Transition(ClientPostGame); err != nil {
+		t.Fatalf("expected lobby → post_game to be allowed, got %v", err)
+	}
+	if err := client.Transition(ClientSpectating); err != nil {
+		t.Fatalf("expected post_game → spectating to be allowed, got %v", err)
+	}
+	if err := client.Transition(ClientWaiting); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected spectating → waiting to be illegal, got %v", err)
+	}
+	if err := client.TransitionFrom(ClientInGame, ClientLobby); err != ErrStateChanged {
+		t.Fatalf("expected ErrStateChanged, got %v", err)
+	}
+
+	client.Transition(ClientLobby)
+	events = nil
+	client.Transition(ClientWaiting)
+	want := []string{"exit lobby", "enter waiting", "event"}
+	if len(events) != len(want) {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, events)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ws
+
+import "log"
+
+// TakeoverPolicy decides what happens when a second socket registers with a
+// session that already has a live client, e.g. the game opened in a new tab
+type TakeoverPolicy string
+
+const (
+	// TakeoverReplace lets the new socket win. The old one is sent
+	// session_replaced and closed.
+	TakeoverReplace TakeoverPolicy = "replace"
+	// TakeoverReject keeps the old socket. The new one is sent
+	// session_conflict and closed.
+	TakeoverReject TakeoverPolicy = "reject"
+	// TakeoverAsk sends the new socket session_conflict and lets it take the
+	// session over with a takeover message
+	TakeoverAsk TakeoverPolicy = "ask"
+)
+
+// ParseTakeoverPolicy validates a policy name. An empty name yields
+// TakeoverReplace.
+func ParseTakeoverPolicy(name string) (TakeoverPolicy, bool) {
+	switch policy := TakeoverPolicy(name); policy {
+	case "":
+		return TakeoverReplace, true
+	case TakeoverReplace, TakeoverReject, TakeoverAsk:
+		return policy, true
+	default:
+		return "", false
+	}
+}
+
+// SetTakeoverPolicy configures how duplicate sessions are handled
+func (h *Hub) SetTakeoverPolicy(policy TakeoverPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.takeover = policy
+}
+
+// Register adds a client to the hub, reporting whether it replaced a live
+// client of the same session. Under TakeoverReject and TakeoverAsk a live
+// client keeps the session and ErrSessionInUse or ErrTakeoverPending is
+// returned. A stopped hub closes the client and returns ErrHubStopped.
+func (h *Hub) Register(client *Client) (bool, error) {
+	h.mu.RLock()
+	stopped, policy := h.stopped, h.takeover
+	h.mu.RUnlock()
+	if stopped {
+		client.Close()
+		return false, ErrHubStopped
+	}
+
+	shard := h.shardFor(client.SessionID)
+	shard.mu.Lock()
+	existing := shard.clients[client.SessionID]
+	live := existing != nil && existing != client && !existing.IsClosed()
+	if live && policy != TakeoverReplace {
+		shard.mu.Unlock()
+		return false, h.refuse(client, policy)
+	}
+	shard.clients[client.SessionID] = client
+	shard.mu.Unlock()
+
+	log.Printf("Client registered: %s", client.SessionID)
+	if live {
+		h.replaced(existing)
+	}
+	return live, nil
+}
+
+// Takeover registers a client in place of the live client of its session,
+// whatever the policy. It answers a session_conflict sent under TakeoverAsk.
+func (h *Hub) Takeover(client *Client) error {
+	h.mu.RLock()
+	stopped := h.stopped
+	h.mu.RUnlock()
+	if stopped {
+		client.Close()
+		return ErrHubStopped
+	}
+
+	shard := h.shardFor(client.SessionID)
+	shard.mu.Lock()
+	existing := shard.clients[client.SessionID]
+	shard.clients[client.SessionID] = client
+	shard.mu.Unlock()
+
+	log.Printf("Client took over session: %s", client.SessionID)
+	if existing != nil && existing != client {
+		h.replaced(existing)
+	}
+	return nil
+}
+
+// replaced tells a superseded client why it is being closed
+func (h *Hub) replaced(old *Client) {
+	msg, err := NewMessage(MsgSessionReplaced, SessionReplacedPayload{})
+	if err != nil {
+		log.Printf("failed to create session_replaced message for session %s: %v", old.SessionID, err)
+	}
+	old.CloseWith(msg)
+}
+
+// refuse answers a client that lost the session to a live one
+func (h *Hub) refuse(client *Client, policy TakeoverPolicy) error {
+	canTakeover := policy == TakeoverAsk
+	msg, err := NewMessage(MsgSessionConflict, SessionConflictPayload{CanTakeover: canTakeover})
+	if err != nil {
+		log.Printf("failed to create session_conflict message for session %s: %v", client.SessionID, err)
+	}
+
+	if canTakeover {
+		if msg != nil {
+			client.SendMessage(msg)
+		}
+		return ErrTakeoverPending
+	}
+	client.CloseWith(msg)
+	return ErrSessionInUse
+}
@@ -0,0 +1,88 @@
+package client
+
+import "memory-feast-online/internal/ws"
+
+// RoomSettings are the settings of an invite room. Zero values leave the
+// server default, or the current setting when updating.
+type RoomSettings = ws.UpdateSettingsPayload
+
+// JoinQueue enters random matchmaking; queue_joined or matched follows
+func (c *Client) JoinQueue(nickname string) error {
+	return c.Send(ws.MsgJoinQueue, ws.JoinQueuePayload{Nickname: nickname, SessionID: c.sessionID})
+}
+
+// CreateRoom opens an invite room; room_created and lobby_state follow
+func (c *Client) CreateRoom(nickname string, settings RoomSettings) error {
+	return c.Send(ws.MsgCreateRoom, ws.CreateRoomPayload{
+		Nickname:    nickname,
+		SessionID:   c.sessionID,
+		PlateCount:  settings.PlateCount,
+		Ruleset:     settings.Ruleset,
+		FirstPlayer: settings.FirstPlayer,
+	})
+}
+
+// JoinRoom takes the free seat of the invite room with code
+func (c *Client) JoinRoom(nickname, code string) error {
+	return c.Send(ws.MsgJoinRoom, ws.JoinRoomPayload{Nickname: nickname, SessionID: c.sessionID, RoomCode: code})
+}
+
+// Spectate watches the running game of the invite room with code
+func (c *Client) Spectate(code string) error {
+	return c.Send(ws.MsgSpectate, ws.SpectatePayload{RoomCode: code})
+}
+
+// Ready sets the client's ready flag in the lobby
+func (c *Client) Ready(ready bool) error {
+	return c.Send(ws.MsgReady, ws.ReadyPayload{Ready: ready})
+}
+
+// UpdateSettings changes the lobby settings; only the host may
+func (c *Client) UpdateSettings(settings RoomSettings) error {
+	return c.Send(ws.MsgUpdateSettings, settings)
+}
+
+// Kick removes the joiner from the lobby; only the host may
+func (c *Client) Kick() error {
+	return c.Send(ws.MsgKickPlayer, ws.KickPlayerPayload{})
+}
+
+// Place puts a token on a plate during placement
+func (c *Client) Place(plate int) error {
+	return c.Send(ws.MsgPlaceToken, ws.PlaceTokenPayload{Index: plate})
+}
+
+// Select toggles a plate during matching
+func (c *Client) Select(plate int) error {
+	return c.Send(ws.MsgSelectPlate, ws.SelectPlatePayload{Index: plate})
+}
+
+// Confirm reveals the two selected plates
+func (c *Client) Confirm() error {
+	return c.Send(ws.MsgConfirmMatch, ws.ConfirmMatchPayload{})
+}
+
+// Add puts one of the client's tokens on a matched plate
+func (c *Client) Add(plate int) error {
+	return c.Send(ws.MsgAddToken, ws.AddTokenPayload{Index: plate})
+}
+
+// Leave leaves the queue, lobby or game
+func (c *Client) Leave() error {
+	return c.Send(ws.MsgLeaveRoom, ws.LeaveRoomPayload{})
+}
+
+// RequestRematch asks the last opponent for another game
+func (c *Client) RequestRematch() error {
+	return c.Send(ws.MsgRematchRequest, ws.RematchRequestPayload{})
+}
+
+// RespondRematch accepts or declines the opponent's rematch request
+func (c *Client) RespondRematch(accept bool) error {
+	return c.Send(ws.MsgRematchResponse, ws.RematchResponsePayload{Accept: accept})
+}
+
+// Takeover moves the session to this client after session_conflict
+func (c *Client) Takeover() error {
+	return c.Send(ws.MsgTakeover, ws.TakeoverPayload{})
+}
@@ -0,0 +1,362 @@
+// Package client is a Go client for the game's WebSocket protocol, for bots,
+// load tests and integration tests. Dial connects, the action methods send
+// client messages, and Events delivers every server message decoded into its
+// payload type. A lost connection is redialed with the same session, which
+// then sends reconnect to take its seat back.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"memory-feast-online/internal/ws"
+)
+
+const (
+	defaultEventBuffer = 64
+	reconnectDelayMin  = 250 * time.Millisecond
+	reconnectDelayMax  = 10 * time.Second
+	writeTimeout       = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned once Close was called or reconnecting gave up
+	ErrClosed = errors.New("client closed")
+	// ErrNotConnected is returned by sends while the client reconnects
+	ErrNotConnected = errors.New("client not connected")
+)
+
+// Options configures a Client; the zero value works
+type Options struct {
+	SessionID string            // Session to play as; a random one when empty
+	Header    http.Header       // Sent with every handshake, e.g. an Origin
+	Dialer    *websocket.Dialer // websocket.DefaultDialer when nil
+
+	NoReconnect          bool // Report a lost connection instead of redialing
+	MaxReconnectAttempts int  // Redials before giving up, 0 for no limit
+
+	EventBuffer int // Events held for a slow reader, 64 when 0
+
+	// Trace, when set, sees every frame as sent or received, before it is
+	// decoded. It runs on the sending or reading goroutine.
+	Trace func(sent bool, frame []byte)
+}
+
+// Event is one server message, or a change of the connection
+type Event struct {
+	Type MessageType
+	// Payload is the decoded payload value: a GameState for game_state, an
+	// Error for error and so on, and Connected or Disconnected for the
+	// connection events. It is nil for messages this client does not know.
+	Payload any
+	Raw     json.RawMessage // Payload as sent; nil for connection events
+}
+
+// Client is one session's connection to the server. Its methods are safe
+// for concurrent use.
+type Client struct {
+	url       string // Endpoint with the session ID
+	opts      Options
+	sessionID string
+	events    chan Event
+
+	ctx    context.Context // Done once the client is closed
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	conn     *websocket.Conn // nil while reconnecting
+	replaced bool            // Another socket took the session over
+	state    *GameState      // Last game_state
+	index    int             // Seat from matched, room_joined or reconnected
+
+	writeMu sync.Mutex // gorilla/websocket allows one writer at a time
+}
+
+// Dial connects to the server's WebSocket endpoint, e.g.
+// ws://localhost:8080/ws. opts may be nil.
+func Dial(ctx context.Context, endpoint string, opts *Options) (*Client, error) {
+	c := &Client{index: -1}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Dialer == nil {
+		c.opts.Dialer = websocket.DefaultDialer
+	}
+	if c.opts.EventBuffer <= 0 {
+		c.opts.EventBuffer = defaultEventBuffer
+	}
+	c.sessionID = c.opts.SessionID
+	if c.sessionID == "" {
+		c.sessionID = newSessionID()
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	query := u.Query()
+	query.Set("sessionId", c.sessionID)
+	u.RawQuery = query.Encode()
+	c.url = u.String()
+
+	conn, _, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", endpoint, err)
+	}
+
+	c.events = make(chan Event, c.opts.EventBuffer)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.conn = conn
+	go c.run(conn)
+	return c, nil
+}
+
+// newSessionID returns a random session ID like the web client's
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SessionID returns the session the client plays as
+func (c *Client) SessionID() string {
+	return c.sessionID
+}
+
+// Events delivers server messages and connection changes in order. It is
+// closed once the client is closed or stops reconnecting. The connection
+// is not read while the buffer is full, so read it promptly.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// State returns the last game state received, or nil
+func (c *Client) State() *GameState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// PlayerIndex returns the client's seat, 0 or 1, or -1 before it has one
+func (c *Client) PlayerIndex() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.index
+}
+
+// WaitFor returns the next event of one of the types, discarding the
+// events before it
+func (c *Client) WaitFor(ctx context.Context, types ...MessageType) (Event, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		case ev, ok := <-c.events:
+			if !ok {
+				return Event{}, ErrClosed
+			}
+			for _, t := range types {
+				if ev.Type == t {
+					return ev, nil
+				}
+			}
+		}
+	}
+}
+
+// Close disconnects for good; Events is closed once the connection is
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	c.writeMu.Lock()
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return conn.Close()
+}
+
+// run reads the connection until it drops, then redials until the client
+// is closed or gives up
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.events)
+
+	c.emit(Event{Type: EventConnected, Payload: Connected{}})
+	for {
+		err := c.read(conn)
+
+		c.mu.Lock()
+		c.conn = nil
+		retry := !c.opts.NoReconnect && !c.replaced
+		c.mu.Unlock()
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.emit(Event{Type: EventDisconnected, Payload: Disconnected{Err: err, Retrying: retry}})
+		if !retry {
+			return
+		}
+		if conn = c.redial(); conn == nil {
+			return
+		}
+		c.emit(Event{Type: EventConnected, Payload: Connected{Resumed: true}})
+	}
+}
+
+// redial connects again with backoff and asks for the session's seat back.
+// It returns nil once the client is closed or out of attempts.
+func (c *Client) redial() *websocket.Conn {
+	delay := reconnectDelayMin
+	for attempt := 1; c.opts.MaxReconnectAttempts == 0 || attempt <= c.opts.MaxReconnectAttempts; attempt++ {
+		// Jitter keeps a fleet of bots from redialing in step
+		wait := delay/2 + time.Duration(mathrand.Int63n(int64(delay)))
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(2*delay, reconnectDelayMax)
+
+		conn, _, err := c.opts.Dialer.DialContext(c.ctx, c.url, c.opts.Header)
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		if c.ctx.Err() != nil {
+			conn.Close()
+			return nil
+		}
+		if err := c.Send(ws.MsgReconnect, ws.ReconnectPayload{SessionID: c.sessionID}); err != nil {
+			conn.Close()
+			continue
+		}
+		return conn
+	}
+
+	c.emit(Event{Type: EventDisconnected, Payload: Disconnected{Err: fmt.Errorf("gave up after %d reconnect attempts", c.opts.MaxReconnectAttempts)}})
+	return nil
+}
+
+// read delivers the connection's messages until it fails
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if c.opts.Trace != nil {
+			c.opts.Trace(false, data)
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		ev := Event{Type: msg.Type, Payload: decodePayload(&msg), Raw: msg.Payload}
+		c.track(ev)
+		if !c.emit(ev) {
+			return ErrClosed
+		}
+	}
+}
+
+// decodePayload decodes a server message into its payload type
+func decodePayload(msg *Message) any {
+	proto, ok := ws.ServerPayloads[msg.Type]
+	if !ok {
+		return nil
+	}
+	payload := reflect.New(reflect.TypeOf(proto))
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, payload.Interface()); err != nil {
+			return nil
+		}
+	}
+	return payload.Elem().Interface()
+}
+
+// track keeps what State and PlayerIndex report
+func (c *Client) track(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch p := ev.Payload.(type) {
+	case GameState:
+		c.state = &p
+	case Matched:
+		c.index = p.PlayerIndex
+	case Reconnected:
+		c.index = p.PlayerIndex
+	case SessionReplaced:
+		c.replaced = true
+	}
+}
+
+// emit hands an event to the reader, reporting false once the client is
+// closed
+func (c *Client) emit(ev Event) bool {
+	select {
+	case c.events <- ev:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// Send sends a client message; the action methods wrap it
+func (c *Client) Send(msgType MessageType, payload any) error {
+	msg, err := ws.NewMessage(msgType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to create %s message: %w", msgType, err)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", msgType, err)
+	}
+
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("failed to send %s message: %w", msgType, err)
+	}
+	if c.opts.Trace != nil {
+		c.opts.Trace(true, data)
+	}
+	return nil
+}
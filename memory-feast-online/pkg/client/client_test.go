@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"memory-feast-online/internal/ws"
+)
+
+// stubServer hands each accepted connection to serve
+func stubServer(t *testing.T, serve func(n int, conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	var accepted atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serve(int(accepted.Add(1)), conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialStub(t *testing.T, endpoint string) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := Dial(ctx, endpoint, &Options{SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientRedialsAndSendsReconnect(t *testing.T) {
+	reconnects := make(chan ws.ReconnectPayload, 1)
+	endpoint := stubServer(t, func(n int, conn *websocket.Conn) {
+		defer conn.Close()
+		if n == 1 {
+			return // Drop the first connection at once
+		}
+		var msg ws.Message
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != ws.MsgReconnect {
+			t.Errorf("expected reconnect first, got %s %v", msg.Type, err)
+			return
+		}
+		var payload ws.ReconnectPayload
+		json.Unmarshal(msg.Payload, &payload)
+		reconnects <- payload
+		conn.ReadMessage()
+	})
+	c := dialStub(t, endpoint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ev, err := c.WaitFor(ctx, EventDisconnected)
+	if err != nil || !ev.Payload.(Disconnected).Retrying {
+		t.Fatalf("expected a retrying disconnect, got %+v %v", ev, err)
+	}
+	if ev, err = c.WaitFor(ctx, EventConnected); err != nil || !ev.Payload.(Connected).Resumed {
+		t.Fatalf("expected a resumed connection, got %+v %v", ev, err)
+	}
+	select {
+	case payload := <-reconnects:
+		if payload.SessionID != "s1" {
+			t.Fatalf("expected reconnect for s1, got %q", payload.SessionID)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected the server to receive reconnect")
+	}
+}
+
+func TestClientStopsAfterSessionReplaced(t *testing.T) {
+	endpoint := stubServer(t, func(n int, conn *websocket.Conn) {
+		defer conn.Close()
+		if n > 1 {
+			t.Error("expected no redial after session_replaced")
+			return
+		}
+		msg, _ := ws.NewMessage(ws.MsgSessionReplaced, ws.SessionReplacedPayload{})
+		conn.WriteJSON(msg)
+	})
+	c := dialStub(t, endpoint)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ev, err := c.WaitFor(ctx, EventDisconnected)
+	if err != nil || ev.Payload.(Disconnected).Retrying {
+		t.Fatalf("expected a final disconnect, got %+v %v", ev, err)
+	}
+	if _, err := c.WaitFor(ctx, EventConnected); err != ErrClosed {
+		t.Fatalf("expected Events closed, got %v", err)
+	}
+	if err := c.Send(ws.MsgReady, ws.ReadyPayload{Ready: true}); err != ErrNotConnected {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import "memory-feast-online/internal/ws"
+
+// The protocol types are the server's own, so the client cannot drift from
+// them. See internal/ws/message.go and GET /protocol/schema.json.
+type (
+	MessageType = ws.MessageType
+	Message     = ws.Message
+
+	GameState       = ws.GameStatePayload
+	PlayerInfo      = ws.PlayerInfo
+	PlateInfo       = ws.PlateInfo
+	GameEnd         = ws.GameEndPayload
+	Error           = ws.ErrorPayload
+	FieldError      = ws.FieldError
+	QueueJoined     = ws.QueueJoinedPayload
+	QueueTimeout    = ws.QueueTimeoutPayload
+	Matched         = ws.MatchedPayload
+	RoomCreated     = ws.RoomCreatedPayload
+	LobbyState      = ws.LobbyStatePayload
+	LobbyPlayer     = ws.LobbyPlayerInfo
+	RoomClosed      = ws.RoomClosedPayload
+	PlayerLeft      = ws.PlayerLeftPayload
+	Reconnected     = ws.ReconnectedPayload
+	RematchOffered  = ws.RematchOfferedPayload
+	RematchDeclined = ws.RematchDeclinedPayload
+	SessionReplaced = ws.SessionReplacedPayload
+	SessionConflict = ws.SessionConflictPayload
+	Spectating      = ws.SpectatingPayload
+)
+
+// Server messages, the Type of an Event
+const (
+	MsgError           = ws.MsgError
+	MsgQueueJoined     = ws.MsgQueueJoined
+	MsgQueueTimeout    = ws.MsgQueueTimeout
+	MsgMatched         = ws.MsgMatched
+	MsgRoomCreated     = ws.MsgRoomCreated
+	MsgRoomJoined      = ws.MsgRoomJoined
+	MsgGameState       = ws.MsgGameState
+	MsgGameEnd         = ws.MsgGameEnd
+	MsgPlayerLeft      = ws.MsgPlayerLeft
+	MsgReconnected     = ws.MsgReconnected
+	MsgLobbyState      = ws.MsgLobbyState
+	MsgRoomClosed      = ws.MsgRoomClosed
+	MsgRematchOffered  = ws.MsgRematchOffered
+	MsgRematchDeclined = ws.MsgRematchDeclined
+	MsgSessionReplaced = ws.MsgSessionReplaced
+	MsgSessionConflict = ws.MsgSessionConflict
+	MsgSpectating      = ws.MsgSpectating
+)
+
+// Events of the connection itself rather than the server. The colon keeps
+// them apart from protocol message types.
+const (
+	EventConnected    MessageType = "client:connected"    // Payload Connected
+	EventDisconnected MessageType = "client:disconnected" // Payload Disconnected
+)
+
+// Connected is the payload of EventConnected
+type Connected struct {
+	Resumed bool // A reconnect, after which the client sent reconnect
+}
+
+// Disconnected is the payload of EventDisconnected
+type Disconnected struct {
+	Err      error
+	Retrying bool // The client is reconnecting
+}
@@ -600,6 +600,10 @@ test.describe('Multi-Player Scenarios', () => {
       await fillRoomCode(page2, roomCode!)
       await page2.locator('.room-code-input button').click()
 
+      // Both players confirm in the pre-game lobby
+      await page1.locator('#lobby-ready-btn').click()
+      await page2.locator('#lobby-ready-btn').click()
+
       // Wait for game state to sync - both should transition to game screen
       await Promise.all([
         expect(page1.locator('#game-screen')).toBeVisible({ timeout: 20000 }),
@@ -645,6 +649,10 @@ test.describe('Multi-Player Scenarios', () => {
       await fillRoomCode(page2, roomCode!)
       await page2.locator('.room-code-input button').click()
 
+      // Both players confirm in the pre-game lobby
+      await page1.locator('#lobby-ready-btn').click()
+      await page2.locator('#lobby-ready-btn').click()
+
       // Both in game
       await Promise.all([
         expect(page1.locator('#game-screen')).toBeVisible({ timeout: 20000 }),
@@ -49,6 +49,10 @@ async function setupMatchingPhase(browser: any): Promise<{
   await page2.locator('#room-code').fill(roomCode);
   await page2.getByRole('button', { name: '참여' }).click();
 
+  // Both players confirm in the pre-game lobby
+  await page1.locator('#lobby-ready-btn').click();
+  await page2.locator('#lobby-ready-btn').click();
+
   // Wait for game to start
   await expect(page1.locator('#game-screen')).toBeVisible({ timeout: 10000 });
   await expect(page2.locator('#game-screen')).toBeVisible({ timeout: 10000 });
@@ -30,6 +30,9 @@ test.describe('Placement Phase Fixes', () => {
     await page2.fill('#room-code', roomCode!);
     await page2.click('button:has-text("참여")');
 
+    await page1.click('#lobby-ready-btn');
+    await page2.click('#lobby-ready-btn');
+
     await page1.waitForSelector('#game-screen', { state: 'visible' });
     await page2.waitForSelector('#game-screen', { state: 'visible' });
 
@@ -184,6 +187,9 @@ test.describe('Add Token Phase Fixes', () => {
     await page2.fill('#room-code', roomCode!);
     await page2.click('button:has-text("참여")');
 
+    await page1.click('#lobby-ready-btn');
+    await page2.click('#lobby-ready-btn');
+
     await page1.waitForSelector('#game-screen', { state: 'visible' });
     await page2.waitForSelector('#game-screen', { state: 'visible' });
 
@@ -35,6 +35,10 @@ test.describe('Token Placement/Addition Animation', () => {
     await page2.fill('#room-code', roomCode!);
     await page2.click('button:has-text("참여")');
 
+    // Both players confirm in the pre-game lobby
+    await page1.click('#lobby-ready-btn');
+    await page2.click('#lobby-ready-btn');
+
     // Wait for game to start (placement phase)
     await page1.waitForSelector('#game-screen', { state: 'visible' });
     await page2.waitForSelector('#game-screen', { state: 'visible' });